@@ -16,6 +16,15 @@ type Aggregate interface {
 	ClearUncommittedEvents()
 }
 
+// Snapshotter is implemented by aggregates whose state can be captured in a snapshot
+type Snapshotter interface {
+	// CreateSnapshot serializes the aggregate state
+	CreateSnapshot() ([]byte, error)
+
+	// RestoreSnapshot restores the aggregate state captured at the given version
+	RestoreSnapshot(version int, state []byte) error
+}
+
 // BaseAggregate provides common functionality for aggregates
 type BaseAggregate struct {
 	id                string
@@ -42,11 +51,22 @@ func (a *BaseAggregate) GetVersion() int {
 	return a.version
 }
 
+// NextVersion returns the version of the next event recorded by a command,
+// following the committed version and the events not saved yet
+func (a *BaseAggregate) NextVersion() int {
+	return a.version + len(a.uncommittedEvents) + 1
+}
+
 // IncrementVersion increments the aggregate version
 func (a *BaseAggregate) IncrementVersion() {
 	a.version++
 }
 
+// restoreVersion sets the aggregate version when restoring from a snapshot
+func (a *BaseAggregate) restoreVersion(version int) {
+	a.version = version
+}
+
 // AddUncommittedEvent adds an event to the uncommitted events list
 func (a *BaseAggregate) AddUncommittedEvent(event *events.Event) {
 	a.uncommittedEvents = append(a.uncommittedEvents, event)
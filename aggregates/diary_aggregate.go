@@ -1,6 +1,7 @@
 package aggregates
 
 import (
+	"encoding/json"
 	"errors"
 	"time"
 
@@ -36,7 +37,7 @@ func (d *DiaryAggregate) CreateEntry(userID, title, content string, tokenCount i
 	event, err := events.NewEvent(
 		events.DiaryEntryCreatedEvent,
 		d.GetID(),
-		d.GetVersion()+1,
+		d.NextVersion(),
 		events.DiaryEntryCreatedPayload{
 			UserID:     userID,
 			Title:      title,
@@ -68,7 +69,7 @@ func (d *DiaryAggregate) UpdateEntry(title, content string, tokenCount int, tags
 	event, err := events.NewEvent(
 		events.DiaryEntryUpdatedEvent,
 		d.GetID(),
-		d.GetVersion()+1,
+		d.NextVersion(),
 		events.DiaryEntryUpdatedPayload{
 			Title:      title,
 			Content:    content,
@@ -98,7 +99,7 @@ func (d *DiaryAggregate) DeleteEntry(reason string) error {
 	event, err := events.NewEvent(
 		events.DiaryEntryDeletedEvent,
 		d.GetID(),
-		d.GetVersion()+1,
+		d.NextVersion(),
 		events.DiaryEntryDeletedPayload{
 			Reason: reason,
 		},
@@ -128,8 +129,8 @@ func (d *DiaryAggregate) ApplyEvent(event *events.Event) error {
 
 // applyDiaryEntryCreated applies the DiaryEntryCreated event
 func (d *DiaryAggregate) applyDiaryEntryCreated(event *events.Event) error {
-	var payload events.DiaryEntryCreatedPayload
-	if err := event.UnmarshalPayload(&payload); err != nil {
+	payload, err := events.PayloadAs[events.DiaryEntryCreatedPayload](event)
+	if err != nil {
 		return err
 	}
 
@@ -146,8 +147,8 @@ func (d *DiaryAggregate) applyDiaryEntryCreated(event *events.Event) error {
 
 // applyDiaryEntryUpdated applies the DiaryEntryUpdated event
 func (d *DiaryAggregate) applyDiaryEntryUpdated(event *events.Event) error {
-	var payload events.DiaryEntryUpdatedPayload
-	if err := event.UnmarshalPayload(&payload); err != nil {
+	payload, err := events.PayloadAs[events.DiaryEntryUpdatedPayload](event)
+	if err != nil {
 		return err
 	}
 
@@ -169,8 +170,7 @@ func (d *DiaryAggregate) applyDiaryEntryUpdated(event *events.Event) error {
 
 // applyDiaryEntryDeleted applies the DiaryEntryDeleted event
 func (d *DiaryAggregate) applyDiaryEntryDeleted(event *events.Event) error {
-	var payload events.DiaryEntryDeletedPayload
-	if err := event.UnmarshalPayload(&payload); err != nil {
+	if _, err := events.PayloadAs[events.DiaryEntryDeletedPayload](event); err != nil {
 		return err
 	}
 
@@ -179,6 +179,48 @@ func (d *DiaryAggregate) applyDiaryEntryDeleted(event *events.Event) error {
 	return nil
 }
 
+// diarySnapshot represents the serialized state of a diary aggregate
+type diarySnapshot struct {
+	UserID     string   `json:"user_id"`
+	Title      string   `json:"title"`
+	Content    string   `json:"content"`
+	TokenCount int      `json:"token_count"`
+	SessionID  string   `json:"session_id"`
+	Tags       []string `json:"tags"`
+	Deleted    bool     `json:"deleted"`
+}
+
+// CreateSnapshot serializes the diary entry state
+func (d *DiaryAggregate) CreateSnapshot() ([]byte, error) {
+	return json.Marshal(diarySnapshot{
+		UserID:     d.userID,
+		Title:      d.title,
+		Content:    d.content,
+		TokenCount: d.tokenCount,
+		SessionID:  d.sessionID,
+		Tags:       d.tags,
+		Deleted:    d.deleted,
+	})
+}
+
+// RestoreSnapshot restores the diary entry state captured at the given version
+func (d *DiaryAggregate) RestoreSnapshot(version int, state []byte) error {
+	var snapshot diarySnapshot
+	if err := json.Unmarshal(state, &snapshot); err != nil {
+		return err
+	}
+
+	d.userID = snapshot.UserID
+	d.title = snapshot.Title
+	d.content = snapshot.Content
+	d.tokenCount = snapshot.TokenCount
+	d.sessionID = snapshot.SessionID
+	d.tags = snapshot.Tags
+	d.deleted = snapshot.Deleted
+	d.restoreVersion(version)
+	return nil
+}
+
 // GetUserID returns the user ID
 func (d *DiaryAggregate) GetUserID() string {
 	return d.userID
@@ -1,6 +1,7 @@
 package aggregates
 
 import (
+	"encoding/json"
 	"errors"
 	"time"
 
@@ -17,6 +18,7 @@ type UserAggregate struct {
 	dateOfBirth string
 	archetype   *events.Archetype
 	modalities  []events.UserModality
+	forgotten   bool
 }
 
 // NewUserAggregate creates a new user aggregate
@@ -29,14 +31,14 @@ func NewUserAggregate(id string) *UserAggregate {
 
 // CreateUser creates a new user
 func (u *UserAggregate) CreateUser(username, email, firstName, lastName, dateOfBirth string) error {
-	if u.username != "" {
+	if u.username != "" || u.forgotten {
 		return errors.New("user already exists")
 	}
 
 	event, err := events.NewEvent(
 		events.UserRegisteredEvent,
 		u.GetID(),
-		u.GetVersion()+1,
+		u.NextVersion(),
 		events.UserRegisteredPayload{
 			Username:    username,
 			Email:       email,
@@ -63,7 +65,7 @@ func (u *UserAggregate) UpdateProfile(firstName, lastName, dateOfBirth, avatar,
 	event, err := events.NewEvent(
 		events.UserProfileUpdatedEvent,
 		u.GetID(),
-		u.GetVersion()+1,
+		u.NextVersion(),
 		events.UserProfileUpdatedPayload{
 			FirstName:   firstName,
 			LastName:    lastName,
@@ -90,7 +92,7 @@ func (u *UserAggregate) AssignArchetype(archetypeID, archetypeName string, confi
 	event, err := events.NewEvent(
 		events.UserArchetypeAssignedEvent,
 		u.GetID(),
-		u.GetVersion()+1,
+		u.NextVersion(),
 		events.UserArchetypeAssignedPayload{
 			ArchetypeID:   archetypeID,
 			ArchetypeName: archetypeName,
@@ -116,7 +118,7 @@ func (u *UserAggregate) UpdateArchetype(archetypeID, archetypeName string, confi
 	event, err := events.NewEvent(
 		events.UserArchetypeUpdatedEvent,
 		u.GetID(),
-		u.GetVersion()+1,
+		u.NextVersion(),
 		events.UserArchetypeUpdatedPayload{
 			ArchetypeID:   archetypeID,
 			ArchetypeName: archetypeName,
@@ -142,7 +144,7 @@ func (u *UserAggregate) UpdateModalities(modalities []events.UserModality) error
 	event, err := events.NewEvent(
 		events.UserModalitiesUpdatedEvent,
 		u.GetID(),
-		u.GetVersion()+1,
+		u.NextVersion(),
 		events.UserModalitiesUpdatedPayload{
 			Modalities: modalities,
 		},
@@ -156,6 +158,32 @@ func (u *UserAggregate) UpdateModalities(modalities []events.UserModality) error
 	return nil
 }
 
+// Forget records that the personal data of the user must be erased
+func (u *UserAggregate) Forget(reason string) error {
+	if u.GetVersion() == 0 {
+		return errors.New("user does not exist")
+	}
+	if u.forgotten {
+		return errors.New("user already forgotten")
+	}
+
+	event, err := events.NewEvent(
+		events.UserForgottenEvent,
+		u.GetID(),
+		u.NextVersion(),
+		events.UserForgottenPayload{
+			Reason: reason,
+		},
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	u.AddUncommittedEvent(event)
+	return nil
+}
+
 // ApplyEvent applies an event to the aggregate
 func (u *UserAggregate) ApplyEvent(event *events.Event) error {
 	switch event.Type {
@@ -169,6 +197,8 @@ func (u *UserAggregate) ApplyEvent(event *events.Event) error {
 		return u.applyUserArchetypeUpdated(event)
 	case events.UserModalitiesUpdatedEvent:
 		return u.applyUserModalitiesUpdated(event)
+	case events.UserForgottenEvent:
+		return u.applyUserForgotten(event)
 	default:
 		return errors.New("unknown event type")
 	}
@@ -176,8 +206,8 @@ func (u *UserAggregate) ApplyEvent(event *events.Event) error {
 
 // applyUserRegistered applies the UserRegistered event
 func (u *UserAggregate) applyUserRegistered(event *events.Event) error {
-	var payload events.UserRegisteredPayload
-	if err := event.UnmarshalPayload(&payload); err != nil {
+	payload, err := events.PayloadAs[events.UserRegisteredPayload](event)
+	if err != nil {
 		return err
 	}
 
@@ -192,8 +222,8 @@ func (u *UserAggregate) applyUserRegistered(event *events.Event) error {
 
 // applyUserProfileUpdated applies the UserProfileUpdated event
 func (u *UserAggregate) applyUserProfileUpdated(event *events.Event) error {
-	var payload events.UserProfileUpdatedPayload
-	if err := event.UnmarshalPayload(&payload); err != nil {
+	payload, err := events.PayloadAs[events.UserProfileUpdatedPayload](event)
+	if err != nil {
 		return err
 	}
 
@@ -212,8 +242,8 @@ func (u *UserAggregate) applyUserProfileUpdated(event *events.Event) error {
 
 // applyUserArchetypeAssigned applies the UserArchetypeAssigned event
 func (u *UserAggregate) applyUserArchetypeAssigned(event *events.Event) error {
-	var payload events.UserArchetypeAssignedPayload
-	if err := event.UnmarshalPayload(&payload); err != nil {
+	payload, err := events.PayloadAs[events.UserArchetypeAssignedPayload](event)
+	if err != nil {
 		return err
 	}
 
@@ -229,8 +259,8 @@ func (u *UserAggregate) applyUserArchetypeAssigned(event *events.Event) error {
 
 // applyUserArchetypeUpdated applies the UserArchetypeUpdated event
 func (u *UserAggregate) applyUserArchetypeUpdated(event *events.Event) error {
-	var payload events.UserArchetypeUpdatedPayload
-	if err := event.UnmarshalPayload(&payload); err != nil {
+	payload, err := events.PayloadAs[events.UserArchetypeUpdatedPayload](event)
+	if err != nil {
 		return err
 	}
 
@@ -246,8 +276,8 @@ func (u *UserAggregate) applyUserArchetypeUpdated(event *events.Event) error {
 
 // applyUserModalitiesUpdated applies the UserModalitiesUpdated event
 func (u *UserAggregate) applyUserModalitiesUpdated(event *events.Event) error {
-	var payload events.UserModalitiesUpdatedPayload
-	if err := event.UnmarshalPayload(&payload); err != nil {
+	payload, err := events.PayloadAs[events.UserModalitiesUpdatedPayload](event)
+	if err != nil {
 		return err
 	}
 
@@ -256,11 +286,80 @@ func (u *UserAggregate) applyUserModalitiesUpdated(event *events.Event) error {
 	return nil
 }
 
+// applyUserForgotten applies the UserForgotten event
+func (u *UserAggregate) applyUserForgotten(event *events.Event) error {
+	if _, err := events.PayloadAs[events.UserForgottenPayload](event); err != nil {
+		return err
+	}
+
+	u.username = ""
+	u.email = ""
+	u.firstName = ""
+	u.lastName = ""
+	u.dateOfBirth = ""
+	u.forgotten = true
+	u.IncrementVersion()
+	return nil
+}
+
+// userSnapshot represents the serialized state of a user aggregate
+type userSnapshot struct {
+	Username    string                `json:"username"`
+	Email       string                `json:"email"`
+	FirstName   string                `json:"first_name"`
+	LastName    string                `json:"last_name"`
+	DateOfBirth string                `json:"date_of_birth,omitempty"`
+	Archetype   *events.Archetype     `json:"archetype,omitempty"`
+	Modalities  []events.UserModality `json:"modalities"`
+	Forgotten   bool                  `json:"forgotten,omitempty"`
+}
+
+// CreateSnapshot serializes the user state
+func (u *UserAggregate) CreateSnapshot() ([]byte, error) {
+	return json.Marshal(userSnapshot{
+		Username:    u.username,
+		Email:       u.email,
+		FirstName:   u.firstName,
+		LastName:    u.lastName,
+		DateOfBirth: u.dateOfBirth,
+		Archetype:   u.archetype,
+		Modalities:  u.modalities,
+		Forgotten:   u.forgotten,
+	})
+}
+
+// RestoreSnapshot restores the user state captured at the given version
+func (u *UserAggregate) RestoreSnapshot(version int, state []byte) error {
+	var snapshot userSnapshot
+	if err := json.Unmarshal(state, &snapshot); err != nil {
+		return err
+	}
+
+	u.username = snapshot.Username
+	u.email = snapshot.Email
+	u.firstName = snapshot.FirstName
+	u.lastName = snapshot.LastName
+	u.dateOfBirth = snapshot.DateOfBirth
+	u.archetype = snapshot.Archetype
+	u.modalities = snapshot.Modalities
+	u.forgotten = snapshot.Forgotten
+	if u.modalities == nil {
+		u.modalities = make([]events.UserModality, 0)
+	}
+	u.restoreVersion(version)
+	return nil
+}
+
 // GetUsername returns the username
 func (u *UserAggregate) GetUsername() string {
 	return u.username
 }
 
+// IsForgotten reports whether the personal data of the user was erased
+func (u *UserAggregate) IsForgotten() bool {
+	return u.forgotten
+}
+
 // GetEmail returns the email
 func (u *UserAggregate) GetEmail() string {
 	return u.email
@@ -0,0 +1,311 @@
+package bus
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kegazani/metachat-event-sourcing/events"
+	"github.com/nats-io/nats.go"
+)
+
+// CloudEventsMode selects how NATSEventBus wraps published events in CloudEvents 1.0
+type CloudEventsMode int
+
+const (
+	// CloudEventsDisabled publishes events with the bus serializer
+	CloudEventsDisabled CloudEventsMode = iota
+
+	// CloudEventsStructured publishes the whole CloudEvent as a JSON body
+	CloudEventsStructured
+
+	// CloudEventsBinary publishes the payload as body and the attributes as ce- headers
+	CloudEventsBinary
+)
+
+const (
+	cloudEventsSpecVersion  = "1.0"
+	cloudEventsContentType  = "application/cloudevents+json"
+	cloudEventsHeaderPrefix = "ce-"
+	cloudEventsDataType     = "application/json"
+)
+
+// Extension attributes carrying the event fields without a standard CloudEvents attribute
+const (
+	extensionAggregateType    = "aggregatetype"
+	extensionAggregateVersion = "aggregateversion"
+	extensionSchemaVersion    = "schemaversion"
+	extensionPosition         = "position"
+
+	// extensionMetadata holds the whole metadata JSON encoded, so keys and
+	// value types that extension names and header values cannot carry, such as
+	// encryption_subject, survive a round trip
+	extensionMetadata = "metadata"
+)
+
+// metadataExtensions maps the extension names of well-known metadata keys back
+// to the keys, since extension names cannot hold underscores. It is only used
+// for CloudEvents published without the metadata extension.
+var metadataExtensions = map[string]string{
+	"correlationid": "correlation_id",
+	"causationid":   "causation_id",
+	"userid":        "user_id",
+}
+
+// cloudEventAttribute is a CloudEvents context or extension attribute
+type cloudEventAttribute struct {
+	name  string
+	value interface{}
+}
+
+// cloudEventAttributes maps an event to CloudEvents attributes. Metadata entries
+// become extensions named after their key in lower case without other characters
+// than letters and digits, for consumers reading them as attributes; the event
+// fields override metadata with the same name. The metadata extension holds
+// the exact metadata for decodeCloudEvent.
+func cloudEventAttributes(event *events.Event, source string) []cloudEventAttribute {
+	attributes := []cloudEventAttribute{
+		{name: "specversion", value: cloudEventsSpecVersion},
+		{name: "id", value: event.ID},
+		{name: "source", value: source},
+		{name: "type", value: string(event.Type)},
+		{name: "subject", value: event.AggregateID},
+		{name: "time", value: event.Timestamp.UTC().Format(time.RFC3339Nano)},
+		{name: "datacontenttype", value: cloudEventsDataType},
+	}
+
+	reserved := map[string]bool{extensionAggregateType: true, extensionAggregateVersion: true, extensionSchemaVersion: true, extensionPosition: true, extensionMetadata: true}
+	for _, attribute := range attributes {
+		reserved[attribute.name] = true
+	}
+
+	for key, value := range event.Metadata {
+		name := extensionName(key)
+		if name == "" || reserved[name] {
+			continue
+		}
+		attributes = append(attributes, cloudEventAttribute{name: name, value: extensionValue(value)})
+	}
+
+	if event.AggregateType != "" {
+		attributes = append(attributes, cloudEventAttribute{name: extensionAggregateType, value: string(event.AggregateType)})
+	}
+	attributes = append(attributes, cloudEventAttribute{name: extensionAggregateVersion, value: event.Version})
+	if event.SchemaVersion != 0 {
+		attributes = append(attributes, cloudEventAttribute{name: extensionSchemaVersion, value: event.SchemaVersion})
+	}
+	if event.Position != 0 {
+		attributes = append(attributes, cloudEventAttribute{name: extensionPosition, value: event.Position})
+	}
+	if len(event.Metadata) > 0 {
+		if metadata, err := json.Marshal(event.Metadata); err == nil {
+			attributes = append(attributes, cloudEventAttribute{name: extensionMetadata, value: string(metadata)})
+		}
+	}
+
+	return attributes
+}
+
+// extensionName turns a metadata key into a valid extension attribute name
+func extensionName(key string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(key) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// extensionValue converts a metadata value to a CloudEvents type: strings,
+// booleans and integers are kept, other values are JSON encoded as strings
+func extensionValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string, bool, int, int32, int64:
+		return v
+	case float64:
+		if v == float64(int64(v)) {
+			return int64(v)
+		}
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(encoded)
+}
+
+// encodeCloudEvent writes an event into a message in the given mode
+func encodeCloudEvent(msg *nats.Msg, event *events.Event, mode CloudEventsMode, source string) error {
+	attributes := cloudEventAttributes(event, source)
+
+	if mode == CloudEventsBinary {
+		for _, attribute := range attributes {
+			if attribute.name == "datacontenttype" {
+				msg.Header.Set(contentTypeHeader, fmt.Sprint(attribute.value))
+				continue
+			}
+			msg.Header.Set(cloudEventsHeaderPrefix+attribute.name, fmt.Sprint(attribute.value))
+		}
+		msg.Data = event.Payload
+		return nil
+	}
+
+	envelope := make(map[string]interface{}, len(attributes)+1)
+	for _, attribute := range attributes {
+		envelope[attribute.name] = attribute.value
+	}
+	if len(event.Payload) > 0 {
+		envelope["data"] = event.Payload
+	}
+
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("failed to marshal CloudEvent: %w", err)
+	}
+
+	msg.Header.Set(contentTypeHeader, cloudEventsContentType)
+	msg.Data = data
+	return nil
+}
+
+// isCloudEvent reports whether a message holds a CloudEvent in either mode
+func isCloudEvent(msg *nats.Msg) bool {
+	if msg.Header.Get(cloudEventsHeaderPrefix+"specversion") != "" {
+		return true
+	}
+	return strings.HasPrefix(msg.Header.Get(contentTypeHeader), cloudEventsContentType)
+}
+
+// decodeCloudEvent reads an event from a CloudEvents message in either mode
+func decodeCloudEvent(msg *nats.Msg) (*events.Event, error) {
+	attributes := make(map[string]interface{})
+	var payload json.RawMessage
+
+	if specVersion := msg.Header.Get(cloudEventsHeaderPrefix + "specversion"); specVersion != "" {
+		for name, values := range msg.Header {
+			name = strings.ToLower(name)
+			if strings.HasPrefix(name, cloudEventsHeaderPrefix) && len(values) > 0 {
+				attributes[strings.TrimPrefix(name, cloudEventsHeaderPrefix)] = values[0]
+			}
+		}
+		payload = msg.Data
+	} else {
+		var envelope map[string]json.RawMessage
+		if err := json.Unmarshal(msg.Data, &envelope); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal CloudEvent: %w", err)
+		}
+
+		for name, raw := range envelope {
+			switch name {
+			case "data":
+				payload = raw
+			case "data_base64":
+				var encoded string
+				if err := json.Unmarshal(raw, &encoded); err != nil {
+					return nil, fmt.Errorf("invalid CloudEvent data_base64: %w", err)
+				}
+				decoded, err := base64.StdEncoding.DecodeString(encoded)
+				if err != nil {
+					return nil, fmt.Errorf("invalid CloudEvent data_base64: %w", err)
+				}
+				payload = decoded
+			default:
+				var value interface{}
+				if err := json.Unmarshal(raw, &value); err != nil {
+					return nil, fmt.Errorf("invalid CloudEvent attribute %s: %w", name, err)
+				}
+				attributes[name] = value
+			}
+		}
+	}
+
+	if specVersion := attributeString(attributes["specversion"]); specVersion != cloudEventsSpecVersion {
+		return nil, fmt.Errorf("unsupported CloudEvents spec version %q", specVersion)
+	}
+
+	event := &events.Event{
+		ID:          attributeString(attributes["id"]),
+		Type:        events.EventType(attributeString(attributes["type"])),
+		AggregateID: attributeString(attributes["subject"]),
+		Payload:     payload,
+		Metadata:    make(map[string]interface{}),
+	}
+
+	if value := attributeString(attributes["time"]); value != "" {
+		timestamp, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CloudEvent time: %w", err)
+		}
+		event.Timestamp = timestamp
+	}
+
+	event.AggregateType = events.AggregateType(attributeString(attributes[extensionAggregateType]))
+	if event.AggregateType == "" {
+		event.AggregateType = events.AggregateTypeOf(event.Type)
+	}
+
+	var err error
+	if event.Version, err = attributeInt(attributes[extensionAggregateVersion]); err != nil {
+		return nil, fmt.Errorf("invalid CloudEvent %s: %w", extensionAggregateVersion, err)
+	}
+	if event.SchemaVersion, err = attributeInt(attributes[extensionSchemaVersion]); err != nil {
+		return nil, fmt.Errorf("invalid CloudEvent %s: %w", extensionSchemaVersion, err)
+	}
+	position, err := attributeInt(attributes[extensionPosition])
+	if err != nil {
+		return nil, fmt.Errorf("invalid CloudEvent %s: %w", extensionPosition, err)
+	}
+	event.Position = uint64(position)
+
+	if metadata := attributeString(attributes[extensionMetadata]); metadata != "" {
+		if err := json.Unmarshal([]byte(metadata), &event.Metadata); err != nil {
+			return nil, fmt.Errorf("invalid CloudEvent %s: %w", extensionMetadata, err)
+		}
+		return event, nil
+	}
+
+	for name, value := range attributes {
+		switch name {
+		case "specversion", "id", "source", "type", "subject", "time", "datacontenttype", "dataschema",
+			extensionAggregateType, extensionAggregateVersion, extensionSchemaVersion, extensionPosition:
+			continue
+		}
+
+		if key, ok := metadataExtensions[name]; ok {
+			name = key
+		}
+		event.Metadata[name] = value
+	}
+
+	return event, nil
+}
+
+// attributeString returns a string attribute, or an empty string if it is missing
+func attributeString(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return fmt.Sprint(value)
+}
+
+// attributeInt returns an integer attribute received as a header string or a JSON number, 0 if it is missing
+func attributeInt(value interface{}) (int, error) {
+	switch v := value.(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		return int(v), nil
+	case string:
+		return strconv.Atoi(v)
+	default:
+		return 0, fmt.Errorf("unexpected value %v", value)
+	}
+}
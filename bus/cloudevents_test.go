@@ -0,0 +1,90 @@
+package bus
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kegazani/metachat-event-sourcing/events"
+	"github.com/nats-io/nats.go"
+)
+
+// newCloudEventTestEvent creates an event with metadata keys and values extension attributes cannot carry
+func newCloudEventTestEvent(t *testing.T) *events.Event {
+	t.Helper()
+
+	event, err := events.NewEvent(events.DiaryEntryCreatedEvent, "diary-1", 1, events.DiaryEntryCreatedPayload{
+		UserID:  "user-1",
+		Title:   "enc:v1:c2VhbGVk",
+		Content: "enc:v1:Ym9keQ",
+	}, map[string]interface{}{
+		"correlation_id":     "correlation-1",
+		"encryption_subject": "user-1",
+		"attempt":            float64(3),
+		"replayed":           true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create event: %v", err)
+	}
+	event.Timestamp = time.Date(2026, 3, 14, 9, 26, 53, 0, time.UTC)
+	event.Position = 42
+	return event
+}
+
+func TestCloudEventRoundTrip(t *testing.T) {
+	for _, mode := range []CloudEventsMode{CloudEventsStructured, CloudEventsBinary} {
+		event := newCloudEventTestEvent(t)
+
+		msg := nats.NewMsg("events.DiaryEntryCreated")
+		if err := encodeCloudEvent(msg, event, mode, "metachat"); err != nil {
+			t.Fatalf("mode %d: failed to encode: %v", mode, err)
+		}
+		if !isCloudEvent(msg) {
+			t.Fatalf("mode %d: expected the message to be recognized as a CloudEvent", mode)
+		}
+
+		decoded, err := decodeCloudEvent(msg)
+		if err != nil {
+			t.Fatalf("mode %d: failed to decode: %v", mode, err)
+		}
+
+		if decoded.ID != event.ID || decoded.Type != event.Type || decoded.AggregateID != event.AggregateID ||
+			decoded.AggregateType != event.AggregateType || decoded.Version != event.Version ||
+			decoded.SchemaVersion != event.SchemaVersion || decoded.Position != event.Position ||
+			!decoded.Timestamp.Equal(event.Timestamp) {
+			t.Fatalf("mode %d: decoded event %+v does not match %+v", mode, decoded, event)
+		}
+		if string(decoded.Payload) != string(event.Payload) {
+			t.Fatalf("mode %d: expected payload %s, got %s", mode, event.Payload, decoded.Payload)
+		}
+		if !reflect.DeepEqual(decoded.Metadata, event.Metadata) {
+			t.Fatalf("mode %d: expected metadata %v, got %v", mode, event.Metadata, decoded.Metadata)
+		}
+	}
+}
+
+func TestCloudEventWithoutMetadataExtension(t *testing.T) {
+	// A binary CloudEvent from another producer carries metadata as plain extensions
+	msg := nats.NewMsg("events.DiaryEntryDeleted")
+	msg.Header.Set("ce-specversion", "1.0")
+	msg.Header.Set("ce-id", "event-1")
+	msg.Header.Set("ce-type", string(events.DiaryEntryDeletedEvent))
+	msg.Header.Set("ce-subject", "diary-1")
+	msg.Header.Set("ce-aggregateversion", "2")
+	msg.Header.Set("ce-correlationid", "correlation-1")
+	msg.Header.Set("ce-tenant", "acme")
+	msg.Data = []byte(`{"reason":"duplicate"}`)
+
+	decoded, err := decodeCloudEvent(msg)
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+
+	want := map[string]interface{}{"correlation_id": "correlation-1", "tenant": "acme"}
+	if !reflect.DeepEqual(decoded.Metadata, want) {
+		t.Fatalf("expected metadata %v, got %v", want, decoded.Metadata)
+	}
+	if decoded.Version != 2 || decoded.AggregateType != events.DiaryAggregateType {
+		t.Fatalf("expected version 2 of a diary aggregate, got %d of %s", decoded.Version, decoded.AggregateType)
+	}
+}
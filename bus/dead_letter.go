@@ -0,0 +1,290 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kegazani/metachat-event-sourcing/events"
+	"github.com/nats-io/nats.go"
+)
+
+// deadLetterStreamName is the JetStream stream holding dead-lettered messages
+const deadLetterStreamName = "EVENTS_DEAD_LETTER"
+
+// Headers added to dead-lettered messages
+const (
+	HeaderDeadLetterReason     = "Dead-Letter-Reason"
+	HeaderDeadLetterSubject    = "Dead-Letter-Subject"
+	HeaderDeadLetterConsumer   = "Dead-Letter-Consumer"
+	HeaderDeadLetterDeliveries = "Dead-Letter-Deliveries"
+	HeaderDeadLetterTime       = "Dead-Letter-Time"
+)
+
+// ErrDeadLetterNotFound is returned when a dead letter does not exist
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// RetryPolicy controls the redelivery of messages whose handler fails.
+// A message is redelivered after InitialDelay, multiplied by Multiplier on each
+// further attempt up to MaxDelay, and is dead-lettered once it has been
+// delivered MaxDeliver times, including deliveries whose handler did not
+// finish within the ack wait. Messages that cannot be decoded are dead-lettered at once.
+type RetryPolicy struct {
+	MaxDeliver   int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+}
+
+// DefaultRetryPolicy is the retry policy of a new NATSEventBus
+var DefaultRetryPolicy = RetryPolicy{
+	MaxDeliver:   5,
+	InitialDelay: time.Second,
+	MaxDelay:     5 * time.Minute,
+	Multiplier:   2,
+}
+
+// Delay returns the redelivery delay after the given number of deliveries
+func (p RetryPolicy) Delay(deliveries int) time.Duration {
+	if deliveries < 1 {
+		deliveries = 1
+	}
+
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	delay := float64(p.InitialDelay) * math.Pow(multiplier, float64(deliveries-1))
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		return p.MaxDelay
+	}
+	return time.Duration(delay)
+}
+
+// DeadLetter is a message that could not be handled
+type DeadLetter struct {
+	// Sequence identifies the dead letter in the dead-letter stream
+	Sequence uint64
+
+	// Subject is the subject the message was originally published on
+	Subject    string
+	Reason     string
+	Consumer   string
+	Deliveries int
+	Time       time.Time
+
+	// Headers and Data are the original message
+	Headers Headers
+	Data    []byte
+
+	// Event is the decoded event, nil if the message could not be decoded
+	Event *events.Event
+}
+
+// deadLetterSubject returns the dead-letter subject of an original subject
+func deadLetterSubject(subject string) string {
+	return "deadletter." + subject
+}
+
+// maxDeliveriesAdvisory is the advisory JetStream publishes when a message
+// reached the MaxDeliver of a consumer without being acknowledged
+type maxDeliveriesAdvisory struct {
+	Stream     string `json:"stream"`
+	Consumer   string `json:"consumer"`
+	StreamSeq  uint64 `json:"stream_seq"`
+	Deliveries int    `json:"deliveries"`
+}
+
+// maxDeliveriesSubject returns the subject of the max deliveries advisories of a consumer of the event stream
+func maxDeliveriesSubject(consumer string) string {
+	return "$JS.EVENT.ADVISORY.CONSUMER.MAX_DELIVERIES." + eventStreamName + "." + consumer
+}
+
+// deadLetter moves a message to the dead-letter stream and terminates its delivery
+func (b *NATSEventBus) deadLetter(msg *nats.Msg, consumer string, reason error) {
+	deliveries := 1
+	if meta, err := msg.Metadata(); err == nil {
+		deliveries = int(meta.NumDelivered)
+	}
+
+	// Without a dead-letter copy the message is left for redelivery rather than lost
+	if err := b.publishDeadLetter(msg, consumer, deliveries, reason); err != nil {
+		msg.Nak()
+		return
+	}
+
+	msg.Term()
+}
+
+// deadLetterExhausted dead-letters the message of a max deliveries advisory.
+// The server stops delivering a message once it reached MaxDeliver, so a
+// message whose handler did not finish within the ack wait on its last
+// delivery, or whose dead-letter copy failed, is only known from the advisory.
+// Advisories are not persisted: messages exhausted while no subscriber of the
+// consumer is connected are not dead-lettered.
+func (b *NATSEventBus) deadLetterExhausted(advisoryMsg *nats.Msg) {
+	var advisory maxDeliveriesAdvisory
+	if err := json.Unmarshal(advisoryMsg.Data, &advisory); err != nil {
+		return
+	}
+
+	raw, err := b.js.GetMsg(advisory.Stream, advisory.StreamSeq)
+	if err != nil {
+		return
+	}
+
+	msg := &nats.Msg{Subject: raw.Subject, Header: raw.Header, Data: raw.Data}
+	reason := fmt.Errorf("not acknowledged after %d deliveries", advisory.Deliveries)
+	b.publishDeadLetter(msg, advisory.Consumer, advisory.Deliveries, reason)
+}
+
+// publishDeadLetter copies a message to the dead-letter stream. The copy is
+// deduplicated per message and consumer, so a message dead-lettered both from
+// its handler and from an advisory is stored once.
+func (b *NATSEventBus) publishDeadLetter(msg *nats.Msg, consumer string, deliveries int, reason error) error {
+	dead := nats.NewMsg(deadLetterSubject(msg.Subject))
+	dead.Data = msg.Data
+	for name, values := range msg.Header {
+		dead.Header[name] = values
+	}
+	dead.Header.Set(nats.MsgIdHdr, msg.Header.Get(nats.MsgIdHdr)+"/"+consumer)
+	dead.Header.Set(HeaderDeadLetterReason, reason.Error())
+	dead.Header.Set(HeaderDeadLetterSubject, msg.Subject)
+	dead.Header.Set(HeaderDeadLetterConsumer, consumer)
+	dead.Header.Set(HeaderDeadLetterDeliveries, strconv.Itoa(deliveries))
+	dead.Header.Set(HeaderDeadLetterTime, time.Now().UTC().Format(time.RFC3339Nano))
+
+	_, err := b.js.PublishMsg(dead)
+	return err
+}
+
+// retry schedules the redelivery of a message whose handler failed, or
+// dead-letters it once the retry policy allows no more deliveries
+func (b *NATSEventBus) retry(msg *nats.Msg, consumer string, policy RetryPolicy, reason error) {
+	meta, err := msg.Metadata()
+	if err != nil {
+		msg.Nak()
+		return
+	}
+
+	deliveries := int(meta.NumDelivered)
+	if policy.MaxDeliver > 0 && deliveries >= policy.MaxDeliver {
+		b.deadLetter(msg, consumer, fmt.Errorf("gave up after %d deliveries: %w", deliveries, reason))
+		return
+	}
+
+	msg.NakWithDelay(policy.Delay(deliveries))
+}
+
+// ListDeadLetters returns up to limit dead letters, oldest first, or all of them if limit is 0
+func (b *NATSEventBus) ListDeadLetters(ctx context.Context, limit int) ([]*DeadLetter, error) {
+	info, err := b.js.StreamInfo(deadLetterStreamName, nats.Context(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dead-letter stream: %w", err)
+	}
+
+	deadLetters := make([]*DeadLetter, 0)
+	if info.State.Msgs == 0 {
+		return deadLetters, nil
+	}
+
+	for sequence := info.State.FirstSeq; sequence <= info.State.LastSeq; sequence++ {
+		if limit > 0 && len(deadLetters) >= limit {
+			break
+		}
+
+		deadLetter, err := b.GetDeadLetter(ctx, sequence)
+		if errors.Is(err, ErrDeadLetterNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		deadLetters = append(deadLetters, deadLetter)
+	}
+
+	return deadLetters, nil
+}
+
+// GetDeadLetter returns a dead letter by sequence
+func (b *NATSEventBus) GetDeadLetter(ctx context.Context, sequence uint64) (*DeadLetter, error) {
+	raw, err := b.js.GetMsg(deadLetterStreamName, sequence, nats.Context(ctx))
+	if errors.Is(err, nats.ErrMsgNotFound) {
+		return nil, fmt.Errorf("%w: %d", ErrDeadLetterNotFound, sequence)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dead letter: %w", err)
+	}
+
+	msg := &nats.Msg{Subject: raw.Subject, Header: raw.Header, Data: raw.Data}
+	deadLetter := &DeadLetter{
+		Sequence: raw.Sequence,
+		Subject:  raw.Header.Get(HeaderDeadLetterSubject),
+		Reason:   raw.Header.Get(HeaderDeadLetterReason),
+		Consumer: raw.Header.Get(HeaderDeadLetterConsumer),
+		Headers:  headersOf(msg),
+		Data:     raw.Data,
+	}
+	if deadLetter.Subject == "" {
+		deadLetter.Subject = strings.TrimPrefix(raw.Subject, deadLetterSubject(""))
+	}
+	deadLetter.Deliveries, _ = strconv.Atoi(raw.Header.Get(HeaderDeadLetterDeliveries))
+	deadLetter.Time, _ = time.Parse(time.RFC3339Nano, raw.Header.Get(HeaderDeadLetterTime))
+
+	if event, err := b.decodeMessage(msg); err == nil {
+		deadLetter.Event = event
+	}
+
+	return deadLetter, nil
+}
+
+// ReplayDeadLetter republishes a dead letter on its original subject and removes
+// it from the dead-letter stream. Every consumer of the subject receives it again.
+func (b *NATSEventBus) ReplayDeadLetter(ctx context.Context, sequence uint64) error {
+	raw, err := b.js.GetMsg(deadLetterStreamName, sequence, nats.Context(ctx))
+	if errors.Is(err, nats.ErrMsgNotFound) {
+		return fmt.Errorf("%w: %d", ErrDeadLetterNotFound, sequence)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read dead letter: %w", err)
+	}
+
+	subject := raw.Header.Get(HeaderDeadLetterSubject)
+	if subject == "" {
+		return fmt.Errorf("dead letter %d has no original subject", sequence)
+	}
+
+	msg := nats.NewMsg(subject)
+	msg.Data = raw.Data
+	for name, values := range raw.Header {
+		if !strings.HasPrefix(name, "Dead-Letter-") {
+			msg.Header[name] = values
+		}
+	}
+	// The original ID is still in the duplicate window of the event stream for recent messages
+	msg.Header.Set(nats.MsgIdHdr, fmt.Sprintf("%s/replay-%d", raw.Header.Get(nats.MsgIdHdr), sequence))
+
+	if _, err := b.js.PublishMsg(msg, nats.Context(ctx)); err != nil {
+		return fmt.Errorf("failed to replay dead letter: %w", err)
+	}
+
+	return b.DeleteDeadLetter(ctx, sequence)
+}
+
+// DeleteDeadLetter removes a dead letter from the dead-letter stream
+func (b *NATSEventBus) DeleteDeadLetter(ctx context.Context, sequence uint64) error {
+	err := b.js.DeleteMsg(deadLetterStreamName, sequence, nats.Context(ctx))
+	if errors.Is(err, nats.ErrMsgNotFound) {
+		return fmt.Errorf("%w: %d", ErrDeadLetterNotFound, sequence)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete dead letter: %w", err)
+	}
+	return nil
+}
@@ -2,29 +2,61 @@ package bus
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
 
 	"github.com/kegazani/metachat-event-sourcing/events"
+	"github.com/kegazani/metachat-event-sourcing/serializer"
 	"github.com/nats-io/nats.go"
 )
 
+// contentTypeHeader is the message header naming the serializer of the event
+const contentTypeHeader = "Content-Type"
+
+// eventStreamName is the JetStream stream holding published events
+const eventStreamName = "EVENTS"
+
 type EventBus interface {
 	Publish(ctx context.Context, event *events.Event) error
-	Subscribe(eventType events.EventType, handler EventHandler) error
+	// Subscribe handles the events of a type, or of the types matching a glob pattern such as Diary*
+	Subscribe(eventType events.EventType, handler EventHandler, options ...SubscribeOption) (Subscription, error)
+
+	// SubscribeMany handles the events of several types or patterns with one handler
+	SubscribeMany(eventTypes []events.EventType, handler EventHandler, options ...SubscribeOption) (Subscription, error)
+
 	Close() error
 }
 
 type EventHandler func(ctx context.Context, event *events.Event) error
 
 type NATSEventBus struct {
-	conn    *nats.Conn
-	js      nats.JetStreamContext
-	subs    map[events.EventType]*nats.Subscription
-	subject string
+	conn      *nats.Conn
+	js        nats.JetStreamContext
+	mu        sync.Mutex
+	subs      map[*natsSubscription]struct{}
+	subject   string
+	upcasters *events.UpcasterRegistry
+
+	serializer  serializer.Serializer
+	serializers *serializer.Registry
+
+	cloudEvents       CloudEventsMode
+	cloudEventsSource string
+
+	retryPolicy RetryPolicy
 }
 
+// NewNATSEventBus creates a JetStream event bus publishing events as JSON
 func NewNATSEventBus(url, subject string) (*NATSEventBus, error) {
+	return NewNATSEventBusWithSerializer(url, subject, nil)
+}
+
+// NewNATSEventBusWithSerializer creates a JetStream event bus publishing events
+// with eventSerializer, or JSON if it is nil. Received events are decoded with
+// the serializer named by their Content-Type header.
+func NewNATSEventBusWithSerializer(url, subject string, eventSerializer serializer.Serializer) (*NATSEventBus, error) {
 	conn, err := nats.Connect(url)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
@@ -36,37 +68,84 @@ func NewNATSEventBus(url, subject string) (*NATSEventBus, error) {
 		return nil, fmt.Errorf("failed to create JetStream context: %w", err)
 	}
 
-	streamName := "EVENTS"
-	_, err = js.StreamInfo(streamName)
-	if err == nats.ErrStreamNotFound {
-		_, err = js.AddStream(&nats.StreamConfig{
-			Name:     streamName,
-			Subjects: []string{subject + ".>"},
-			Replicas: 1,
-		})
-		if err != nil {
-			conn.Close()
-			return nil, fmt.Errorf("failed to create stream: %w", err)
-		}
+	if err := ensureStream(js, eventStreamName, subject+".>"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if err := ensureStream(js, deadLetterStreamName, deadLetterSubject(subject)+".>"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if eventSerializer == nil {
+		eventSerializer = serializer.NewJSONSerializer()
 	}
 
 	return &NATSEventBus{
-		conn:    conn,
-		js:      js,
-		subs:    make(map[events.EventType]*nats.Subscription),
-		subject: subject,
+		conn:        conn,
+		js:          js,
+		subs:        make(map[*natsSubscription]struct{}),
+		subject:     subject,
+		serializer:  eventSerializer,
+		serializers: serializer.NewRegistry(serializer.NewJSONSerializer(), serializer.NewBinarySerializer(), eventSerializer),
+		retryPolicy: DefaultRetryPolicy,
 	}, nil
 }
 
+// ensureStream creates a stream unless it exists
+func ensureStream(js nats.JetStreamContext, name, subject string) error {
+	_, err := js.StreamInfo(name)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, nats.ErrStreamNotFound) {
+		return fmt.Errorf("failed to read stream %s: %w", name, err)
+	}
+
+	_, err = js.AddStream(&nats.StreamConfig{
+		Name:     name,
+		Subjects: []string{subject},
+		Replicas: 1,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create stream: %w", err)
+	}
+	return nil
+}
+
+// SetRetryPolicy sets the redelivery of failed messages for subscriptions created afterwards
+func (b *NATSEventBus) SetRetryPolicy(policy RetryPolicy) {
+	b.retryPolicy = policy
+}
+
+// SetUpcasters makes subscriptions created afterwards bring received events to
+// their latest schema version. Events that cannot be upcast are not acknowledged.
+func (b *NATSEventBus) SetUpcasters(upcasters *events.UpcasterRegistry) {
+	b.upcasters = upcasters
+}
+
+// SetCloudEvents makes the bus publish events as CloudEvents 1.0 in the given
+// mode, with source as their source attribute, or the bus subject if it is empty.
+// Subscriptions accept CloudEvents in either mode whatever the setting.
+func (b *NATSEventBus) SetCloudEvents(mode CloudEventsMode, source string) {
+	if source == "" {
+		source = b.subject
+	}
+
+	b.cloudEvents = mode
+	b.cloudEventsSource = source
+}
+
 func (b *NATSEventBus) Publish(ctx context.Context, event *events.Event) error {
 	subject := fmt.Sprintf("%s.%s", b.subject, string(event.Type))
 
-	eventJSON, err := json.Marshal(event)
+	msg, err := b.encodeMessage(subject, event)
 	if err != nil {
-		return fmt.Errorf("failed to marshal event: %w", err)
+		return err
 	}
 
-	_, err = b.js.Publish(subject, eventJSON)
+	_, err = b.js.PublishMsg(msg)
 	if err != nil {
 		return fmt.Errorf("failed to publish event: %w", err)
 	}
@@ -74,39 +153,210 @@ func (b *NATSEventBus) Publish(ctx context.Context, event *events.Event) error {
 	return nil
 }
 
-func (b *NATSEventBus) Subscribe(eventType events.EventType, handler EventHandler) error {
-	subject := fmt.Sprintf("%s.%s", b.subject, string(eventType))
+// Subscribe handles the events of a type, or of the types matching a glob pattern
+// such as Diary*, through a durable consumer. The consumer is named
+// handler-<EventType> by default; WithService gives each service its own and
+// WithQueue spreads events over the instances of a service. Further handlers
+// of the same events on the bus need their own WithService or WithDurable name,
+// as a shared consumer would split the events between them; subscribing them
+// without one returns ErrDurableInUse.
+func (b *NATSEventBus) Subscribe(eventType events.EventType, handler EventHandler, options ...SubscribeOption) (Subscription, error) {
+	return b.SubscribeMany([]events.EventType{eventType}, handler, options...)
+}
+
+// SubscribeMany handles the events of several types or patterns through one
+// durable consumer. The consumer of several types only receives their events,
+// filtering on several subjects needs nats-server 2.10 or later. NATS
+// wildcards only match whole tokens, so the consumer of a glob pattern reads
+// every event of the bus subject and acknowledges the ones it does not match.
+func (b *NATSEventBus) SubscribeMany(eventTypes []events.EventType, handler EventHandler, options ...SubscribeOption) (Subscription, error) {
+	if len(eventTypes) == 0 {
+		return nil, fmt.Errorf("no event types to subscribe to")
+	}
+
+	filters := b.filterSubjects(eventTypes)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Members of a queue group share their consumer, other handlers need one of their own
+	subscribeOptions := newSubscribeOptions(options)
+	durable := subscribeOptions.durableName(eventTypes)
+	if subscribeOptions.queue == "" && b.durableInUse(durable) {
+		return nil, fmt.Errorf("%w: %s, subscribe with WithService or WithDurable", ErrDurableInUse, durable)
+	}
+	upcasters := b.upcasters
+	policy := b.retryPolicy
+
+	// A subscription bound to a consumer of several subjects has no subject of its own
+	subject := ""
+	config := subscribeOptions.consumerConfig(durable, b.deliverSubject(durable))
+	if len(filters) == 1 {
+		subject = filters[0]
+		config.FilterSubject = subject
+	} else {
+		config.FilterSubjects = filters
+	}
+	if policy.MaxDeliver > 0 {
+		config.MaxDeliver = policy.MaxDeliver
+	}
+	if err := b.ensureConsumer(config); err != nil {
+		return nil, err
+	}
 
-	sub, err := b.js.Subscribe(subject, func(msg *nats.Msg) {
-		var event events.Event
-		if err := json.Unmarshal(msg.Data, &event); err != nil {
+	callback := func(msg *nats.Msg) {
+		// Only the consumers of a pattern receive events they do not match
+		eventType := events.EventType(strings.TrimPrefix(msg.Subject, b.subject+"."))
+		if !matchEventType(eventTypes, eventType) {
+			msg.Ack()
 			return
 		}
 
-		ctx := context.Background()
-		if err := handler(ctx, &event); err != nil {
+		event, err := b.decodeMessage(msg)
+		if err != nil {
+			b.deadLetter(msg, durable, fmt.Errorf("failed to decode event: %w", err))
+			return
+		}
+
+		if upcasters != nil {
+			if event, err = upcasters.Upcast(event); err != nil {
+				b.deadLetter(msg, durable, err)
+				return
+			}
+		}
+
+		ctx := ContextWithHeaders(context.Background(), headersOf(msg))
+		if err := handler(ctx, event); err != nil {
+			b.retry(msg, durable, policy, err)
 			return
 		}
 
 		msg.Ack()
-	}, nats.Durable(fmt.Sprintf("handler-%s", string(eventType))))
+	}
+
+	// nats.go deletes the consumers it creates when their subscription stops,
+	// binding to the consumer created above keeps it and its position
+	natsOptions := []nats.SubOpt{nats.Bind(eventStreamName, durable), nats.ManualAck()}
 
+	var sub *nats.Subscription
+	var err error
+	if subscribeOptions.queue != "" {
+		sub, err = b.js.QueueSubscribe(subject, subscribeOptions.queue, callback, natsOptions...)
+	} else {
+		sub, err = b.js.Subscribe(subject, callback, natsOptions...)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to subscribe: %w", err)
+		return nil, fmt.Errorf("failed to subscribe: %w", err)
+	}
+
+	subscription := &natsSubscription{bus: b, durable: durable, sub: sub}
+
+	// Messages whose ack wait expired on their last delivery never reach the callback again
+	if policy.MaxDeliver > 0 {
+		subscription.advisory, err = b.conn.QueueSubscribe(maxDeliveriesSubject(durable), durable, b.deadLetterExhausted)
+		if err != nil {
+			sub.Unsubscribe()
+			return nil, fmt.Errorf("failed to subscribe to max deliveries advisories: %w", err)
+		}
 	}
 
-	b.subs[eventType] = sub
+	b.subs[subscription] = struct{}{}
+	return subscription, nil
+}
+
+// filterSubjects returns the subjects of the events of the given types, or
+// the whole bus subject if one of them is a glob pattern
+func (b *NATSEventBus) filterSubjects(eventTypes []events.EventType) []string {
+	seen := make(map[events.EventType]bool, len(eventTypes))
+	subjects := make([]string, 0, len(eventTypes))
+	for _, eventType := range eventTypes {
+		if isEventTypePattern(eventType) {
+			return []string{b.subject + ".>"}
+		}
+		if seen[eventType] {
+			continue
+		}
+		seen[eventType] = true
+		subjects = append(subjects, fmt.Sprintf("%s.%s", b.subject, string(eventType)))
+	}
+	return subjects
+}
+
+// ensureConsumer creates a durable consumer of the event stream, or updates the
+// ack wait, max deliver and filter of an existing one. An existing consumer
+// keeps its deliver policy and position.
+func (b *NATSEventBus) ensureConsumer(config *nats.ConsumerConfig) error {
+	info, err := b.js.ConsumerInfo(eventStreamName, config.Durable)
+	if errors.Is(err, nats.ErrConsumerNotFound) {
+		if _, err := b.js.AddConsumer(eventStreamName, config); err != nil {
+			return fmt.Errorf("failed to create consumer %s: %w", config.Durable, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read consumer %s: %w", config.Durable, err)
+	}
+
+	updated := info.Config
+	updated.AckWait = config.AckWait
+	updated.MaxDeliver = config.MaxDeliver
+	updated.FilterSubject = config.FilterSubject
+	updated.FilterSubjects = config.FilterSubjects
+	if _, err := b.js.UpdateConsumer(eventStreamName, &updated); err != nil {
+		return fmt.Errorf("failed to update consumer %s: %w", config.Durable, err)
+	}
 	return nil
 }
 
+// deliverSubject returns the subject a durable consumer pushes its messages
+// to. It is derived from the consumer name, so the members of a queue group
+// creating the consumer at once create the same one.
+func (b *NATSEventBus) deliverSubject(durable string) string {
+	return "deliver." + b.subject + "." + durable
+}
+
+// encodeMessage builds the message publishing an event. Its Nats-Msg-Id is
+// the event ID, so JetStream drops republished events within its duplicate window.
+func (b *NATSEventBus) encodeMessage(subject string, event *events.Event) (*nats.Msg, error) {
+	msg := nats.NewMsg(subject)
+	setEventHeaders(msg, event)
+
+	if b.cloudEvents != CloudEventsDisabled {
+		if err := encodeCloudEvent(msg, event, b.cloudEvents, b.cloudEventsSource); err != nil {
+			return nil, err
+		}
+		return msg, nil
+	}
+
+	data, err := b.serializer.Serialize(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize event: %w", err)
+	}
+
+	msg.Data = data
+	msg.Header.Set(contentTypeHeader, b.serializer.ContentType())
+	return msg, nil
+}
+
+// decodeMessage reads the event of a received message
+func (b *NATSEventBus) decodeMessage(msg *nats.Msg) (*events.Event, error) {
+	if isCloudEvent(msg) {
+		return decodeCloudEvent(msg)
+	}
+	return b.serializers.Deserialize(msg.Header.Get(contentTypeHeader), msg.Data)
+}
+
 func (b *NATSEventBus) Close() error {
-	for _, sub := range b.subs {
-		if err := sub.Unsubscribe(); err != nil {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for subscription := range b.subs {
+		if err := subscription.unsubscribe(); err != nil {
 			return err
 		}
+		delete(b.subs, subscription)
 	}
 
 	b.conn.Close()
 	return nil
 }
-
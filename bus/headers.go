@@ -0,0 +1,71 @@
+package bus
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/kegazani/metachat-event-sourcing/events"
+	"github.com/nats-io/nats.go"
+)
+
+// Headers set on every published message, so subscribers can route without decoding the body
+const (
+	HeaderEventType        = "Event-Type"
+	HeaderAggregateID      = "Aggregate-Id"
+	HeaderAggregateVersion = "Aggregate-Version"
+	HeaderCorrelationID    = "Correlation-Id"
+	HeaderCausationID      = "Causation-Id"
+)
+
+// Headers holds the first value of each header of a received message
+type Headers map[string]string
+
+// headersKey is the context key of the headers of the message being handled
+type headersKey struct{}
+
+// ContextWithHeaders returns a context carrying message headers
+func ContextWithHeaders(ctx context.Context, headers Headers) context.Context {
+	return context.WithValue(ctx, headersKey{}, headers)
+}
+
+// HeadersFromContext returns the headers of the message being handled, or nil outside a handler
+func HeadersFromContext(ctx context.Context) Headers {
+	headers, _ := ctx.Value(headersKey{}).(Headers)
+	return headers
+}
+
+// eventHeaders returns the deduplication ID and the routing headers of an event
+func eventHeaders(event *events.Event) Headers {
+	headers := Headers{
+		nats.MsgIdHdr:          event.ID,
+		HeaderEventType:        string(event.Type),
+		HeaderAggregateID:      event.AggregateID,
+		HeaderAggregateVersion: strconv.Itoa(event.Version),
+	}
+
+	if correlationID, ok := event.Metadata["correlation_id"].(string); ok && correlationID != "" {
+		headers[HeaderCorrelationID] = correlationID
+	}
+	if causationID, ok := event.Metadata["causation_id"].(string); ok && causationID != "" {
+		headers[HeaderCausationID] = causationID
+	}
+	return headers
+}
+
+// setEventHeaders sets the deduplication ID and the routing headers of an event
+func setEventHeaders(msg *nats.Msg, event *events.Event) {
+	for name, value := range eventHeaders(event) {
+		msg.Header.Set(name, value)
+	}
+}
+
+// headersOf returns the headers of a received message
+func headersOf(msg *nats.Msg) Headers {
+	headers := make(Headers, len(msg.Header))
+	for name, values := range msg.Header {
+		if len(values) > 0 {
+			headers[name] = values[0]
+		}
+	}
+	return headers
+}
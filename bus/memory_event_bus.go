@@ -0,0 +1,359 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kegazani/metachat-event-sourcing/events"
+)
+
+// ErrBusClosed is returned when publishing on a closed bus
+var ErrBusClosed = errors.New("event bus closed")
+
+// DeliveryMode selects how InMemoryEventBus delivers published events
+type DeliveryMode int
+
+const (
+	// DeliverySync handles events in the goroutine of Publish, which returns once every handler is done
+	DeliverySync DeliveryMode = iota
+
+	// DeliveryAsync handles events in one goroutine per subscription, in publish order
+	DeliveryAsync
+)
+
+// InMemoryEventBusOptions configures an InMemoryEventBus
+type InMemoryEventBusOptions struct {
+	Mode DeliveryMode
+
+	// Retry controls redelivery when a handler fails. A zero MaxDeliver
+	// delivers events up to 3 times, and delays are slept in sync mode too.
+	Retry RetryPolicy
+}
+
+// defaultMemoryMaxDeliver is the number of deliveries of an event to a failing handler when unset
+const defaultMemoryMaxDeliver = 3
+
+// InMemoryEventBus is an EventBus delivering events within the process,
+// recording every published event and every event its handler gave up on.
+// Subscriptions sharing a WithQueue name compete for events; the other
+// subscription options are ignored.
+type InMemoryEventBus struct {
+	mode  DeliveryMode
+	retry RetryPolicy
+
+	mu          sync.Mutex
+	subs        map[*memorySubscription]struct{}
+	nextID      int
+	queueCursor map[string]int
+	published   []*events.Event
+	deadLetters []*DeadLetter
+	inFlight    int
+	idle        []chan struct{}
+	closed      bool
+}
+
+// NewInMemoryEventBus creates an in-memory event bus
+func NewInMemoryEventBus(options InMemoryEventBusOptions) *InMemoryEventBus {
+	if options.Retry.MaxDeliver <= 0 {
+		options.Retry.MaxDeliver = defaultMemoryMaxDeliver
+	}
+
+	return &InMemoryEventBus{
+		mode:        options.Mode,
+		retry:       options.Retry,
+		subs:        make(map[*memorySubscription]struct{}),
+		queueCursor: make(map[string]int),
+	}
+}
+
+// memorySubscription is the Subscription of an InMemoryEventBus
+type memorySubscription struct {
+	bus        *InMemoryEventBus
+	id         int
+	name       string
+	eventTypes []events.EventType
+	queue      string
+	handler    EventHandler
+
+	// pending and wake feed the delivery goroutine in async mode
+	pending []*events.Event
+	wake    chan struct{}
+	stop    chan struct{}
+}
+
+// Publish records an event and delivers it to the matching subscriptions.
+// Handler failures are retried and then recorded as dead letters, they are not returned.
+func (b *InMemoryEventBus) Publish(ctx context.Context, event *events.Event) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrBusClosed
+	}
+
+	b.published = append(b.published, event)
+	targets := b.targets(event.Type)
+	b.inFlight += len(targets)
+
+	if b.mode == DeliveryAsync {
+		for _, sub := range targets {
+			sub.pending = append(sub.pending, event)
+			select {
+			case sub.wake <- struct{}{}:
+			default:
+			}
+		}
+		b.mu.Unlock()
+		return nil
+	}
+	b.mu.Unlock()
+
+	for _, sub := range targets {
+		b.deliver(ctx, sub, event)
+	}
+	return nil
+}
+
+// targets returns the subscriptions receiving an event type, picking one
+// member of each queue group in turn. The caller holds b.mu.
+func (b *InMemoryEventBus) targets(eventType events.EventType) []*memorySubscription {
+	var targets []*memorySubscription
+	queues := make(map[string][]*memorySubscription)
+
+	for sub := range b.subs {
+		if !matchEventType(sub.eventTypes, eventType) {
+			continue
+		}
+		if sub.queue == "" {
+			targets = append(targets, sub)
+			continue
+		}
+		queues[sub.queue] = append(queues[sub.queue], sub)
+	}
+
+	for queue, members := range queues {
+		sort.Slice(members, func(i, j int) bool { return members[i].id < members[j].id })
+		cursor := b.queueCursor[queue]
+		targets = append(targets, members[cursor%len(members)])
+		b.queueCursor[queue] = cursor + 1
+	}
+
+	return targets
+}
+
+// deliver hands an event to a subscription, retrying failures per the retry policy
+func (b *InMemoryEventBus) deliver(ctx context.Context, sub *memorySubscription, event *events.Event) {
+	defer b.done()
+
+	ctx = ContextWithHeaders(ctx, eventHeaders(event))
+	for deliveries := 1; ; deliveries++ {
+		err := sub.handler(ctx, event)
+		if err == nil {
+			return
+		}
+
+		if deliveries >= b.retry.MaxDeliver {
+			b.mu.Lock()
+			b.deadLetters = append(b.deadLetters, &DeadLetter{
+				Sequence:   uint64(len(b.deadLetters) + 1),
+				Reason:     fmt.Sprintf("gave up after %d deliveries: %v", deliveries, err),
+				Consumer:   sub.name,
+				Deliveries: deliveries,
+				Time:       time.Now().UTC(),
+				Headers:    eventHeaders(event),
+				Event:      event,
+			})
+			b.mu.Unlock()
+			return
+		}
+
+		if delay := b.retry.Delay(deliveries); delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// done marks one delivery as finished, waking WaitIdle callers when none is left
+func (b *InMemoryEventBus) done() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.inFlight--
+	b.notifyIdle()
+}
+
+// notifyIdle wakes WaitIdle callers if no delivery is in flight. The caller holds b.mu.
+func (b *InMemoryEventBus) notifyIdle() {
+	if b.inFlight > 0 {
+		return
+	}
+
+	for _, idle := range b.idle {
+		close(idle)
+	}
+	b.idle = nil
+}
+
+// run delivers the pending events of a subscription in async mode
+func (b *InMemoryEventBus) run(sub *memorySubscription) {
+	for {
+		b.mu.Lock()
+		if len(sub.pending) == 0 {
+			b.mu.Unlock()
+			select {
+			case <-sub.wake:
+				continue
+			case <-sub.stop:
+				return
+			}
+		}
+		event := sub.pending[0]
+		sub.pending = sub.pending[1:]
+		b.mu.Unlock()
+
+		b.deliver(context.Background(), sub, event)
+	}
+}
+
+// Subscribe handles the events of a type, or of the types matching a glob pattern such as Diary*
+func (b *InMemoryEventBus) Subscribe(eventType events.EventType, handler EventHandler, options ...SubscribeOption) (Subscription, error) {
+	return b.SubscribeMany([]events.EventType{eventType}, handler, options...)
+}
+
+// SubscribeMany handles the events of several types or patterns with one handler
+func (b *InMemoryEventBus) SubscribeMany(eventTypes []events.EventType, handler EventHandler, options ...SubscribeOption) (Subscription, error) {
+	if len(eventTypes) == 0 {
+		return nil, fmt.Errorf("no event types to subscribe to")
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, ErrBusClosed
+	}
+
+	b.nextID++
+	subscribeOptions := newSubscribeOptions(options)
+	sub := &memorySubscription{
+		bus:        b,
+		id:         b.nextID,
+		name:       fmt.Sprintf("%s#%d", subscribeOptions.durableName(eventTypes), b.nextID),
+		eventTypes: append([]events.EventType(nil), eventTypes...),
+		queue:      subscribeOptions.queue,
+		handler:    handler,
+		wake:       make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+	}
+	b.subs[sub] = struct{}{}
+
+	if b.mode == DeliveryAsync {
+		go b.run(sub)
+	}
+
+	return sub, nil
+}
+
+// Unsubscribe stops delivering events to the handler of the subscription.
+// Events already queued for it in async mode are dropped.
+func (s *memorySubscription) Unsubscribe() error {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	s.bus.removeSubscription(s)
+	return nil
+}
+
+// removeSubscription drops a subscription and its pending events. The caller holds b.mu.
+func (b *InMemoryEventBus) removeSubscription(sub *memorySubscription) {
+	if _, ok := b.subs[sub]; !ok {
+		return
+	}
+
+	delete(b.subs, sub)
+	close(sub.stop)
+
+	if dropped := len(sub.pending); dropped > 0 {
+		sub.pending = nil
+		b.inFlight -= dropped
+		b.notifyIdle()
+	}
+}
+
+// WaitIdle blocks until every published event has been handled, retries
+// included, or the context is done
+func (b *InMemoryEventBus) WaitIdle(ctx context.Context) error {
+	b.mu.Lock()
+	if b.inFlight == 0 {
+		b.mu.Unlock()
+		return nil
+	}
+	idle := make(chan struct{})
+	b.idle = append(b.idle, idle)
+	b.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Published returns the events published so far, in order
+func (b *InMemoryEventBus) Published() []*events.Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]*events.Event(nil), b.published...)
+}
+
+// PublishedOfType returns the events of a type published so far, in order
+func (b *InMemoryEventBus) PublishedOfType(eventType events.EventType) []*events.Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var result []*events.Event
+	for _, event := range b.published {
+		if event.Type == eventType {
+			result = append(result, event)
+		}
+	}
+	return result
+}
+
+// DeadLetters returns the events a handler still failed on after its last delivery
+func (b *InMemoryEventBus) DeadLetters() []*DeadLetter {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]*DeadLetter(nil), b.deadLetters...)
+}
+
+// Reset clears the publish history and the dead letters
+func (b *InMemoryEventBus) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.published = nil
+	b.deadLetters = nil
+}
+
+// Close removes every subscription, after which Publish fails
+func (b *InMemoryEventBus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for sub := range b.subs {
+		b.removeSubscription(sub)
+	}
+	return nil
+}
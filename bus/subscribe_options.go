@@ -0,0 +1,137 @@
+package bus
+
+import (
+	"strings"
+	"time"
+
+	"github.com/kegazani/metachat-event-sourcing/events"
+	"github.com/nats-io/nats.go"
+)
+
+// SubscribeOption configures a subscription
+type SubscribeOption func(*subscribeOptions)
+
+// subscribeOptions holds the configuration of a subscription
+type subscribeOptions struct {
+	service       string
+	queue         string
+	durable       string
+	deliverPolicy nats.DeliverPolicy
+	startSequence uint64
+	startTime     *time.Time
+	ackWait       time.Duration
+}
+
+// WithService scopes the durable consumer to a service, so each service
+// subscribing to an event type receives every event of that type
+func WithService(name string) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.service = name
+	}
+}
+
+// WithQueue makes the subscription a member of a consumer group: the
+// subscriptions sharing a queue name, typically the instances of one service,
+// compete for the events and each event is handled by one of them
+func WithQueue(name string) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.queue = name
+	}
+}
+
+// WithDurable sets the durable consumer name, overriding the one derived from the service and queue
+func WithDurable(name string) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.durable = name
+	}
+}
+
+// DeliverAll starts a new durable consumer at the first event of the stream
+func DeliverAll() SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.deliverPolicy = nats.DeliverAllPolicy
+	}
+}
+
+// DeliverNew starts a new durable consumer at events published after it is created
+func DeliverNew() SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.deliverPolicy = nats.DeliverNewPolicy
+	}
+}
+
+// DeliverFromTime starts a new durable consumer at the first event published at or after a time
+func DeliverFromTime(startTime time.Time) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.deliverPolicy = nats.DeliverByStartTimePolicy
+		o.startTime = &startTime
+	}
+}
+
+// DeliverFromSequence starts a new durable consumer at a stream sequence
+func DeliverFromSequence(sequence uint64) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.deliverPolicy = nats.DeliverByStartSequencePolicy
+		o.startSequence = sequence
+	}
+}
+
+// WithAckWait sets how long the server waits for a handler to finish before redelivering an event
+func WithAckWait(ackWait time.Duration) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.ackWait = ackWait
+	}
+}
+
+// newSubscribeOptions applies options
+func newSubscribeOptions(options []SubscribeOption) subscribeOptions {
+	var o subscribeOptions
+	for _, option := range options {
+		option(&o)
+	}
+	return o
+}
+
+// durableName returns the durable consumer name of a subscription: the
+// service, or else the queue, followed by the event types. Subscriptions
+// without either keep the handler-<EventType> name.
+func (o subscribeOptions) durableName(eventTypes []events.EventType) string {
+	if o.durable != "" {
+		return o.durable
+	}
+
+	prefix := "handler"
+	switch {
+	case o.service != "":
+		prefix = o.service
+	case o.queue != "":
+		prefix = o.queue
+	}
+
+	names := make([]string, len(eventTypes))
+	for i, eventType := range eventTypes {
+		names[i] = string(eventType)
+	}
+
+	return sanitizeConsumerName(prefix + "-" + strings.Join(names, "-"))
+}
+
+// consumerConfig returns the configuration of the durable push consumer of a
+// subscription, delivering to deliverSubject
+func (o subscribeOptions) consumerConfig(durable, deliverSubject string) *nats.ConsumerConfig {
+	return &nats.ConsumerConfig{
+		Durable:        durable,
+		DeliverSubject: deliverSubject,
+		DeliverGroup:   o.queue,
+		DeliverPolicy:  o.deliverPolicy,
+		OptStartSeq:    o.startSequence,
+		OptStartTime:   o.startTime,
+		AckPolicy:      nats.AckExplicitPolicy,
+		AckWait:        o.ackWait,
+	}
+}
+
+// sanitizeConsumerName replaces the characters JetStream forbids in consumer names
+func sanitizeConsumerName(name string) string {
+	return strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_", "?", "_", "[", "_", "]", "_").Replace(name)
+}
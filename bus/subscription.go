@@ -0,0 +1,85 @@
+package bus
+
+import (
+	"errors"
+	"path"
+	"strings"
+
+	"github.com/kegazani/metachat-event-sourcing/events"
+	"github.com/nats-io/nats.go"
+)
+
+// ErrDurableInUse is returned when a handler subscribes through the durable
+// consumer of another handler of the bus without joining its queue group
+var ErrDurableInUse = errors.New("durable consumer already in use")
+
+// Subscription is a handle on a subscription created by an EventBus
+type Subscription interface {
+	// Unsubscribe stops delivering events to the handler of the subscription
+	Unsubscribe() error
+}
+
+// isEventTypePattern reports whether an event type holds glob characters,
+// as in Diary* or *Aggregated
+func isEventTypePattern(eventType events.EventType) bool {
+	return strings.ContainsAny(string(eventType), "*?[")
+}
+
+// matchEventType reports whether an event type matches any of the given types or glob patterns
+func matchEventType(patterns []events.EventType, eventType events.EventType) bool {
+	for _, pattern := range patterns {
+		if pattern == eventType {
+			return true
+		}
+		if matched, _ := path.Match(string(pattern), string(eventType)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// natsSubscription is the Subscription of a NATSEventBus durable consumer
+type natsSubscription struct {
+	bus     *NATSEventBus
+	durable string
+	sub     *nats.Subscription
+
+	// advisory receives the max deliveries advisories of the consumer, nil without a MaxDeliver
+	advisory *nats.Subscription
+}
+
+// Unsubscribe stops delivering events to the handler of the subscription. The
+// durable consumer is kept, so subscribing again resumes after the last
+// acknowledged event.
+func (s *natsSubscription) Unsubscribe() error {
+	s.bus.mu.Lock()
+	if _, ok := s.bus.subs[s]; !ok {
+		s.bus.mu.Unlock()
+		return nil
+	}
+	delete(s.bus.subs, s)
+	s.bus.mu.Unlock()
+
+	return s.unsubscribe()
+}
+
+// unsubscribe stops the message and advisory subscriptions
+func (s *natsSubscription) unsubscribe() error {
+	if s.advisory != nil {
+		if err := s.advisory.Unsubscribe(); err != nil {
+			return err
+		}
+	}
+	return s.sub.Unsubscribe()
+}
+
+// durableInUse reports whether another subscription of the bus consumes through
+// a durable consumer. The caller holds b.mu.
+func (b *NATSEventBus) durableInUse(durable string) bool {
+	for sub := range b.subs {
+		if sub.durable == durable {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/kegazani/metachat-event-sourcing/events"
+)
+
+func TestSubscribeRejectsSharedDurable(t *testing.T) {
+	// The consumer of the first handler is in use, the second handler never reaches JetStream
+	b := &NATSEventBus{
+		subs:    map[*natsSubscription]struct{}{{durable: "handler-DiaryEntryCreated"}: {}},
+		subject: "metachat.events",
+	}
+	handler := func(ctx context.Context, event *events.Event) error { return nil }
+
+	_, err := b.Subscribe(events.DiaryEntryCreatedEvent, handler)
+	if !errors.Is(err, ErrDurableInUse) {
+		t.Fatalf("expected ErrDurableInUse, got %v", err)
+	}
+
+	_, err = b.Subscribe(events.DiaryEntryCreatedEvent, handler, WithService("handler"))
+	if !errors.Is(err, ErrDurableInUse) {
+		t.Fatalf("expected ErrDurableInUse for the same service name, got %v", err)
+	}
+}
+
+func TestDurableNameIsDeterministic(t *testing.T) {
+	eventTypes := []events.EventType{events.DiaryEntryCreatedEvent, "Mood*"}
+
+	tests := []struct {
+		options []SubscribeOption
+		want    string
+	}{
+		{nil, "handler-DiaryEntryCreated-Mood_"},
+		{[]SubscribeOption{WithService("insights")}, "insights-DiaryEntryCreated-Mood_"},
+		{[]SubscribeOption{WithQueue("workers")}, "workers-DiaryEntryCreated-Mood_"},
+		{[]SubscribeOption{WithService("insights"), WithDurable("insights-moods")}, "insights-moods"},
+	}
+
+	for _, tt := range tests {
+		if got := newSubscribeOptions(tt.options).durableName(eventTypes); got != tt.want {
+			t.Fatalf("expected durable %s, got %s", tt.want, got)
+		}
+	}
+}
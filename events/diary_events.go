@@ -2,20 +2,20 @@ package events
 
 // DiaryEntryCreatedPayload represents the payload for DiaryEntryCreated event
 type DiaryEntryCreatedPayload struct {
-	UserID      string `json:"user_id"`
-	Title       string `json:"title"`
-	Content     string `json:"content"`
-	TokenCount  int    `json:"token_count"`
-	SessionID   string `json:"session_id"`
-	Tags        []string `json:"tags,omitempty"`
+	UserID     string   `json:"user_id" validate:"required"`
+	Title      string   `json:"title"`
+	Content    string   `json:"content"`
+	TokenCount int      `json:"token_count" validate:"min=0"`
+	SessionID  string   `json:"session_id"`
+	Tags       []string `json:"tags,omitempty"`
 }
 
 // DiaryEntryUpdatedPayload represents the payload for DiaryEntryUpdated event
 type DiaryEntryUpdatedPayload struct {
-	Title       string `json:"title,omitempty"`
-	Content     string `json:"content,omitempty"`
-	TokenCount  int    `json:"token_count,omitempty"`
-	Tags        []string `json:"tags,omitempty"`
+	Title      string   `json:"title,omitempty"`
+	Content    string   `json:"content,omitempty"`
+	TokenCount int      `json:"token_count,omitempty" validate:"min=0"`
+	Tags       []string `json:"tags,omitempty"`
 }
 
 // DiaryEntryDeletedPayload represents the payload for DiaryEntryDeleted event
@@ -25,15 +25,15 @@ type DiaryEntryDeletedPayload struct {
 
 // DiarySessionStartedPayload represents the payload for DiarySessionStarted event
 type DiarySessionStartedPayload struct {
-	UserID    string `json:"user_id"`
-	StartTime string `json:"start_time"`
+	UserID    string `json:"user_id" validate:"required"`
+	StartTime string `json:"start_time" validate:"required,rfc3339"`
 	Source    string `json:"source"` // "web", "mobile", etc.
 }
 
 // DiarySessionEndedPayload represents the payload for DiarySessionEnded event
 type DiarySessionEndedPayload struct {
-	SessionID   string `json:"session_id"`
-	EndTime     string `json:"end_time"`
-	EntryCount  int    `json:"entry_count"`
-	TokenCount  int    `json:"token_count"`
-}
\ No newline at end of file
+	SessionID  string `json:"session_id" validate:"required"`
+	EndTime    string `json:"end_time" validate:"required,rfc3339"`
+	EntryCount int    `json:"entry_count" validate:"min=0"`
+	TokenCount int    `json:"token_count" validate:"min=0"`
+}
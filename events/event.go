@@ -17,6 +17,7 @@ const (
 	UserArchetypeAssignedEvent EventType = "UserArchetypeAssigned"
 	UserArchetypeUpdatedEvent  EventType = "UserArchetypeUpdated"
 	UserModalitiesUpdatedEvent EventType = "UserModalitiesUpdated"
+	UserForgottenEvent         EventType = "UserForgotten"
 
 	// Diary events
 	DiaryEntryCreatedEvent   EventType = "DiaryEntryCreated"
@@ -42,19 +43,87 @@ const (
 	UserPortraitUpdatedEvent EventType = "UserPortraitUpdated"
 )
 
+// AggregateType represents the type of aggregate an event belongs to
+type AggregateType string
+
+const (
+	UserAggregateType      AggregateType = "user"
+	DiaryAggregateType     AggregateType = "diary"
+	SessionAggregateType   AggregateType = "session"
+	MoodAggregateType      AggregateType = "mood"
+	ArchetypeAggregateType AggregateType = "archetype"
+	PortraitAggregateType  AggregateType = "portrait"
+)
+
+// aggregateTypes maps each event type to the aggregate type that emits it
+var aggregateTypes = map[EventType]AggregateType{
+	UserRegisteredEvent:        UserAggregateType,
+	UserProfileUpdatedEvent:    UserAggregateType,
+	UserArchetypeAssignedEvent: UserAggregateType,
+	UserArchetypeUpdatedEvent:  UserAggregateType,
+	UserModalitiesUpdatedEvent: UserAggregateType,
+	UserForgottenEvent:         UserAggregateType,
+
+	DiaryEntryCreatedEvent: DiaryAggregateType,
+	DiaryEntryUpdatedEvent: DiaryAggregateType,
+	DiaryEntryDeletedEvent: DiaryAggregateType,
+
+	DiarySessionStartedEvent: SessionAggregateType,
+	DiarySessionEndedEvent:   SessionAggregateType,
+
+	MoodAnalyzedEvent:          MoodAggregateType,
+	DailyMoodAggregatedEvent:   MoodAggregateType,
+	WeeklyMoodAggregatedEvent:  MoodAggregateType,
+	MonthlyMoodAggregatedEvent: MoodAggregateType,
+
+	ArchetypeCalculationTriggeredEvent: ArchetypeAggregateType,
+	ArchetypeAssignedEvent:             ArchetypeAggregateType,
+	ArchetypeUpdatedEvent:              ArchetypeAggregateType,
+
+	UserPortraitUpdatedEvent: PortraitAggregateType,
+}
+
+// AggregateTypeOf returns the aggregate type that emits the given event type,
+// or an empty AggregateType if the event type is unknown
+func AggregateTypeOf(eventType EventType) AggregateType {
+	return aggregateTypes[eventType]
+}
+
+// EventTypesOf returns the event types emitted by the given aggregate type
+func EventTypesOf(aggregateType AggregateType) []EventType {
+	var result []EventType
+	for eventType, t := range aggregateTypes {
+		if t == aggregateType {
+			result = append(result, eventType)
+		}
+	}
+	return result
+}
+
 // Event represents a domain event
 type Event struct {
-	ID          string                 `json:"id"`
-	Type        EventType              `json:"type"`
-	AggregateID string                 `json:"aggregate_id"`
-	Version     int                    `json:"version"`
-	Timestamp   time.Time              `json:"timestamp"`
-	Payload     json.RawMessage        `json:"payload"`
-	Metadata    map[string]interface{} `json:"metadata"`
+	ID            string                 `json:"id"`
+	Type          EventType              `json:"type"`
+	AggregateID   string                 `json:"aggregate_id"`
+	AggregateType AggregateType          `json:"aggregate_type,omitempty"`
+	Version       int                    `json:"version"`
+	Timestamp     time.Time              `json:"timestamp"`
+	Payload       json.RawMessage        `json:"payload"`
+	Metadata      map[string]interface{} `json:"metadata"`
+
+	// SchemaVersion is the version of the payload shape, 0 for events stored before versioning
+	SchemaVersion int `json:"schema_version,omitempty"`
+
+	// Position is the global position assigned by the event store, 0 until stored
+	Position uint64 `json:"position,omitempty"`
 }
 
 // NewEvent creates a new event
 func NewEvent(eventType EventType, aggregateID string, version int, payload interface{}, metadata map[string]interface{}) (*Event, error) {
+	if err := ValidatePayload(eventType, payload); err != nil {
+		return nil, err
+	}
+
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
 		return nil, err
@@ -65,13 +134,15 @@ func NewEvent(eventType EventType, aggregateID string, version int, payload inte
 	}
 
 	return &Event{
-		ID:          uuid.New().String(),
-		Type:        eventType,
-		AggregateID: aggregateID,
-		Version:     version,
-		Timestamp:   time.Now(),
-		Payload:     payloadBytes,
-		Metadata:    metadata,
+		ID:            uuid.New().String(),
+		Type:          eventType,
+		AggregateID:   aggregateID,
+		AggregateType: AggregateTypeOf(eventType),
+		Version:       version,
+		Timestamp:     time.Now(),
+		Payload:       payloadBytes,
+		Metadata:      metadata,
+		SchemaVersion: LatestSchemaVersion(eventType),
 	}, nil
 }
 
@@ -0,0 +1,117 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// ErrUnregisteredEventType is returned when decoding an event whose type has no registered payload
+var ErrUnregisteredEventType = errors.New("unregistered event type")
+
+// Registry maps event types to their payload types
+type Registry struct {
+	mu       sync.RWMutex
+	payloads map[EventType]reflect.Type
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		payloads: make(map[EventType]reflect.Type),
+	}
+}
+
+// DefaultRegistry holds the payload types of all event types of this package
+// that have one. The archetype calculation events carry no payload type yet.
+var DefaultRegistry = newDefaultRegistry()
+
+func newDefaultRegistry() *Registry {
+	r := NewRegistry()
+
+	r.Register(UserRegisteredEvent, UserRegisteredPayload{})
+	r.Register(UserProfileUpdatedEvent, UserProfileUpdatedPayload{})
+	r.Register(UserArchetypeAssignedEvent, UserArchetypeAssignedPayload{})
+	r.Register(UserArchetypeUpdatedEvent, UserArchetypeUpdatedPayload{})
+	r.Register(UserModalitiesUpdatedEvent, UserModalitiesUpdatedPayload{})
+	r.Register(UserForgottenEvent, UserForgottenPayload{})
+
+	r.Register(DiaryEntryCreatedEvent, DiaryEntryCreatedPayload{})
+	r.Register(DiaryEntryUpdatedEvent, DiaryEntryUpdatedPayload{})
+	r.Register(DiaryEntryDeletedEvent, DiaryEntryDeletedPayload{})
+	r.Register(DiarySessionStartedEvent, DiarySessionStartedPayload{})
+	r.Register(DiarySessionEndedEvent, DiarySessionEndedPayload{})
+
+	r.Register(MoodAnalyzedEvent, MoodAnalyzedPayload{})
+	r.Register(DailyMoodAggregatedEvent, DailyMoodAggregatedPayload{})
+	r.Register(WeeklyMoodAggregatedEvent, WeeklyMoodAggregatedPayload{})
+	r.Register(MonthlyMoodAggregatedEvent, MonthlyMoodAggregatedPayload{})
+
+	r.Register(UserPortraitUpdatedEvent, UserPortraitUpdatedPayload{})
+
+	return r
+}
+
+// Register maps an event type to the type of the given payload value
+func (r *Registry) Register(eventType EventType, payload interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.payloads[eventType] = reflect.TypeOf(payload)
+}
+
+// PayloadType returns the payload type registered for an event type
+func (r *Registry) PayloadType(eventType EventType) (reflect.Type, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	payloadType, ok := r.payloads[eventType]
+	return payloadType, ok
+}
+
+// Decode unmarshals the payload of an event into a value of its registered type
+func (r *Registry) Decode(event *Event) (any, error) {
+	payloadType, ok := r.PayloadType(event.Type)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnregisteredEventType, event.Type)
+	}
+
+	payload := reflect.New(payloadType)
+	if err := json.Unmarshal(event.Payload, payload.Interface()); err != nil {
+		return nil, fmt.Errorf("failed to decode %s payload: %w", event.Type, err)
+	}
+
+	return payload.Elem().Interface(), nil
+}
+
+// DecodeAs unmarshals the payload of an event into T, which must be the type registered for the event type
+func DecodeAs[T any](r *Registry, event *Event) (T, error) {
+	var payload T
+
+	payloadType, ok := r.PayloadType(event.Type)
+	if !ok {
+		return payload, fmt.Errorf("%w: %s", ErrUnregisteredEventType, event.Type)
+	}
+
+	if want := reflect.TypeOf(payload); payloadType != want {
+		return payload, fmt.Errorf("payload of %s is %s, not %s", event.Type, payloadType, want)
+	}
+
+	if err := json.Unmarshal(event.Payload, &payload); err != nil {
+		return payload, fmt.Errorf("failed to decode %s payload: %w", event.Type, err)
+	}
+
+	return payload, nil
+}
+
+// Decode unmarshals the payload of an event using DefaultRegistry
+func Decode(event *Event) (any, error) {
+	return DefaultRegistry.Decode(event)
+}
+
+// PayloadAs unmarshals the payload of an event into T using DefaultRegistry
+func PayloadAs[T any](event *Event) (T, error) {
+	return DecodeAs[T](DefaultRegistry, event)
+}
@@ -0,0 +1,82 @@
+package events
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRegistryDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		event   *Event
+		want    interface{}
+		wantErr error
+	}{
+		{
+			name:  "registered type",
+			event: &Event{Type: DiaryEntryDeletedEvent, Payload: []byte(`{"reason":"duplicate"}`)},
+			want:  DiaryEntryDeletedPayload{Reason: "duplicate"},
+		},
+		{
+			name:    "unknown type",
+			event:   &Event{Type: "SomethingElse", Payload: []byte(`{}`)},
+			wantErr: ErrUnregisteredEventType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := Decode(tt.event)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to decode: %v", err)
+			}
+			if !reflect.DeepEqual(payload, tt.want) {
+				t.Fatalf("expected %#v, got %#v", tt.want, payload)
+			}
+		})
+	}
+}
+
+func TestRegistryDecodeMalformedPayload(t *testing.T) {
+	_, err := Decode(&Event{Type: DiaryEntryDeletedEvent, Payload: []byte(`{"reason":`)})
+	if err == nil || errors.Is(err, ErrUnregisteredEventType) {
+		t.Fatalf("expected a decoding error, got %v", err)
+	}
+}
+
+func TestDecodeAs(t *testing.T) {
+	event := &Event{Type: DiaryEntryDeletedEvent, Payload: []byte(`{"reason":"duplicate"}`)}
+
+	payload, err := PayloadAs[DiaryEntryDeletedPayload](event)
+	if err != nil || payload.Reason != "duplicate" {
+		t.Fatalf("expected the decoded payload, got %#v (%v)", payload, err)
+	}
+
+	if _, err := PayloadAs[DiaryEntryCreatedPayload](event); err == nil {
+		t.Fatal("expected an error when decoding into another payload type")
+	}
+
+	if _, err := PayloadAs[DiaryEntryDeletedPayload](&Event{Type: "SomethingElse"}); !errors.Is(err, ErrUnregisteredEventType) {
+		t.Fatalf("expected ErrUnregisteredEventType, got %v", err)
+	}
+}
+
+func TestRegistryRegister(t *testing.T) {
+	r := NewRegistry()
+	if _, ok := r.PayloadType(DiaryEntryDeletedEvent); ok {
+		t.Fatal("expected an empty registry")
+	}
+
+	r.Register(DiaryEntryDeletedEvent, DiaryEntryDeletedPayload{})
+	payloadType, ok := r.PayloadType(DiaryEntryDeletedEvent)
+	if !ok || payloadType != reflect.TypeOf(DiaryEntryDeletedPayload{}) {
+		t.Fatalf("expected DiaryEntryDeletedPayload, got %v", payloadType)
+	}
+}
@@ -0,0 +1,103 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Upcaster transforms a raw payload from one schema version to the next
+type Upcaster func(payload json.RawMessage) (json.RawMessage, error)
+
+// upcasterKey identifies the upcaster of an event type from a schema version
+type upcasterKey struct {
+	eventType EventType
+	version   int
+}
+
+// UpcasterRegistry holds the upcasters bringing stored payloads to the latest schema version.
+// Schema versions start at 1 and events stored before versioning count as version 1.
+type UpcasterRegistry struct {
+	mu        sync.RWMutex
+	upcasters map[upcasterKey]Upcaster
+	latest    map[EventType]int
+}
+
+// NewUpcasterRegistry creates an empty upcaster registry
+func NewUpcasterRegistry() *UpcasterRegistry {
+	return &UpcasterRegistry{
+		upcasters: make(map[upcasterKey]Upcaster),
+		latest:    make(map[EventType]int),
+	}
+}
+
+// DefaultUpcasters is the registry used by NewEvent to stamp schema versions
+var DefaultUpcasters = NewUpcasterRegistry()
+
+// Register adds the upcaster transforming payloads of an event type from a
+// schema version to the next one, making that the latest version if it is newer
+func (r *UpcasterRegistry) Register(eventType EventType, fromVersion int, upcaster Upcaster) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.upcasters[upcasterKey{eventType: eventType, version: fromVersion}] = upcaster
+	if fromVersion+1 > r.latest[eventType] {
+		r.latest[eventType] = fromVersion + 1
+	}
+}
+
+// LatestVersion returns the latest schema version of an event type
+func (r *UpcasterRegistry) LatestVersion(eventType EventType) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if version, ok := r.latest[eventType]; ok {
+		return version
+	}
+	return 1
+}
+
+// Upcast returns the event with its payload at the latest schema version.
+// The event is returned as is if it is current, otherwise a copy is returned.
+func (r *UpcasterRegistry) Upcast(event *Event) (*Event, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	version := event.SchemaVersion
+	if version < 1 {
+		version = 1
+	}
+
+	latest, ok := r.latest[event.Type]
+	if !ok || version >= latest {
+		return event, nil
+	}
+
+	payload := event.Payload
+	for ; version < latest; version++ {
+		upcaster, ok := r.upcasters[upcasterKey{eventType: event.Type, version: version}]
+		if !ok {
+			return nil, fmt.Errorf("no upcaster for %s from schema version %d", event.Type, version)
+		}
+
+		var err error
+		if payload, err = upcaster(payload); err != nil {
+			return nil, fmt.Errorf("failed to upcast %s from schema version %d: %w", event.Type, version, err)
+		}
+	}
+
+	upcasted := *event
+	upcasted.Payload = payload
+	upcasted.SchemaVersion = latest
+	return &upcasted, nil
+}
+
+// RegisterUpcaster adds an upcaster to DefaultUpcasters
+func RegisterUpcaster(eventType EventType, fromVersion int, upcaster Upcaster) {
+	DefaultUpcasters.Register(eventType, fromVersion, upcaster)
+}
+
+// LatestSchemaVersion returns the latest schema version of an event type in DefaultUpcasters
+func LatestSchemaVersion(eventType EventType) int {
+	return DefaultUpcasters.LatestVersion(eventType)
+}
@@ -2,8 +2,8 @@ package events
 
 // UserRegisteredPayload represents the payload for UserRegistered event
 type UserRegisteredPayload struct {
-	Username    string `json:"username"`
-	Email       string `json:"email"`
+	Username    string `json:"username" validate:"required"`
+	Email       string `json:"email" validate:"required"`
 	FirstName   string `json:"first_name"`
 	LastName    string `json:"last_name"`
 	DateOfBirth string `json:"date_of_birth,omitempty"`
@@ -20,18 +20,18 @@ type UserProfileUpdatedPayload struct {
 
 // UserArchetypeAssignedPayload represents the payload for UserArchetypeAssigned event
 type UserArchetypeAssignedPayload struct {
-	ArchetypeID    string  `json:"archetype_id"`
-	ArchetypeName  string  `json:"archetype_name"`
-	Confidence     float64 `json:"confidence"`
-	Description    string  `json:"description"`
+	ArchetypeID   string  `json:"archetype_id" validate:"required"`
+	ArchetypeName string  `json:"archetype_name"`
+	Confidence    float64 `json:"confidence" validate:"min=0,max=1"`
+	Description   string  `json:"description"`
 }
 
 // UserArchetypeUpdatedPayload represents the payload for UserArchetypeUpdated event
 type UserArchetypeUpdatedPayload struct {
-	ArchetypeID    string  `json:"archetype_id"`
-	ArchetypeName  string  `json:"archetype_name"`
-	Confidence     float64 `json:"confidence"`
-	Description    string  `json:"description"`
+	ArchetypeID   string  `json:"archetype_id" validate:"required"`
+	ArchetypeName string  `json:"archetype_name"`
+	Confidence    float64 `json:"confidence" validate:"min=0,max=1"`
+	Description   string  `json:"description"`
 }
 
 // UserModalitiesUpdatedPayload represents the payload for UserModalitiesUpdated event
@@ -39,12 +39,17 @@ type UserModalitiesUpdatedPayload struct {
 	Modalities []UserModality `json:"modalities"`
 }
 
+// UserForgottenPayload represents the payload for UserForgotten event
+type UserForgottenPayload struct {
+	Reason string `json:"reason,omitempty"`
+}
+
 // UserModality represents a user modality
 type UserModality struct {
-	ID          string  `json:"id"`
-	Name        string  `json:"name"`
-	Type        string  `json:"type"`
-	Enabled     bool    `json:"enabled"`
-	Weight      float64 `json:"weight"`
-	Config      map[string]interface{} `json:"config,omitempty"`
-}
\ No newline at end of file
+	ID      string                 `json:"id"`
+	Name    string                 `json:"name"`
+	Type    string                 `json:"type"`
+	Enabled bool                   `json:"enabled"`
+	Weight  float64                `json:"weight"`
+	Config  map[string]interface{} `json:"config,omitempty"`
+}
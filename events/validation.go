@@ -0,0 +1,265 @@
+package events
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidPayload is matched by every ValidationError
+var ErrInvalidPayload = errors.New("invalid payload")
+
+// ValidationError lists the validation rules a payload violates
+type ValidationError struct {
+	EventType  EventType
+	Violations []string
+}
+
+// Error returns the violations of the payload
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s payload: %s", e.EventType, strings.Join(e.Violations, "; "))
+}
+
+// Unwrap returns ErrInvalidPayload
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalidPayload
+}
+
+// Payload fields are validated with a validate struct tag holding a comma
+// separated list of rules:
+//
+//	required  strings must not be empty, other values must not be zero
+//	nonempty  maps and slices must have at least one element
+//	min=N     numbers must be at least N
+//	max=N     numbers must be at most N
+//	rfc3339   strings must be RFC3339 timestamps unless empty
+//
+// Nested structs, and structs in slices, are validated as well.
+
+// fieldRule is a parsed validation rule of a struct field
+type fieldRule struct {
+	name  string
+	limit float64
+}
+
+// fieldRules holds the validation rules of a struct field
+type fieldRules struct {
+	index []int
+	name  string
+	rules []fieldRule
+}
+
+// structRules caches the parsed rules of payload types
+var structRules sync.Map
+
+// ValidatePayload checks a payload against the validate tags of its type.
+// Payloads that are not structs, such as maps, are not validated.
+func ValidatePayload(eventType EventType, payload interface{}) error {
+	var violations []string
+	if err := validateValue(reflect.ValueOf(payload), "", &violations); err != nil {
+		return err
+	}
+
+	if len(violations) > 0 {
+		return &ValidationError{EventType: eventType, Violations: violations}
+	}
+	return nil
+}
+
+// Validate decodes the payload of an event with DefaultRegistry and validates it.
+// Events of unregistered types are not validated.
+func Validate(event *Event) error {
+	payload, err := DefaultRegistry.Decode(event)
+	if errors.Is(err, ErrUnregisteredEventType) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return ValidatePayload(event.Type, payload)
+}
+
+// validateValue validates structs and the structs held by slices
+func validateValue(value reflect.Value, path string, violations *[]string) error {
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+
+	switch value.Kind() {
+	case reflect.Struct:
+		return validateStruct(value, path, violations)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			if err := validateValue(value.Index(i), fmt.Sprintf("%s[%d]", path, i), violations); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateStruct applies the rules of every field of a struct
+func validateStruct(value reflect.Value, path string, violations *[]string) error {
+	fields, err := rulesOf(value.Type())
+	if err != nil {
+		return err
+	}
+
+	for _, field := range fields {
+		fieldValue := value.FieldByIndex(field.index)
+		fieldPath := field.name
+		if path != "" {
+			fieldPath = path + "." + field.name
+		}
+
+		for _, rule := range field.rules {
+			if violation := checkRule(rule, fieldValue); violation != "" {
+				*violations = append(*violations, fieldPath+" "+violation)
+			}
+		}
+
+		if err := validateValue(fieldValue, fieldPath, violations); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkRule returns the violation of a rule by a field value, or an empty string
+func checkRule(rule fieldRule, value reflect.Value) string {
+	switch rule.name {
+	case "required":
+		if value.IsZero() {
+			return "is required"
+		}
+	case "nonempty":
+		if value.Len() == 0 {
+			return "must not be empty"
+		}
+	case "min":
+		if number(value) < rule.limit {
+			return fmt.Sprintf("must be at least %v", rule.limit)
+		}
+	case "max":
+		if number(value) > rule.limit {
+			return fmt.Sprintf("must be at most %v", rule.limit)
+		}
+	case "rfc3339":
+		if s := value.String(); s != "" {
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				return "must be an RFC3339 timestamp"
+			}
+		}
+	}
+	return ""
+}
+
+// number returns the value of a numeric field as a float64
+func number(value reflect.Value) float64 {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint())
+	default:
+		return value.Float()
+	}
+}
+
+// rulesOf returns the parsed rules of a struct type
+func rulesOf(structType reflect.Type) ([]fieldRules, error) {
+	if cached, ok := structRules.Load(structType); ok {
+		return cached.([]fieldRules), nil
+	}
+
+	var fields []fieldRules
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		rules, err := parseRules(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid validate tag on %s.%s: %w", structType.Name(), field.Name, err)
+		}
+
+		fields = append(fields, fieldRules{
+			index: field.Index,
+			name:  jsonName(field),
+			rules: rules,
+		})
+	}
+
+	structRules.Store(structType, fields)
+	return fields, nil
+}
+
+// parseRules parses the validate tag of a struct field, checking each rule applies to the field type
+func parseRules(field reflect.StructField) ([]fieldRule, error) {
+	tag := field.Tag.Get("validate")
+	if tag == "" {
+		return nil, nil
+	}
+
+	kind := field.Type.Kind()
+	var rules []fieldRule
+	for _, part := range strings.Split(tag, ",") {
+		name, arg, _ := strings.Cut(part, "=")
+		rule := fieldRule{name: name}
+
+		switch name {
+		case "required":
+		case "nonempty":
+			if kind != reflect.Map && kind != reflect.Slice && kind != reflect.Array && kind != reflect.String {
+				return nil, fmt.Errorf("nonempty does not apply to %s", kind)
+			}
+		case "min", "max":
+			if !isNumber(kind) {
+				return nil, fmt.Errorf("%s does not apply to %s", name, kind)
+			}
+			limit, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s limit %q", name, arg)
+			}
+			rule.limit = limit
+		case "rfc3339":
+			if kind != reflect.String {
+				return nil, fmt.Errorf("rfc3339 does not apply to %s", kind)
+			}
+		default:
+			return nil, fmt.Errorf("unknown rule %q", name)
+		}
+
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// isNumber reports whether a kind is an integer or floating point kind
+func isNumber(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// jsonName returns the JSON name of a struct field, used in violations
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
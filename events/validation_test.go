@@ -0,0 +1,188 @@
+package events
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type requiredPayload struct {
+	Name  string         `json:"name" validate:"required"`
+	Count int            `json:"count" validate:"required"`
+	Extra map[string]int `json:"extra" validate:"required"`
+}
+
+type nonemptyPayload struct {
+	Tags     []string           `json:"tags" validate:"nonempty"`
+	Emotions map[string]float64 `json:"emotions" validate:"nonempty"`
+}
+
+type rangePayload struct {
+	Score  float64 `json:"score" validate:"min=-1,max=1"`
+	Tokens int     `json:"tokens" validate:"min=0"`
+	Level  uint8   `json:"level" validate:"max=5"`
+}
+
+type timestampPayload struct {
+	At string `json:"at" validate:"rfc3339"`
+}
+
+type nestedPayload struct {
+	Owner requiredPayload    `json:"owner"`
+	Items []timestampPayload `json:"items"`
+}
+
+func TestValidatePayloadRules(t *testing.T) {
+	tests := []struct {
+		name       string
+		payload    interface{}
+		violations []string
+	}{
+		{
+			name:    "required satisfied",
+			payload: requiredPayload{Name: "a", Count: 1, Extra: map[string]int{}},
+		},
+		{
+			name:       "required missing",
+			payload:    requiredPayload{},
+			violations: []string{"name is required", "count is required", "extra is required"},
+		},
+		{
+			name:    "nonempty satisfied",
+			payload: nonemptyPayload{Tags: []string{"a"}, Emotions: map[string]float64{"joy": 1}},
+		},
+		{
+			name:       "nonempty empty",
+			payload:    nonemptyPayload{Tags: []string{}},
+			violations: []string{"tags must not be empty", "emotions must not be empty"},
+		},
+		{
+			name:    "range at the limits",
+			payload: rangePayload{Score: -1, Tokens: 0, Level: 5},
+		},
+		{
+			name:       "range below min",
+			payload:    rangePayload{Score: -1.5, Tokens: -1},
+			violations: []string{"score must be at least -1", "tokens must be at least 0"},
+		},
+		{
+			name:       "range above max",
+			payload:    rangePayload{Score: 1.5, Level: 6},
+			violations: []string{"score must be at most 1", "level must be at most 5"},
+		},
+		{
+			name:    "rfc3339 valid",
+			payload: timestampPayload{At: "2026-03-14T09:26:53Z"},
+		},
+		{
+			name:    "rfc3339 empty",
+			payload: timestampPayload{},
+		},
+		{
+			name:       "rfc3339 invalid",
+			payload:    timestampPayload{At: "14/03/2026"},
+			violations: []string{"at must be an RFC3339 timestamp"},
+		},
+		{
+			name: "nested structs and slices",
+			payload: &nestedPayload{
+				Owner: requiredPayload{Name: "a", Extra: map[string]int{}},
+				Items: []timestampPayload{{At: "2026-03-14T09:26:53Z"}, {At: "yesterday"}},
+			},
+			violations: []string{"owner.count is required", "items[1].at must be an RFC3339 timestamp"},
+		},
+		{
+			name:    "maps are not validated",
+			payload: map[string]interface{}{"name": ""},
+		},
+		{
+			name:    "nil pointers are not validated",
+			payload: (*requiredPayload)(nil),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePayload("Test", tt.payload)
+			if len(tt.violations) == 0 {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("expected a ValidationError, got %v", err)
+			}
+			if !errors.Is(err, ErrInvalidPayload) {
+				t.Fatalf("expected the error to match ErrInvalidPayload")
+			}
+			if strings.Join(validationErr.Violations, "; ") != strings.Join(tt.violations, "; ") {
+				t.Fatalf("expected violations %q, got %q", tt.violations, validationErr.Violations)
+			}
+		})
+	}
+}
+
+func TestValidatePayloadRejectsInvalidTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload interface{}
+	}{
+		{"unknown rule", struct {
+			Name string `validate:"unique"`
+		}{}},
+		{"min on a string", struct {
+			Name string `validate:"min=1"`
+		}{}},
+		{"invalid limit", struct {
+			Count int `validate:"max=many"`
+		}{}},
+		{"nonempty on a number", struct {
+			Count int `validate:"nonempty"`
+		}{}},
+		{"rfc3339 on a number", struct {
+			At int64 `validate:"rfc3339"`
+		}{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePayload("Test", tt.payload)
+			if err == nil || errors.Is(err, ErrInvalidPayload) {
+				t.Fatalf("expected an invalid tag error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestNewEventValidatesPayload(t *testing.T) {
+	_, err := NewEvent(DiarySessionEndedEvent, "session-1", 2, DiarySessionEndedPayload{
+		SessionID: "session-1",
+		EndTime:   "not a time",
+	}, nil)
+	if !errors.Is(err, ErrInvalidPayload) {
+		t.Fatalf("expected ErrInvalidPayload, got %v", err)
+	}
+
+	_, err = NewEvent(DiarySessionEndedEvent, "session-1", 2, DiarySessionEndedPayload{
+		SessionID: "session-1",
+		EndTime:   "2026-03-14T09:26:53Z",
+	}, nil)
+	if err != nil {
+		t.Fatalf("expected a valid event, got %v", err)
+	}
+}
+
+func TestValidateDecodesRegisteredPayloads(t *testing.T) {
+	invalid := &Event{Type: MoodAnalyzedEvent, Payload: []byte(`{"diary_entry_id":"entry-1","emotions":{"joy":0.9},"valence":2}`)}
+	if err := Validate(invalid); !errors.Is(err, ErrInvalidPayload) {
+		t.Fatalf("expected ErrInvalidPayload, got %v", err)
+	}
+
+	unregistered := &Event{Type: "SomethingElse", Payload: []byte(`{}`)}
+	if err := Validate(unregistered); err != nil {
+		t.Fatalf("expected events of unregistered types not to be validated, got %v", err)
+	}
+}
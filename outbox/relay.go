@@ -0,0 +1,327 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kegazani/metachat-event-sourcing/bus"
+	"github.com/kegazani/metachat-event-sourcing/events"
+	"github.com/kegazani/metachat-event-sourcing/store"
+)
+
+// DefaultRelayName is the checkpoint name of a relay when none is configured
+const DefaultRelayName = "outbox"
+
+// defaultRelayRetry is the backoff between failed publish attempts when none is configured
+var defaultRelayRetry = bus.RetryPolicy{
+	InitialDelay: 100 * time.Millisecond,
+	MaxDelay:     30 * time.Second,
+	Multiplier:   2,
+}
+
+// RelayOptions configures a Relay
+type RelayOptions struct {
+	// Name identifies the checkpoint of the relay. Relays publishing to
+	// different buses from the same store need different names.
+	Name string
+
+	// Filter selects the events to publish, every event by default
+	Filter store.SubscriptionFilter
+
+	// Retry is the backoff between attempts to publish an event or to resume
+	// reading the store after a failure. MaxDeliver is ignored: the relay never
+	// skips an event, it retries until it is published or Run is stopped.
+	Retry bus.RetryPolicy
+
+	// CheckpointInterval is the number of published events between checkpoint
+	// saves, 1 by default. Events published since the last save are published
+	// again after a restart.
+	CheckpointInterval int
+}
+
+// RelayStats are the metrics of a relay
+type RelayStats struct {
+	Name string
+
+	// Checkpoint is the global position of the last published event
+	Checkpoint uint64
+
+	// Published counts the events published since the relay was created
+	Published uint64
+
+	// Failures counts the failed publish attempts, store reads and checkpoint saves
+	Failures uint64
+
+	// LastPublishedAt is when the last event was published
+	LastPublishedAt time.Time
+
+	// Lag is the time between the last published event being stored and being published
+	Lag time.Duration
+
+	// Skipped counts the global positions the store subscription skipped
+	// because no event was committed at them in time. An event committed later
+	// at a skipped position is not published by the relay.
+	Skipped uint64
+
+	// LastGap is the last range of skipped positions, zero if there was none
+	LastGap Gap
+
+	// LastError is the last failure, nil if there was none
+	LastError   error
+	LastErrorAt time.Time
+}
+
+// Gap is a range of global positions skipped by a relay
+type Gap struct {
+	First     uint64
+	Last      uint64
+	SkippedAt time.Time
+}
+
+// Relay publishes the events of an event store to an event bus, making the
+// store's global log a transactional outbox: saving events records them for
+// publication in the same write, and the relay tails the log from its
+// checkpoint and publishes them. Delivery is at least once, since events
+// published after the last saved checkpoint are published again after a
+// crash; NATSEventBus drops these duplicates within the stream's duplicate
+// window by event ID, other subscribers must be idempotent.
+//
+// Stores committing positions out of order, Cassandra and SQL, pause the
+// relay at a missing position and skip it after a timeout, assuming its
+// append failed. An append committed after that, such as a SQL transaction
+// held open longer than the timeout, is not published: skipped positions are
+// counted in the stats and recorded as failures so they can be checked and
+// the events republished.
+type Relay struct {
+	eventStore  store.EventStore
+	subscriber  store.EventSubscriber
+	eventBus    bus.EventBus
+	checkpoints store.CheckpointStore
+	options     RelayOptions
+
+	mu        sync.Mutex
+	stats     RelayStats
+	unsaved   int
+	isRunning bool
+}
+
+// NewRelay creates a relay from an event store that can be tailed to an event bus
+func NewRelay(eventStore store.EventStore, eventBus bus.EventBus, checkpoints store.CheckpointStore, options RelayOptions) (*Relay, error) {
+	subscriber, ok := eventStore.(store.EventSubscriber)
+	if !ok {
+		return nil, fmt.Errorf("event store %T does not support subscriptions", eventStore)
+	}
+
+	if options.Name == "" {
+		options.Name = DefaultRelayName
+	}
+	if options.Retry.InitialDelay <= 0 {
+		options.Retry = defaultRelayRetry
+	}
+	if options.CheckpointInterval <= 0 {
+		options.CheckpointInterval = 1
+	}
+
+	return &Relay{
+		eventStore:  eventStore,
+		subscriber:  subscriber,
+		eventBus:    eventBus,
+		checkpoints: checkpoints,
+		options:     options,
+		stats:       RelayStats{Name: options.Name},
+	}, nil
+}
+
+// Run publishes the stored events after the checkpoint, then the events saved
+// later, until the context is cancelled. Store failures are retried, so Run
+// only returns the context error, or an error if the checkpoint cannot be
+// loaded or the relay is already running.
+func (r *Relay) Run(ctx context.Context) error {
+	r.mu.Lock()
+	if r.isRunning {
+		r.mu.Unlock()
+		return errors.New("outbox relay is already running")
+	}
+	r.isRunning = true
+	r.mu.Unlock()
+
+	defer func() {
+		r.mu.Lock()
+		r.isRunning = false
+		r.mu.Unlock()
+	}()
+
+	position, err := r.checkpoints.GetCheckpoint(ctx, r.options.Name)
+	if err != nil {
+		return fmt.Errorf("failed to load outbox checkpoint: %w", err)
+	}
+
+	r.mu.Lock()
+	r.stats.Checkpoint = position
+	r.unsaved = 0
+	r.mu.Unlock()
+
+	// Events published after the last save are recorded when the relay stops
+	defer r.flush(context.WithoutCancel(ctx))
+
+	subscribeCtx := store.ContextWithGapHandler(ctx, r.skip)
+
+	for attempt := 1; ; attempt++ {
+		from := r.checkpoint()
+		err := r.subscriber.Subscribe(subscribeCtx, from, r.options.Filter, r.publish)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		r.fail(err)
+
+		// The backoff only grows while the relay makes no progress
+		if r.checkpoint() != from {
+			attempt = 1
+		}
+
+		if !sleep(ctx, r.options.Retry.Delay(attempt)) {
+			return ctx.Err()
+		}
+	}
+}
+
+// publish publishes one event, retrying until it succeeds, and advances the checkpoint
+func (r *Relay) publish(ctx context.Context, event *events.Event) error {
+	for attempt := 1; ; attempt++ {
+		err := r.eventBus.Publish(ctx, event)
+		if err == nil {
+			break
+		}
+		r.fail(fmt.Errorf("failed to publish event %s: %w", event.ID, err))
+
+		if !sleep(ctx, r.options.Retry.Delay(attempt)) {
+			return ctx.Err()
+		}
+	}
+
+	now := time.Now()
+
+	r.mu.Lock()
+	r.stats.Checkpoint = event.Position
+	r.stats.Published++
+	r.stats.LastPublishedAt = now
+	r.stats.Lag = now.Sub(event.Timestamp)
+	r.unsaved++
+	due := r.unsaved >= r.options.CheckpointInterval
+	r.mu.Unlock()
+
+	if due {
+		r.flush(ctx)
+	}
+	return nil
+}
+
+// flush saves the checkpoint if events were published since the last save.
+// A failed save is only recorded: the events are published again after a restart.
+func (r *Relay) flush(ctx context.Context) {
+	r.mu.Lock()
+	position, unsaved := r.stats.Checkpoint, r.unsaved
+	r.mu.Unlock()
+
+	if unsaved == 0 {
+		return
+	}
+
+	if err := r.checkpoints.SaveCheckpoint(ctx, r.options.Name, position); err != nil {
+		r.fail(fmt.Errorf("failed to save outbox checkpoint: %w", err))
+		return
+	}
+
+	r.mu.Lock()
+	r.unsaved -= unsaved
+	r.mu.Unlock()
+}
+
+// skip records positions skipped by the store subscription
+func (r *Relay) skip(ctx context.Context, first, last uint64) {
+	r.fail(fmt.Errorf("skipped positions %d to %d: no event was committed within the gap timeout", first, last))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stats.Skipped += last - first + 1
+	r.stats.LastGap = Gap{First: first, Last: last, SkippedAt: time.Now()}
+}
+
+// checkpoint returns the position of the last published event
+func (r *Relay) checkpoint() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.stats.Checkpoint
+}
+
+// fail records a failure in the stats
+func (r *Relay) fail(err error) {
+	if err == nil {
+		err = errors.New("subscription ended")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stats.Failures++
+	r.stats.LastError = err
+	r.stats.LastErrorAt = time.Now()
+}
+
+// Stats returns the metrics of the relay
+func (r *Relay) Stats() RelayStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.stats
+}
+
+// Pending counts the events of the store waiting to be published, up to limit,
+// or all of them if limit is 0. Together with Stats().Lag it measures how far
+// the relay is behind.
+func (r *Relay) Pending(ctx context.Context, limit int) (int, error) {
+	position := r.checkpoint()
+	pending := 0
+
+	for {
+		batch, err := r.eventStore.ReadAll(ctx, position, pendingBatchSize)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, event := range batch {
+			if r.options.Filter.Matches(event) {
+				pending++
+				if limit > 0 && pending >= limit {
+					return pending, nil
+				}
+			}
+			position = event.Position
+		}
+
+		if len(batch) < pendingBatchSize {
+			return pending, nil
+		}
+	}
+}
+
+// pendingBatchSize is the number of events read per round trip while counting pending events
+const pendingBatchSize = 500
+
+// sleep waits for a delay, reporting false if the context is done first
+func sleep(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,212 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kegazani/metachat-event-sourcing/bus"
+	"github.com/kegazani/metachat-event-sourcing/events"
+	"github.com/kegazani/metachat-event-sourcing/store"
+)
+
+// testRetry keeps the backoff of failed publishes short
+var testRetry = bus.RetryPolicy{
+	InitialDelay: time.Millisecond,
+	MaxDelay:     10 * time.Millisecond,
+	Multiplier:   2,
+}
+
+// appendDiaryEvents appends count events to a diary stream holding version events
+func appendDiaryEvents(t *testing.T, eventStore store.EventStore, aggregateID string, version, count int) {
+	t.Helper()
+
+	eventList := make([]*events.Event, 0, count)
+	for v := version + 1; v <= version+count; v++ {
+		var event *events.Event
+		var err error
+		if v == 1 {
+			event, err = events.NewEvent(events.DiaryEntryCreatedEvent, aggregateID, v, events.DiaryEntryCreatedPayload{
+				UserID: "user-1",
+				Title:  "First entry",
+			}, nil)
+		} else {
+			event, err = events.NewEvent(events.DiaryEntryUpdatedEvent, aggregateID, v, events.DiaryEntryUpdatedPayload{
+				Content: "Updated",
+			}, nil)
+		}
+		if err != nil {
+			t.Fatalf("failed to create event: %v", err)
+		}
+		eventList = append(eventList, event)
+	}
+
+	if err := eventStore.AppendToStream(context.Background(), aggregateID, version, eventList); err != nil {
+		t.Fatalf("failed to append events: %v", err)
+	}
+}
+
+// runRelay runs a relay until stop is called, which returns the error of Run
+func runRelay(t *testing.T, relay *Relay) (stop func() error) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- relay.Run(ctx)
+	}()
+
+	return func() error {
+		cancel()
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("relay did not stop")
+			return nil
+		}
+	}
+}
+
+// waitPublished waits until a bus has published count events
+func waitPublished(t *testing.T, eventBus *bus.InMemoryEventBus, count int) []*events.Event {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		published := eventBus.Published()
+		if len(published) >= count {
+			return published
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d published events, got %d", count, len(published))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// assertPositions checks the global positions of published events
+func assertPositions(t *testing.T, published []*events.Event, positions ...uint64) {
+	t.Helper()
+
+	if len(published) != len(positions) {
+		t.Fatalf("expected %d published events, got %d", len(positions), len(published))
+	}
+	for i, event := range published {
+		if event.Position != positions[i] {
+			t.Fatalf("expected position %d at index %d, got %d", positions[i], i, event.Position)
+		}
+	}
+}
+
+// flakyBus fails the first publishes before delegating to an in-memory bus
+type flakyBus struct {
+	*bus.InMemoryEventBus
+
+	mu       sync.Mutex
+	failures int
+}
+
+// Publish fails while failures remain
+func (b *flakyBus) Publish(ctx context.Context, event *events.Event) error {
+	b.mu.Lock()
+	if b.failures > 0 {
+		b.failures--
+		b.mu.Unlock()
+		return errors.New("bus unavailable")
+	}
+	b.mu.Unlock()
+
+	return b.InMemoryEventBus.Publish(ctx, event)
+}
+
+func TestRelayResumesFromCheckpoint(t *testing.T) {
+	eventStore := store.NewMemoryEventStore()
+	checkpoints := store.NewMemoryCheckpointStore()
+	appendDiaryEvents(t, eventStore, "diary-1", 0, 3)
+
+	firstBus := bus.NewInMemoryEventBus(bus.InMemoryEventBusOptions{})
+	relay, err := NewRelay(eventStore, firstBus, checkpoints, RelayOptions{Retry: testRetry})
+	if err != nil {
+		t.Fatalf("failed to create relay: %v", err)
+	}
+
+	stop := runRelay(t, relay)
+	assertPositions(t, waitPublished(t, firstBus, 3), 1, 2, 3)
+	if err := stop(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	checkpoint, err := checkpoints.GetCheckpoint(context.Background(), DefaultRelayName)
+	if err != nil || checkpoint != 3 {
+		t.Fatalf("expected checkpoint 3, got %d (%v)", checkpoint, err)
+	}
+
+	// A new relay only publishes the events stored after the checkpoint
+	appendDiaryEvents(t, eventStore, "diary-1", 3, 2)
+
+	secondBus := bus.NewInMemoryEventBus(bus.InMemoryEventBusOptions{})
+	relay, err = NewRelay(eventStore, secondBus, checkpoints, RelayOptions{Retry: testRetry})
+	if err != nil {
+		t.Fatalf("failed to create relay: %v", err)
+	}
+
+	stop = runRelay(t, relay)
+	waitPublished(t, secondBus, 2)
+	stop()
+
+	assertPositions(t, secondBus.Published(), 4, 5)
+	if stats := relay.Stats(); stats.Checkpoint != 5 || stats.Published != 2 {
+		t.Fatalf("expected checkpoint 5 after 2 events, got %d after %d", stats.Checkpoint, stats.Published)
+	}
+}
+
+func TestRelayRetriesFailedPublishes(t *testing.T) {
+	eventStore := store.NewMemoryEventStore()
+	checkpoints := store.NewMemoryCheckpointStore()
+	appendDiaryEvents(t, eventStore, "diary-1", 0, 3)
+
+	eventBus := &flakyBus{InMemoryEventBus: bus.NewInMemoryEventBus(bus.InMemoryEventBusOptions{}), failures: 4}
+	relay, err := NewRelay(eventStore, eventBus, checkpoints, RelayOptions{Retry: testRetry})
+	if err != nil {
+		t.Fatalf("failed to create relay: %v", err)
+	}
+
+	stop := runRelay(t, relay)
+	waitPublished(t, eventBus.InMemoryEventBus, 3)
+	stop()
+
+	// The failing event is retried in place, so none is skipped or reordered
+	assertPositions(t, eventBus.Published(), 1, 2, 3)
+
+	stats := relay.Stats()
+	if stats.Failures != 4 || stats.LastError == nil {
+		t.Fatalf("expected 4 recorded failures, got %d (last error %v)", stats.Failures, stats.LastError)
+	}
+	if stats.Checkpoint != 3 || stats.Published != 3 {
+		t.Fatalf("expected checkpoint 3 after 3 events, got %d after %d", stats.Checkpoint, stats.Published)
+	}
+}
+
+func TestRelaySavesCheckpointWhenStopped(t *testing.T) {
+	eventStore := store.NewMemoryEventStore()
+	checkpoints := store.NewMemoryCheckpointStore()
+	appendDiaryEvents(t, eventStore, "diary-1", 0, 3)
+
+	eventBus := bus.NewInMemoryEventBus(bus.InMemoryEventBusOptions{})
+	relay, err := NewRelay(eventStore, eventBus, checkpoints, RelayOptions{Retry: testRetry, CheckpointInterval: 10})
+	if err != nil {
+		t.Fatalf("failed to create relay: %v", err)
+	}
+
+	stop := runRelay(t, relay)
+	waitPublished(t, eventBus, 3)
+	stop()
+
+	checkpoint, err := checkpoints.GetCheckpoint(context.Background(), DefaultRelayName)
+	if err != nil || checkpoint != 3 {
+		t.Fatalf("expected checkpoint 3 saved on stop, got %d (%v)", checkpoint, err)
+	}
+}
@@ -0,0 +1,322 @@
+package privacy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kegazani/metachat-event-sourcing/events"
+	"github.com/kegazani/metachat-event-sourcing/repository"
+	"github.com/kegazani/metachat-event-sourcing/store"
+)
+
+// Erasure methods recorded in an ErasureReport
+const (
+	// MethodRedacted means the personal fields were removed from the stored events
+	MethodRedacted = "redacted"
+
+	// MethodShredded means the data key encrypting the personal fields was deleted
+	MethodShredded = "shredded"
+
+	// MethodNone means the data of the aggregate could not be erased
+	MethodNone = "none"
+)
+
+// PersonalDataFields are the payload fields holding personal data, by event type
+var PersonalDataFields = map[events.EventType][]string{
+	events.UserRegisteredEvent:        {"username", "email", "first_name", "last_name", "date_of_birth"},
+	events.UserProfileUpdatedEvent:    {"first_name", "last_name", "date_of_birth", "avatar", "bio"},
+	events.DiaryEntryCreatedEvent:     {"title", "content", "tags"},
+	events.DiaryEntryUpdatedEvent:     {"title", "content", "tags"},
+	events.MoodAnalyzedEvent:          {"keywords", "topics"},
+	events.DailyMoodAggregatedEvent:   {"keywords", "topics"},
+	events.WeeklyMoodAggregatedEvent:  {"keywords", "topics"},
+	events.MonthlyMoodAggregatedEvent: {"keywords", "topics"},
+	events.UserPortraitUpdatedEvent:   {"thematic_profile"},
+}
+
+// userLinkedEventTypes are the event types linking an aggregate to a user through their user_id
+var userLinkedEventTypes = []events.EventType{
+	events.DiaryEntryCreatedEvent,
+	events.DiarySessionStartedEvent,
+	events.DailyMoodAggregatedEvent,
+	events.WeeklyMoodAggregatedEvent,
+	events.MonthlyMoodAggregatedEvent,
+	events.UserPortraitUpdatedEvent,
+}
+
+// ReadModelEraser erases the personal data of a user from a read model
+type ReadModelEraser interface {
+	// Name identifies the read model in erasure reports
+	Name() string
+
+	// EraseUser erases the data of a user and of the aggregates linked to them
+	EraseUser(ctx context.Context, userID string, aggregateIDs []string) error
+}
+
+// LinkedAggregate is an aggregate holding data of a user
+type LinkedAggregate struct {
+	AggregateID   string               `json:"aggregate_id"`
+	AggregateType events.AggregateType `json:"aggregate_type"`
+}
+
+// AggregateErasure records how the data of one aggregate was erased
+type AggregateErasure struct {
+	LinkedAggregate
+	Method           string `json:"method"`
+	RedactedEvents   int    `json:"redacted_events,omitempty"`
+	SnapshotsDeleted bool   `json:"snapshots_deleted"`
+	Error            string `json:"error,omitempty"`
+}
+
+// ReadModelErasure records the erasure of a user from one read model
+type ReadModelErasure struct {
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+}
+
+// ErasureReport is the auditable record of a forget-user operation
+type ErasureReport struct {
+	UserID           string             `json:"user_id"`
+	Reason           string             `json:"reason,omitempty"`
+	ForgottenEventID string             `json:"forgotten_event_id,omitempty"`
+	KeyShredded      bool               `json:"key_shredded"`
+	Aggregates       []AggregateErasure `json:"aggregates"`
+	ReadModels       []ReadModelErasure `json:"read_models"`
+	Errors           []string           `json:"errors,omitempty"`
+	StartedAt        time.Time          `json:"started_at"`
+	CompletedAt      time.Time          `json:"completed_at"`
+}
+
+// Succeeded reports whether all personal data was erased
+func (r *ErasureReport) Succeeded() bool {
+	if len(r.Errors) > 0 {
+		return false
+	}
+	for _, aggregate := range r.Aggregates {
+		if aggregate.Error != "" || aggregate.Method == MethodNone {
+			return false
+		}
+	}
+	for _, readModel := range r.ReadModels {
+		if readModel.Error != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// UserForgetter erases the personal data of users on request
+type UserForgetter struct {
+	eventStore    store.EventStore
+	snapshotStore store.SnapshotStore
+	readModels    []ReadModelEraser
+}
+
+// NewUserForgetter creates a user forgetter. The snapshot store may be nil.
+func NewUserForgetter(eventStore store.EventStore, snapshotStore store.SnapshotStore, readModels ...ReadModelEraser) *UserForgetter {
+	return &UserForgetter{
+		eventStore:    eventStore,
+		snapshotStore: snapshotStore,
+		readModels:    readModels,
+	}
+}
+
+// ErrErasureNotSupported is returned by ForgetUser when the event store can
+// neither redact events nor crypto-shred them
+var ErrErasureNotSupported = errors.New("event store supports neither redaction nor crypto-shredding")
+
+// ForgetUser erases the personal data of a user from every linked aggregate,
+// their snapshots and the read models, and records a UserForgotten event.
+// Events are redacted if the event store supports it, and the user's data key
+// is shredded if the store encrypts them; shredding only erases the fields the
+// store was configured to encrypt. Linked aggregates are erased even if the
+// user aggregate does not exist, in which case no event is recorded. It is
+// safe to retry: a user already forgotten is erased again without recording a
+// second event.
+// ErrErasureNotSupported is returned, before anything is recorded, if the
+// store can erase nothing. Other errors are returned only if the operation
+// could not start or the event could not be recorded; failures of individual
+// steps are recorded in the report.
+func (f *UserForgetter) ForgetUser(ctx context.Context, userID, reason string) (*ErasureReport, error) {
+	report := &ErasureReport{
+		UserID:    userID,
+		Reason:    reason,
+		StartedAt: time.Now().UTC(),
+	}
+
+	redactor, canRedact := f.eventStore.(store.EventRedactor)
+	forgetter, canShred := f.eventStore.(store.SubjectForgetter)
+	if !canRedact && !canShred {
+		return nil, ErrErasureNotSupported
+	}
+
+	linked, err := f.FindLinkedAggregates(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Diary data may outlive the user aggregate, so a missing user is still erased
+	users := repository.NewUserRepository(f.eventStore)
+	user, err := users.Load(ctx, userID)
+	if err != nil && !errors.Is(err, store.ErrEventNotFound) {
+		return nil, err
+	}
+
+	if canShred {
+		err := forgetter.ForgetSubject(ctx, userID)
+		switch {
+		case err == nil:
+			report.KeyShredded = true
+		case errors.Is(err, store.ErrNotSupported):
+			canShred = false
+		default:
+			report.Errors = append(report.Errors, fmt.Sprintf("failed to shred data key: %v", err))
+		}
+	}
+
+	aggregateIDs := make([]string, 0, len(linked))
+	for _, aggregate := range linked {
+		aggregateIDs = append(aggregateIDs, aggregate.AggregateID)
+
+		erasure := AggregateErasure{LinkedAggregate: aggregate, Method: MethodNone}
+
+		if canRedact {
+			redacted, err := redactor.RedactStream(ctx, aggregate.AggregateID, PersonalDataFields)
+			if errors.Is(err, store.ErrNotSupported) {
+				// Decorators forward redaction to stores that may not support it
+				canRedact = false
+			} else {
+				erasure.Method = MethodRedacted
+				erasure.RedactedEvents = redacted
+				if err != nil {
+					erasure.Error = err.Error()
+				}
+			}
+		}
+
+		if !canRedact {
+			if !canShred {
+				return nil, ErrErasureNotSupported
+			}
+			if report.KeyShredded {
+				erasure.Method = MethodShredded
+			} else {
+				erasure.Error = "data key was not shredded"
+			}
+		}
+
+		if deleter, ok := f.snapshotStore.(store.SnapshotDeleter); ok {
+			err := deleter.DeleteSnapshots(ctx, aggregate.AggregateID)
+			erasure.SnapshotsDeleted = err == nil
+			if err != nil && erasure.Error == "" {
+				erasure.Error = err.Error()
+			}
+		}
+
+		report.Aggregates = append(report.Aggregates, erasure)
+	}
+
+	if user != nil && !user.IsForgotten() {
+		if err := user.Forget(reason); err != nil {
+			return nil, err
+		}
+
+		forgotten := user.GetUncommittedEvents()[0]
+		if err := users.Save(ctx, user); err != nil {
+			return nil, err
+		}
+		report.ForgottenEventID = forgotten.ID
+	}
+
+	for _, readModel := range f.readModels {
+		erasure := ReadModelErasure{Name: readModel.Name()}
+		if err := readModel.EraseUser(ctx, userID, aggregateIDs); err != nil {
+			erasure.Error = err.Error()
+		}
+		report.ReadModels = append(report.ReadModels, erasure)
+	}
+
+	report.CompletedAt = time.Now().UTC()
+	return report, nil
+}
+
+// FindLinkedAggregates returns the user aggregate followed by every aggregate
+// holding data of the user: diary entries, sessions, mood analyses of their
+// entries, mood aggregations and portraits.
+//
+// The links are not indexed: every event of the seven linking types is read
+// and decoded, so the cost grows with the whole store rather than with the
+// user's data. This is acceptable for occasional erasure requests; stores
+// with many users should run it off-peak or batch requests together.
+func (f *UserForgetter) FindLinkedAggregates(ctx context.Context, userID string) ([]LinkedAggregate, error) {
+	linked := []LinkedAggregate{{AggregateID: userID, AggregateType: events.UserAggregateType}}
+	seen := map[string]bool{userID: true}
+	diaryEntries := make(map[string]bool)
+
+	add := func(event *events.Event) {
+		if seen[event.AggregateID] {
+			return
+		}
+		seen[event.AggregateID] = true
+
+		aggregateType := event.AggregateType
+		if aggregateType == "" {
+			aggregateType = events.AggregateTypeOf(event.Type)
+		}
+		linked = append(linked, LinkedAggregate{AggregateID: event.AggregateID, AggregateType: aggregateType})
+	}
+
+	for _, eventType := range userLinkedEventTypes {
+		err := f.forEachEvent(ctx, eventType, func(event *events.Event) error {
+			var payload struct {
+				UserID string `json:"user_id"`
+			}
+			if err := json.Unmarshal(event.Payload, &payload); err != nil || payload.UserID != userID {
+				return nil
+			}
+
+			if event.Type == events.DiaryEntryCreatedEvent {
+				diaryEntries[event.AggregateID] = true
+			}
+			add(event)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Mood analyses reference the diary entry they analyzed rather than the user
+	err := f.forEachEvent(ctx, events.MoodAnalyzedEvent, func(event *events.Event) error {
+		var payload events.MoodAnalyzedPayload
+		if err := json.Unmarshal(event.Payload, &payload); err == nil && diaryEntries[payload.DiaryEntryID] {
+			add(event)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return linked, nil
+}
+
+// forEachEvent streams the events of a type through a callback
+func (f *UserForgetter) forEachEvent(ctx context.Context, eventType events.EventType, fn func(*events.Event) error) error {
+	iterator, err := f.eventStore.IterateEventsByType(ctx, eventType, "")
+	if err != nil {
+		return err
+	}
+	defer iterator.Close()
+
+	for iterator.Next() {
+		if err := fn(iterator.Event()); err != nil {
+			return err
+		}
+	}
+
+	return iterator.Err()
+}
@@ -0,0 +1,191 @@
+package privacy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/kegazani/metachat-event-sourcing/events"
+	"github.com/kegazani/metachat-event-sourcing/store"
+)
+
+// appendTestEvent creates an event and appends it as the next one of its stream
+func appendTestEvent(t *testing.T, eventStore store.EventStore, eventType events.EventType, aggregateID string, version int, payload interface{}) {
+	t.Helper()
+
+	event, err := events.NewEvent(eventType, aggregateID, version, payload, nil)
+	if err != nil {
+		t.Fatalf("failed to create event: %v", err)
+	}
+	if err := eventStore.AppendToStream(context.Background(), aggregateID, version-1, []*events.Event{event}); err != nil {
+		t.Fatalf("failed to append %s: %v", eventType, err)
+	}
+}
+
+// seedUser stores a user with a diary entry and the mood analysis of that entry
+func seedUser(t *testing.T, eventStore store.EventStore, userID string) {
+	t.Helper()
+
+	appendTestEvent(t, eventStore, events.UserRegisteredEvent, userID, 1, events.UserRegisteredPayload{
+		Username:  userID,
+		Email:     userID + "@example.com",
+		FirstName: "Ada",
+	})
+	appendTestEvent(t, eventStore, events.DiaryEntryCreatedEvent, "diary-"+userID, 1, events.DiaryEntryCreatedPayload{
+		UserID:  userID,
+		Title:   "A rainy Tuesday",
+		Content: "Dear diary",
+		Tags:    []string{"rain"},
+	})
+	appendTestEvent(t, eventStore, events.MoodAnalyzedEvent, "mood-"+userID, 1, events.MoodAnalyzedPayload{
+		DiaryEntryID: "diary-" + userID,
+		Emotions:     map[string]float64{"calm": 0.8},
+		Keywords:     []string{"rain"},
+	})
+}
+
+// streamFields decodes the payload of the first event of a stream
+func streamFields(t *testing.T, eventStore store.EventStore, aggregateID string) map[string]interface{} {
+	t.Helper()
+
+	stored, err := eventStore.GetEventsByAggregateID(context.Background(), aggregateID)
+	if err != nil || len(stored) == 0 {
+		t.Fatalf("failed to read %s: %v", aggregateID, err)
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(stored[0].Payload, &fields); err != nil {
+		t.Fatalf("invalid payload: %v", err)
+	}
+	return fields
+}
+
+// countForgotten returns the number of UserForgotten events of a user
+func countForgotten(t *testing.T, eventStore store.EventStore, userID string) int {
+	t.Helper()
+
+	stored, err := eventStore.GetEventsByAggregateID(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", userID, err)
+	}
+
+	count := 0
+	for _, event := range stored {
+		if event.Type == events.UserForgottenEvent {
+			count++
+		}
+	}
+	return count
+}
+
+func TestForgetUserRedactsLinkedAggregates(t *testing.T) {
+	eventStore := store.NewMemoryEventStore()
+	seedUser(t, eventStore, "user-1")
+	seedUser(t, eventStore, "user-2")
+
+	report, err := NewUserForgetter(eventStore, store.NewMemorySnapshotStore()).ForgetUser(context.Background(), "user-1", "request")
+	if err != nil {
+		t.Fatalf("failed to forget user: %v", err)
+	}
+	if !report.Succeeded() || report.ForgottenEventID == "" {
+		t.Fatalf("expected a successful erasure recording UserForgotten, got %+v", report)
+	}
+
+	want := []string{"user-1", "diary-user-1", "mood-user-1"}
+	if len(report.Aggregates) != len(want) {
+		t.Fatalf("expected %d erased aggregates, got %+v", len(want), report.Aggregates)
+	}
+	for i, aggregate := range report.Aggregates {
+		if aggregate.AggregateID != want[i] || aggregate.Method != MethodRedacted {
+			t.Fatalf("expected %s to be redacted, got %+v", want[i], aggregate)
+		}
+	}
+
+	for aggregateID, fields := range map[string][]string{
+		"user-1":       {"username", "email", "first_name"},
+		"diary-user-1": {"title", "content", "tags"},
+		"mood-user-1":  {"keywords"},
+	} {
+		stored := streamFields(t, eventStore, aggregateID)
+		for _, field := range fields {
+			if _, ok := stored[field]; ok {
+				t.Fatalf("expected %s of %s to be redacted, got %v", field, aggregateID, stored)
+			}
+		}
+	}
+
+	// The data of other users is left alone
+	if content := streamFields(t, eventStore, "diary-user-2")["content"]; content != "Dear diary" {
+		t.Fatalf("expected the diary of user-2 to be kept, got %v", content)
+	}
+	if keywords := streamFields(t, eventStore, "mood-user-2")["keywords"]; keywords == nil {
+		t.Fatal("expected the mood analysis of user-2 to be kept")
+	}
+}
+
+func TestForgetUserRetryRecordsOneEvent(t *testing.T) {
+	eventStore := store.NewMemoryEventStore()
+	seedUser(t, eventStore, "user-1")
+	forgetter := NewUserForgetter(eventStore, nil)
+
+	if _, err := forgetter.ForgetUser(context.Background(), "user-1", "request"); err != nil {
+		t.Fatalf("failed to forget user: %v", err)
+	}
+
+	report, err := forgetter.ForgetUser(context.Background(), "user-1", "request")
+	if err != nil {
+		t.Fatalf("failed to retry: %v", err)
+	}
+	if report.ForgottenEventID != "" || len(report.Aggregates) != 3 {
+		t.Fatalf("expected the retry to erase again without recording an event, got %+v", report)
+	}
+	if count := countForgotten(t, eventStore, "user-1"); count != 1 {
+		t.Fatalf("expected one UserForgotten event, got %d", count)
+	}
+}
+
+func TestForgetUserShredsEncryptedFields(t *testing.T) {
+	eventStore := store.NewEncryptingEventStore(store.NewMemoryEventStore(), store.NewMemoryKeyStore(), nil)
+	seedUser(t, eventStore, "user-1")
+
+	report, err := NewUserForgetter(eventStore, nil).ForgetUser(context.Background(), "user-1", "request")
+	if err != nil {
+		t.Fatalf("failed to forget user: %v", err)
+	}
+	if !report.Succeeded() || !report.KeyShredded {
+		t.Fatalf("expected the data key to be shredded, got %+v", report)
+	}
+
+	stored, err := eventStore.GetEventsByAggregateID(context.Background(), "diary-user-1")
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if stored[0].Metadata[store.MetadataEncryptionShredded] != true {
+		t.Fatalf("expected the diary entry to be shredded, got metadata %v", stored[0].Metadata)
+	}
+}
+
+// plainEventStore hides the erasure methods of the store it wraps
+type plainEventStore struct {
+	store.EventStore
+}
+
+func TestForgetUserNotSupported(t *testing.T) {
+	inner := store.NewMemoryEventStore()
+	seedUser(t, inner, "user-1")
+	stored := inner.Len()
+
+	for name, eventStore := range map[string]store.EventStore{
+		"plain store":     plainEventStore{inner},
+		"decorated store": store.NewValidatingEventStore(plainEventStore{inner}),
+	} {
+		_, err := NewUserForgetter(eventStore, nil).ForgetUser(context.Background(), "user-1", "request")
+		if !errors.Is(err, ErrErasureNotSupported) {
+			t.Fatalf("%s: expected ErrErasureNotSupported, got %v", name, err)
+		}
+		if inner.Len() != stored {
+			t.Fatalf("%s: expected nothing to be recorded, got %d new events", name, inner.Len()-stored)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/kegazani/metachat-event-sourcing/aggregates"
+	"github.com/kegazani/metachat-event-sourcing/events"
+	"github.com/kegazani/metachat-event-sourcing/store"
+)
+
+// DefaultSnapshotFrequency is the number of events between snapshots when none is configured
+const DefaultSnapshotFrequency = 100
+
+// AggregateFactory creates an empty aggregate instance for the given ID
+type AggregateFactory[T aggregates.Aggregate] func(id string) T
+
+// Repository loads and saves event-sourced aggregates on top of an EventStore
+type Repository[T aggregates.Aggregate] struct {
+	store             store.EventStore
+	factory           AggregateFactory[T]
+	snapshots         store.SnapshotStore
+	snapshotFrequency int
+}
+
+// NewRepository creates a new repository for aggregates produced by factory
+func NewRepository[T aggregates.Aggregate](eventStore store.EventStore, factory AggregateFactory[T]) *Repository[T] {
+	return &Repository[T]{
+		store:   eventStore,
+		factory: factory,
+	}
+}
+
+// NewRepositoryWithSnapshots creates a repository that snapshots aggregates
+// implementing aggregates.Snapshotter every frequency events and loads them
+// from the latest snapshot plus the events recorded after it
+func NewRepositoryWithSnapshots[T aggregates.Aggregate](eventStore store.EventStore, snapshotStore store.SnapshotStore, frequency int, factory AggregateFactory[T]) *Repository[T] {
+	if frequency <= 0 {
+		frequency = DefaultSnapshotFrequency
+	}
+
+	return &Repository[T]{
+		store:             eventStore,
+		factory:           factory,
+		snapshots:         snapshotStore,
+		snapshotFrequency: frequency,
+	}
+}
+
+// NewUserRepository creates a repository for user aggregates
+func NewUserRepository(eventStore store.EventStore) *Repository[*aggregates.UserAggregate] {
+	return NewRepository(eventStore, aggregates.NewUserAggregate)
+}
+
+// NewDiaryRepository creates a repository for diary aggregates
+func NewDiaryRepository(eventStore store.EventStore) *Repository[*aggregates.DiaryAggregate] {
+	return NewRepository(eventStore, aggregates.NewDiaryAggregate)
+}
+
+// Load rehydrates an aggregate by replaying its events.
+// It returns store.ErrEventNotFound if the aggregate has no events.
+func (r *Repository[T]) Load(ctx context.Context, id string) (T, error) {
+	aggregate := r.factory(id)
+
+	restored, err := r.restoreSnapshot(ctx, aggregate)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	var eventList []*events.Event
+	if restored {
+		eventList, err = r.store.GetEventsByAggregateIDAfterVersion(ctx, id, aggregate.GetVersion())
+	} else {
+		eventList, err = r.store.GetEventsByAggregateID(ctx, id)
+	}
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	if !restored && len(eventList) == 0 {
+		var zero T
+		return zero, store.ErrEventNotFound
+	}
+
+	if err := r.apply(aggregate, eventList); err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return aggregate, nil
+}
+
+// Save persists the uncommitted events of an aggregate.
+// The events must directly follow the version the aggregate was loaded at,
+// otherwise store.ErrVersionConflict is returned and nothing is written.
+// On success the events are applied to the aggregate and cleared.
+func (r *Repository[T]) Save(ctx context.Context, aggregate T) error {
+	uncommitted := aggregate.GetUncommittedEvents()
+	if len(uncommitted) == 0 {
+		return nil
+	}
+
+	expectedVersion := aggregate.GetVersion()
+	if err := r.store.AppendToStream(ctx, aggregate.GetID(), expectedVersion, uncommitted); err != nil {
+		return err
+	}
+
+	if err := r.apply(aggregate, uncommitted); err != nil {
+		return err
+	}
+
+	aggregate.ClearUncommittedEvents()
+
+	if r.shouldSnapshot(expectedVersion, aggregate.GetVersion()) {
+		// Snapshots are only an optimization: the events are already committed,
+		// so a failed snapshot must not fail the save
+		_ = r.saveSnapshot(ctx, aggregate)
+	}
+
+	return nil
+}
+
+// restoreSnapshot restores the aggregate from its latest snapshot if snapshots are enabled
+func (r *Repository[T]) restoreSnapshot(ctx context.Context, aggregate T) (bool, error) {
+	if r.snapshots == nil {
+		return false, nil
+	}
+
+	snapshotter, ok := any(aggregate).(aggregates.Snapshotter)
+	if !ok {
+		return false, nil
+	}
+
+	snapshot, err := r.snapshots.GetLatestSnapshot(ctx, aggregate.GetID())
+	if errors.Is(err, store.ErrSnapshotNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if err := snapshotter.RestoreSnapshot(snapshot.Version, snapshot.State); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// shouldSnapshot reports whether a save moving from one version to another crossed a snapshot boundary
+func (r *Repository[T]) shouldSnapshot(fromVersion, toVersion int) bool {
+	if r.snapshots == nil {
+		return false
+	}
+
+	return fromVersion/r.snapshotFrequency != toVersion/r.snapshotFrequency
+}
+
+// saveSnapshot stores a snapshot of the aggregate at its current version
+func (r *Repository[T]) saveSnapshot(ctx context.Context, aggregate T) error {
+	snapshotter, ok := any(aggregate).(aggregates.Snapshotter)
+	if !ok {
+		return nil
+	}
+
+	state, err := snapshotter.CreateSnapshot()
+	if err != nil {
+		return err
+	}
+
+	return r.snapshots.SaveSnapshot(ctx, &store.Snapshot{
+		AggregateID: aggregate.GetID(),
+		Version:     aggregate.GetVersion(),
+		State:       state,
+		Timestamp:   time.Now(),
+	})
+}
+
+// apply replays events onto the aggregate
+func (r *Repository[T]) apply(aggregate T, eventList []*events.Event) error {
+	for _, event := range eventList {
+		if err := aggregate.ApplyEvent(event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
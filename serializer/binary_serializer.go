@@ -0,0 +1,198 @@
+package serializer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kegazani/metachat-event-sourcing/events"
+)
+
+// ContentTypeBinary is the content type of BinarySerializer
+const ContentTypeBinary = "application/vnd.metachat.event+binary"
+
+// binaryFormatVersion is the first byte of every binary encoded event
+const binaryFormatVersion byte = 1
+
+// errTruncated is returned when binary data ends in the middle of a field
+var errTruncated = errors.New("truncated binary event")
+
+// BinarySerializer encodes events as a compact sequence of varints and
+// length-prefixed fields. Payloads and metadata stay JSON, with insignificant
+// whitespace removed, so upcasters and payload decoding work unchanged.
+type BinarySerializer struct{}
+
+// NewBinarySerializer creates a new binary serializer
+func NewBinarySerializer() *BinarySerializer {
+	return &BinarySerializer{}
+}
+
+// ContentType returns the binary event content type
+func (b *BinarySerializer) ContentType() string {
+	return ContentTypeBinary
+}
+
+// Serialize serializes an event to binary bytes
+func (b *BinarySerializer) Serialize(event *events.Event) ([]byte, error) {
+	payload, err := compactJSON(event.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compact payload: %w", err)
+	}
+
+	var metadata []byte
+	if event.Metadata != nil {
+		if metadata, err = json.Marshal(event.Metadata); err != nil {
+			return nil, fmt.Errorf("failed to marshal metadata: %w", err)
+		}
+	}
+
+	// One version byte, six length prefixes and five numeric fields at most
+	size := 1 + 11*binary.MaxVarintLen64 +
+		len(event.ID) + len(event.Type) + len(event.AggregateID) + len(event.AggregateType) +
+		len(payload) + len(metadata)
+	buf := make([]byte, 0, size)
+
+	buf = append(buf, binaryFormatVersion)
+	buf = appendString(buf, event.ID)
+	buf = appendString(buf, string(event.Type))
+	buf = appendString(buf, event.AggregateID)
+	buf = appendString(buf, string(event.AggregateType))
+	buf = binary.AppendVarint(buf, int64(event.Version))
+	buf = binary.AppendVarint(buf, event.Timestamp.Unix())
+	buf = binary.AppendUvarint(buf, uint64(event.Timestamp.Nanosecond()))
+	buf = appendBytes(buf, payload)
+	buf = appendBytes(buf, metadata)
+	buf = binary.AppendVarint(buf, int64(event.SchemaVersion))
+	buf = binary.AppendUvarint(buf, event.Position)
+
+	return buf, nil
+}
+
+// Deserialize deserializes binary bytes to an event. Timestamps are returned in UTC.
+func (b *BinarySerializer) Deserialize(data []byte) (*events.Event, error) {
+	if len(data) == 0 {
+		return nil, errTruncated
+	}
+	if data[0] != binaryFormatVersion {
+		return nil, fmt.Errorf("unsupported binary event format %d", data[0])
+	}
+
+	r := binaryReader{data: data[1:]}
+	event := &events.Event{
+		ID:            r.string(),
+		Type:          events.EventType(r.string()),
+		AggregateID:   r.string(),
+		AggregateType: events.AggregateType(r.string()),
+		Version:       int(r.varint()),
+	}
+
+	seconds := r.varint()
+	nanos := r.uvarint()
+	event.Timestamp = time.Unix(seconds, int64(nanos)).UTC()
+
+	if payload := r.bytes(); len(payload) > 0 {
+		event.Payload = append(json.RawMessage(nil), payload...)
+	}
+	if metadata := r.bytes(); len(metadata) > 0 && r.err == nil {
+		if err := json.Unmarshal(metadata, &event.Metadata); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal metadata: %w", err)
+		}
+	}
+
+	event.SchemaVersion = int(r.varint())
+	event.Position = r.uvarint()
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(r.data) > 0 {
+		return nil, fmt.Errorf("%d trailing bytes after binary event", len(r.data))
+	}
+	return event, nil
+}
+
+// compactJSON removes insignificant whitespace from a JSON value
+func compactJSON(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(len(data))
+	if err := json.Compact(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// appendString appends a length-prefixed string
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// appendBytes appends a length-prefixed byte slice
+func appendBytes(buf []byte, b []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+// binaryReader decodes the fields of a binary event, keeping the first error
+type binaryReader struct {
+	data []byte
+	err  error
+}
+
+// uvarint reads an unsigned varint
+func (r *binaryReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = errTruncated
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+// varint reads a signed varint
+func (r *binaryReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+
+	v, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.err = errTruncated
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+// bytes reads a length-prefixed byte slice, sharing the underlying data
+func (r *binaryReader) bytes() []byte {
+	length := r.uvarint()
+	if r.err != nil {
+		return nil
+	}
+	if length > uint64(len(r.data)) {
+		r.err = errTruncated
+		return nil
+	}
+
+	b := r.data[:length:length]
+	r.data = r.data[length:]
+	return b
+}
+
+// string reads a length-prefixed string
+func (r *binaryReader) string() string {
+	return string(r.bytes())
+}
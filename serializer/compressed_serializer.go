@@ -0,0 +1,162 @@
+package serializer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/kegazani/metachat-event-sourcing/events"
+)
+
+// Compression is a compression algorithm, used as a content type suffix
+type Compression string
+
+// Supported compression algorithms
+const (
+	CompressionGzip   Compression = "gzip"
+	CompressionZstd   Compression = "zstd"
+	CompressionSnappy Compression = "snappy"
+)
+
+// maxDecompressedSize bounds the size of a decompressed event
+const maxDecompressedSize = 64 << 20
+
+// zstd encoders and decoders are safe for concurrent EncodeAll and DecodeAll calls
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecompressedSize))
+)
+
+// gzipWriters reuses gzip writers, which allocate their compression tables on creation
+var gzipWriters = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(nil)
+	},
+}
+
+// CompressedSerializer compresses the output of another serializer. Its
+// content type is the one of the wrapped serializer with a +algorithm suffix,
+// such as application/json+gzip.
+type CompressedSerializer struct {
+	serializer  Serializer
+	compression Compression
+}
+
+// NewCompressedSerializer wraps a serializer with a compression algorithm
+func NewCompressedSerializer(serializer Serializer, compression Compression) (*CompressedSerializer, error) {
+	switch compression {
+	case CompressionGzip, CompressionZstd, CompressionSnappy:
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", compression)
+	}
+
+	return &CompressedSerializer{
+		serializer:  serializer,
+		compression: compression,
+	}, nil
+}
+
+// ContentType returns the content type of the wrapped serializer with the compression suffix
+func (c *CompressedSerializer) ContentType() string {
+	return c.serializer.ContentType() + "+" + string(c.compression)
+}
+
+// Serialize serializes an event with the wrapped serializer and compresses the result
+func (c *CompressedSerializer) Serialize(event *events.Event) ([]byte, error) {
+	data, err := c.serializer.Serialize(event)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c.compression {
+	case CompressionGzip:
+		var buf bytes.Buffer
+		w := gzipWriters.Get().(*gzip.Writer)
+		defer gzipWriters.Put(w)
+
+		w.Reset(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionZstd:
+		return zstdEncoder.EncodeAll(data, nil), nil
+	default:
+		return snappy.Encode(nil, data), nil
+	}
+}
+
+// Deserialize decompresses bytes and deserializes them with the wrapped serializer
+func (c *CompressedSerializer) Deserialize(data []byte) (*events.Event, error) {
+	var (
+		decompressed []byte
+		err          error
+	)
+
+	switch c.compression {
+	case CompressionGzip:
+		decompressed, err = gunzip(data)
+	case CompressionZstd:
+		decompressed, err = zstdDecoder.DecodeAll(data, nil)
+	default:
+		decompressed, err = unsnappy(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s event: %w", c.compression, err)
+	}
+
+	return c.serializer.Deserialize(decompressed)
+}
+
+// gunzip decompresses gzip data up to maxDecompressedSize
+func gunzip(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	decompressed, err := io.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(decompressed) > maxDecompressedSize {
+		return nil, fmt.Errorf("decompressed event exceeds %d bytes", maxDecompressedSize)
+	}
+	return decompressed, nil
+}
+
+// unsnappy decompresses snappy data up to maxDecompressedSize
+func unsnappy(data []byte) ([]byte, error) {
+	size, err := snappy.DecodedLen(data)
+	if err != nil {
+		return nil, err
+	}
+	if size > maxDecompressedSize {
+		return nil, fmt.Errorf("decompressed event exceeds %d bytes", maxDecompressedSize)
+	}
+	return snappy.Decode(nil, data)
+}
+
+// splitCompression splits a content type into its base content type and compression suffix
+func splitCompression(contentType string) (string, Compression) {
+	i := strings.LastIndex(contentType, "+")
+	if i < 0 {
+		return contentType, ""
+	}
+
+	switch compression := Compression(contentType[i+1:]); compression {
+	case CompressionGzip, CompressionZstd, CompressionSnappy:
+		return contentType[:i], compression
+	}
+	return contentType, ""
+}
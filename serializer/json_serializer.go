@@ -13,8 +13,14 @@ type Serializer interface {
 
 	// Deserialize deserializes bytes to an event
 	Deserialize(data []byte) (*events.Event, error)
+
+	// ContentType returns the content type of the serialized bytes
+	ContentType() string
 }
 
+// ContentTypeJSON is the content type of JSONSerializer
+const ContentTypeJSON = "application/json"
+
 // JSONSerializer is a JSON implementation of Serializer
 type JSONSerializer struct{}
 
@@ -36,3 +42,8 @@ func (j *JSONSerializer) Deserialize(data []byte) (*events.Event, error) {
 	}
 	return &event, nil
 }
+
+// ContentType returns application/json
+func (j *JSONSerializer) ContentType() string {
+	return ContentTypeJSON
+}
@@ -0,0 +1,86 @@
+package serializer
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/kegazani/metachat-event-sourcing/events"
+)
+
+// ErrUnknownContentType is returned when no serializer handles a content type
+var ErrUnknownContentType = errors.New("unknown content type")
+
+// Registry resolves the serializer decoding data of a content type. Compressed
+// content types resolve to the serializer of their base content type wrapped
+// with the compression, so only uncompressed serializers need registering.
+type Registry struct {
+	mu          sync.RWMutex
+	serializers map[string]Serializer
+}
+
+// NewRegistry creates a registry holding the given serializers
+func NewRegistry(serializers ...Serializer) *Registry {
+	r := &Registry{
+		serializers: make(map[string]Serializer),
+	}
+	for _, serializer := range serializers {
+		r.Register(serializer)
+	}
+	return r
+}
+
+// DefaultRegistry resolves the serializers of this package
+var DefaultRegistry = NewRegistry(NewJSONSerializer(), NewBinarySerializer())
+
+// Register adds a serializer under its content type
+func (r *Registry) Register(serializer Serializer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.serializers[serializer.ContentType()] = serializer
+}
+
+// Lookup returns the serializer of a content type. An empty content type,
+// as found on data written before content types were recorded, is JSON.
+func (r *Registry) Lookup(contentType string) (Serializer, error) {
+	if contentType == "" {
+		contentType = ContentTypeJSON
+	}
+
+	r.mu.RLock()
+	serializer, ok := r.serializers[contentType]
+	r.mu.RUnlock()
+	if ok {
+		return serializer, nil
+	}
+
+	base, compression := splitCompression(contentType)
+	if compression == "" {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownContentType, contentType)
+	}
+
+	r.mu.RLock()
+	serializer, ok = r.serializers[base]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownContentType, contentType)
+	}
+
+	compressed, err := NewCompressedSerializer(serializer, compression)
+	if err != nil {
+		return nil, err
+	}
+
+	r.Register(compressed)
+	return compressed, nil
+}
+
+// Deserialize decodes data with the serializer of its content type
+func (r *Registry) Deserialize(contentType string, data []byte) (*events.Event, error) {
+	serializer, err := r.Lookup(contentType)
+	if err != nil {
+		return nil, err
+	}
+	return serializer.Deserialize(data)
+}
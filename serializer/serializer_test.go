@@ -0,0 +1,208 @@
+package serializer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kegazani/metachat-event-sourcing/events"
+)
+
+// diaryContent is a diary entry of a realistic length, about 2 KB
+var diaryContent = strings.Repeat("Today started slowly. I walked to the park before work, "+
+	"listened to the rain and wrote down three things I was grateful for. "+
+	"The meeting in the afternoon went better than I expected. ", 10)
+
+// newDiaryEvent creates a stored diary entry event with metadata, as read back from an event store
+func newDiaryEvent(tb testing.TB) *events.Event {
+	tb.Helper()
+
+	event, err := events.NewEvent(events.DiaryEntryCreatedEvent, "2f1d4c6e-8a3b-4f7d-9c2e-5b6a7d8e9f01", 1, events.DiaryEntryCreatedPayload{
+		UserID:     "7c9e6679-7425-40de-944b-e07fc1f90ae7",
+		Title:      "A rainy Tuesday",
+		Content:    diaryContent,
+		TokenCount: 412,
+		SessionID:  "b3f1a2c4-5d6e-4f70-8a9b-0c1d2e3f4a5b",
+		Tags:       []string{"morning", "gratitude", "work"},
+	}, map[string]interface{}{
+		"correlation_id": "c0ffee00-1234-4abc-9def-0123456789ab",
+		"user_id":        "7c9e6679-7425-40de-944b-e07fc1f90ae7",
+	})
+	if err != nil {
+		tb.Fatalf("failed to create event: %v", err)
+	}
+
+	event.AggregateType = events.DiaryAggregateType
+	event.SchemaVersion = 2
+	event.Position = 1048576
+	return event
+}
+
+// testSerializers returns the serializers of the package, uncompressed and with every compression
+func testSerializers(tb testing.TB) []Serializer {
+	tb.Helper()
+
+	serializers := []Serializer{NewJSONSerializer(), NewBinarySerializer()}
+	for _, base := range []Serializer{NewJSONSerializer(), NewBinarySerializer()} {
+		for _, compression := range []Compression{CompressionGzip, CompressionZstd, CompressionSnappy} {
+			compressed, err := NewCompressedSerializer(base, compression)
+			if err != nil {
+				tb.Fatalf("failed to create %s serializer: %v", compression, err)
+			}
+			serializers = append(serializers, compressed)
+		}
+	}
+	return serializers
+}
+
+// assertEventEqual checks that a decoded event matches the serialized one
+func assertEventEqual(t *testing.T, want, got *events.Event) {
+	t.Helper()
+
+	if got.ID != want.ID || got.Type != want.Type || got.AggregateID != want.AggregateID ||
+		got.AggregateType != want.AggregateType || got.Version != want.Version ||
+		got.SchemaVersion != want.SchemaVersion || got.Position != want.Position {
+		t.Fatalf("decoded event %+v does not match %+v", got, want)
+	}
+	if !got.Timestamp.Equal(want.Timestamp) {
+		t.Fatalf("expected timestamp %v, got %v", want.Timestamp, got.Timestamp)
+	}
+
+	var wantPayload, gotPayload bytes.Buffer
+	if err := json.Compact(&wantPayload, want.Payload); err != nil {
+		t.Fatalf("invalid payload: %v", err)
+	}
+	if err := json.Compact(&gotPayload, got.Payload); err != nil {
+		t.Fatalf("invalid decoded payload: %v", err)
+	}
+	if !bytes.Equal(wantPayload.Bytes(), gotPayload.Bytes()) {
+		t.Fatalf("expected payload %s, got %s", wantPayload.Bytes(), gotPayload.Bytes())
+	}
+
+	if len(got.Metadata) != len(want.Metadata) {
+		t.Fatalf("expected metadata %v, got %v", want.Metadata, got.Metadata)
+	}
+	for key, value := range want.Metadata {
+		if got.Metadata[key] != value {
+			t.Fatalf("expected metadata %s=%v, got %v", key, value, got.Metadata[key])
+		}
+	}
+}
+
+func TestRegistryRoundTrip(t *testing.T) {
+	event := newDiaryEvent(t)
+
+	for _, s := range testSerializers(t) {
+		t.Run(s.ContentType(), func(t *testing.T) {
+			data, err := s.Serialize(event)
+			if err != nil {
+				t.Fatalf("failed to serialize: %v", err)
+			}
+
+			decoded, err := NewRegistry(NewJSONSerializer(), NewBinarySerializer()).Deserialize(s.ContentType(), data)
+			if err != nil {
+				t.Fatalf("failed to deserialize through the registry: %v", err)
+			}
+			assertEventEqual(t, event, decoded)
+		})
+	}
+}
+
+func TestRegistryDecodesLegacyDataAsJSON(t *testing.T) {
+	event := newDiaryEvent(t)
+
+	data, err := NewJSONSerializer().Serialize(event)
+	if err != nil {
+		t.Fatalf("failed to serialize: %v", err)
+	}
+
+	decoded, err := DefaultRegistry.Deserialize("", data)
+	if err != nil {
+		t.Fatalf("failed to deserialize data without a content type: %v", err)
+	}
+	assertEventEqual(t, event, decoded)
+}
+
+func TestRegistryRejectsUnknownContentTypes(t *testing.T) {
+	for _, contentType := range []string{"application/xml", "application/xml+gzip", "application/json+lz4"} {
+		_, err := DefaultRegistry.Lookup(contentType)
+		if !errors.Is(err, ErrUnknownContentType) {
+			t.Fatalf("expected ErrUnknownContentType for %s, got %v", contentType, err)
+		}
+	}
+}
+
+func TestBinarySerializerReturnsUTC(t *testing.T) {
+	event := newDiaryEvent(t)
+	event.Timestamp = time.Date(2026, 3, 14, 9, 26, 53, 589793238, time.FixedZone("CET", 3600))
+
+	data, err := NewBinarySerializer().Serialize(event)
+	if err != nil {
+		t.Fatalf("failed to serialize: %v", err)
+	}
+	decoded, err := NewBinarySerializer().Deserialize(data)
+	if err != nil {
+		t.Fatalf("failed to deserialize: %v", err)
+	}
+
+	if decoded.Timestamp.Location() != time.UTC || !decoded.Timestamp.Equal(event.Timestamp) {
+		t.Fatalf("expected %v in UTC, got %v", event.Timestamp, decoded.Timestamp)
+	}
+}
+
+// benchmarkSerializer measures serializing and deserializing a diary event, reporting the encoded size
+func benchmarkSerializer(b *testing.B, s Serializer) {
+	event := newDiaryEvent(b)
+
+	data, err := s.Serialize(event)
+	if err != nil {
+		b.Fatalf("failed to serialize: %v", err)
+	}
+
+	b.Run("Serialize", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			if _, err := s.Serialize(event); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(len(data)), "bytes/event")
+	})
+
+	b.Run("Deserialize", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			if _, err := s.Deserialize(data); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(len(data)), "bytes/event")
+	})
+}
+
+func BenchmarkJSONSerializer(b *testing.B) {
+	benchmarkSerializer(b, NewJSONSerializer())
+}
+
+func BenchmarkBinarySerializer(b *testing.B) {
+	benchmarkSerializer(b, NewBinarySerializer())
+}
+
+func BenchmarkCompressedSerializer(b *testing.B) {
+	for _, base := range []Serializer{NewJSONSerializer(), NewBinarySerializer()} {
+		for _, compression := range []Compression{CompressionGzip, CompressionZstd, CompressionSnappy} {
+			s, err := NewCompressedSerializer(base, compression)
+			if err != nil {
+				b.Fatalf("failed to create %s serializer: %v", compression, err)
+			}
+			b.Run(s.ContentType(), func(b *testing.B) {
+				benchmarkSerializer(b, s)
+			})
+		}
+	}
+}
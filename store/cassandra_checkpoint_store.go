@@ -0,0 +1,71 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+type CassandraCheckpointStore struct {
+	session *gocql.Session
+}
+
+func NewCassandraCheckpointStore(session *gocql.Session) *CassandraCheckpointStore {
+	return &CassandraCheckpointStore{
+		session: session,
+	}
+}
+
+// InitializeSchema creates the keyspace and the checkpoints table. The session
+// must be created with the keyspace set to read and write checkpoints.
+func (c *CassandraCheckpointStore) InitializeSchema(keyspace string) error {
+	queries := []string{
+		`CREATE KEYSPACE IF NOT EXISTS %s WITH replication = {'class': 'SimpleStrategy', 'replication_factor': 1}`,
+		`CREATE TABLE IF NOT EXISTS %s.checkpoints (
+			name text PRIMARY KEY,
+			position bigint,
+			updated_at timestamp
+		)`,
+	}
+
+	for _, query := range queries {
+		if err := c.session.Query(fmt.Sprintf(query, keyspace)).Exec(); err != nil {
+			return fmt.Errorf("failed to execute schema query: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (c *CassandraCheckpointStore) SaveCheckpoint(ctx context.Context, name string, position uint64) error {
+	err := c.session.Query(
+		`INSERT INTO checkpoints (name, position, updated_at) VALUES (?, ?, ?)`,
+		name,
+		int64(position),
+		time.Now().UTC(),
+	).WithContext(ctx).Exec()
+	if err != nil {
+		return NewEventStoreError(ErrCodeStorage, "failed to save checkpoint", err)
+	}
+
+	return nil
+}
+
+func (c *CassandraCheckpointStore) GetCheckpoint(ctx context.Context, name string) (uint64, error) {
+	var position int64
+
+	err := c.session.Query(
+		`SELECT position FROM checkpoints WHERE name = ?`,
+		name,
+	).WithContext(ctx).Scan(&position)
+	if err == gocql.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, NewEventStoreError(ErrCodeStorage, "failed to retrieve checkpoint", err)
+	}
+
+	return uint64(position), nil
+}
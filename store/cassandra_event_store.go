@@ -4,28 +4,80 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gocql/gocql"
-	"github.com/google/uuid"
+	"github.com/kegazani/metachat-event-sourcing/bus"
 	"github.com/kegazani/metachat-event-sourcing/events"
+	"github.com/kegazani/metachat-event-sourcing/serializer"
 )
 
+// defaultAggregateType is the partition key prefix of streams written before
+// aggregate types were recorded
+const defaultAggregateType = "default"
+
+// eventColumns are the columns selected by every event query, in scan order
+const eventColumns = `aggregate_type, aggregate_id, event_id, event_type, payload, metadata, created_at, version, position, schema_version, content_type, data`
+
+// globalSequenceName is the row of global_sequence holding the last reserved global position
+const globalSequenceName = "events"
+
+// positionBucketSize is the number of global positions stored per events_by_position partition
+const positionBucketSize = 10000
+
+// maxReserveAttempts bounds the retries when concurrent writers race for global positions
+const maxReserveAttempts = 16
+
+// maxAppendAttempts bounds the retries of an append at ExpectedVersionAny racing concurrent writers of its stream
+const maxAppendAttempts = 16
+
+// positionReindexAfter is how long an append may take between reserving its
+// global positions and committing before its events are moved to new ones,
+// well within the time subscriptions wait at a gap
+const positionReindexAfter = subscriptionGapTimeout / 2
+
+// maxRepairedPositions bounds the missing global positions looked up in the events table per read
+const maxRepairedPositions = 100
+
 type CassandraEventStore struct {
-	session *gocql.Session
+	session        *gocql.Session
+	serializer     serializer.Serializer
+	serializers    *serializer.Registry
+	aggregateTypes sync.Map // aggregate ID -> aggregate type
 }
 
+// NewCassandraEventStore creates a Cassandra event store writing events as JSON
 func NewCassandraEventStore(session *gocql.Session) *CassandraEventStore {
+	return NewCassandraEventStoreWithSerializer(session, nil)
+}
+
+// NewCassandraEventStoreWithSerializer creates a Cassandra event store writing
+// events with eventSerializer, or JSON if it is nil. Events are read back with
+// the serializer of the content type stored with each of them.
+func NewCassandraEventStoreWithSerializer(session *gocql.Session, eventSerializer serializer.Serializer) *CassandraEventStore {
+	if eventSerializer == nil {
+		eventSerializer = serializer.NewJSONSerializer()
+	}
+
 	return &CassandraEventStore{
-		session: session,
+		session:     session,
+		serializer:  eventSerializer,
+		serializers: serializer.NewRegistry(serializer.NewJSONSerializer(), serializer.NewBinarySerializer(), eventSerializer),
 	}
 }
 
+// InitializeSchema creates the keyspace and the tables of the store. gocql does
+// not support USE statements, so the session must be created with the keyspace
+// set in its ClusterConfig to read and write events. Keyspaces holding streams
+// written before aggregate types were recorded need BackfillAggregateTypes
+// afterwards for GetEventsByAggregateType to return those streams.
 func (c *CassandraEventStore) InitializeSchema(keyspace string) error {
 	queries := []string{
-		fmt.Sprintf(`CREATE KEYSPACE IF NOT EXISTS %s WITH replication = {'class': 'SimpleStrategy', 'replication_factor': 1}`, keyspace),
-		fmt.Sprintf(`USE %s`, keyspace),
-		`CREATE TABLE IF NOT EXISTS events (
+		`CREATE KEYSPACE IF NOT EXISTS %s WITH replication = {'class': 'SimpleStrategy', 'replication_factor': 1}`,
+		`CREATE TABLE IF NOT EXISTS %s.events (
 			aggregate_type text,
 			aggregate_id uuid,
 			version int,
@@ -34,14 +86,79 @@ func (c *CassandraEventStore) InitializeSchema(keyspace string) error {
 			payload text,
 			metadata text,
 			created_at timestamp,
+			position bigint,
+			schema_version int,
+			content_type text,
+			data blob,
 			PRIMARY KEY ((aggregate_type, aggregate_id), version)
 		) WITH CLUSTERING ORDER BY (version ASC)`,
-		`CREATE INDEX IF NOT EXISTS ON events (event_type)`,
-		`CREATE INDEX IF NOT EXISTS ON events (created_at)`,
+		`CREATE TABLE IF NOT EXISTS %s.events_by_position (
+			bucket bigint,
+			position bigint,
+			aggregate_type text,
+			aggregate_id uuid,
+			version int,
+			event_id uuid,
+			event_type text,
+			payload text,
+			metadata text,
+			created_at timestamp,
+			schema_version int,
+			content_type text,
+			data blob,
+			PRIMARY KEY (bucket, position)
+		) WITH CLUSTERING ORDER BY (position ASC)`,
+		`CREATE TABLE IF NOT EXISTS %s.released_positions (
+			bucket bigint,
+			position bigint,
+			PRIMARY KEY (bucket, position)
+		)`,
+		`CREATE TABLE IF NOT EXISTS %s.global_sequence (
+			name text PRIMARY KEY,
+			value bigint
+		)`,
+		`CREATE TABLE IF NOT EXISTS %s.aggregates (
+			aggregate_id uuid PRIMARY KEY,
+			aggregate_type text
+		)`,
+		`CREATE TABLE IF NOT EXISTS %s.aggregates_by_type (
+			aggregate_type text,
+			aggregate_id uuid,
+			PRIMARY KEY (aggregate_type, aggregate_id)
+		)`,
+	}
+
+	// Tables created by earlier versions of the store lack the later columns
+	migrations := []string{
+		`ALTER TABLE %s.events ADD position bigint`,
+		`ALTER TABLE %s.events ADD schema_version int`,
+		`ALTER TABLE %s.events ADD content_type text`,
+		`ALTER TABLE %s.events ADD data blob`,
+		`ALTER TABLE %s.events_by_position ADD schema_version int`,
+		`ALTER TABLE %s.events_by_position ADD content_type text`,
+		`ALTER TABLE %s.events_by_position ADD data blob`,
+	}
+
+	indexes := []string{
+		`CREATE INDEX IF NOT EXISTS ON %s.events (event_type)`,
+		`CREATE INDEX IF NOT EXISTS ON %s.events (created_at)`,
+		`CREATE INDEX IF NOT EXISTS ON %s.events (position)`,
 	}
 
 	for _, query := range queries {
-		if err := c.session.Query(query).Exec(); err != nil {
+		if err := c.session.Query(fmt.Sprintf(query, keyspace)).Exec(); err != nil {
+			return fmt.Errorf("failed to execute schema query: %w", err)
+		}
+	}
+
+	for _, query := range migrations {
+		if err := c.session.Query(fmt.Sprintf(query, keyspace)).Exec(); err != nil && !isColumnExists(err) {
+			return fmt.Errorf("failed to execute schema migration: %w", err)
+		}
+	}
+
+	for _, query := range indexes {
+		if err := c.session.Query(fmt.Sprintf(query, keyspace)).Exec(); err != nil {
 			return fmt.Errorf("failed to execute schema query: %w", err)
 		}
 	}
@@ -49,188 +166,482 @@ func (c *CassandraEventStore) InitializeSchema(keyspace string) error {
 	return nil
 }
 
+// isColumnExists reports whether an ALTER TABLE ADD failed because the column
+// exists, which Cassandra reports with a version dependent message
+func isColumnExists(err error) bool {
+	message := strings.ToLower(err.Error())
+	return strings.Contains(message, "conflicts with an existing column") || strings.Contains(message, "already exists")
+}
+
 func (c *CassandraEventStore) SaveEvents(ctx context.Context, eventList []*events.Event) error {
-	batch := c.session.NewBatch(gocql.LoggedBatch)
+	return saveEventsByAggregate(ctx, c, eventList)
+}
 
-	for _, event := range eventList {
-		aggregateType := c.getAggregateType(event.AggregateID)
+func (c *CassandraEventStore) AppendToStream(ctx context.Context, aggregateID string, expectedVersion int, eventList []*events.Event) error {
+	if len(eventList) == 0 {
+		return nil
+	}
+
+	aggregateUUID, err := gocql.ParseUUID(aggregateID)
+	if err != nil {
+		return NewEventStoreError(ErrCodeSerialization, "invalid aggregate ID", err)
+	}
 
-		payloadJSON, err := json.Marshal(event.Payload)
+	// An append at any version lost to a concurrent writer is retried on top of
+	// the new head of the stream, only explicit expected versions conflict
+	for attempt := 0; attempt < maxAppendAttempts; attempt++ {
+		applied, err := c.appendOnce(ctx, aggregateID, aggregateUUID, expectedVersion, eventList)
 		if err != nil {
-			return NewEventStoreError(ErrCodeSerialization, "failed to marshal payload", err)
+			return err
+		}
+		if applied {
+			return nil
 		}
+		if expectedVersion != ExpectedVersionAny {
+			return ErrVersionConflict
+		}
+	}
 
-		metadataJSON, err := json.Marshal(event.Metadata)
-		if err != nil {
-			return NewEventStoreError(ErrCodeSerialization, "failed to marshal metadata", err)
+	return NewEventStoreError(ErrCodeStorage, "failed to save events", fmt.Errorf("gave up after %d attempts", maxAppendAttempts))
+}
+
+// appendOnce appends events at the current head of a stream in one conditional
+// batch. It returns false without error if a concurrent writer stored one of
+// the versions first.
+func (c *CassandraEventStore) appendOnce(ctx context.Context, aggregateID string, aggregateUUID gocql.UUID, expectedVersion int, eventList []*events.Event) (bool, error) {
+	aggregateType, err := c.getAggregateType(ctx, aggregateUUID)
+	if err != nil {
+		return false, err
+	}
+
+	currentVersion, err := c.currentVersion(ctx, aggregateType, aggregateUUID)
+	if err != nil {
+		return false, err
+	}
+
+	if err := prepareAppend(aggregateID, currentVersion, expectedVersion, eventList); err != nil {
+		return false, err
+	}
+
+	// A new stream is partitioned by the type of the aggregate that emits it.
+	// The partition is recorded first so its events can always be found; the
+	// stream is only listed by type once its first events are committed.
+	newStream := currentVersion == 0 && eventList[0].AggregateType != ""
+	if newStream && aggregateType == defaultAggregateType {
+		aggregateType = string(eventList[0].AggregateType)
+		if err := c.registerAggregate(ctx, aggregateType, aggregateUUID); err != nil {
+			return false, err
 		}
+	}
+
+	firstPosition, err := c.reservePositions(ctx, len(eventList))
+	if err != nil {
+		return false, err
+	}
+	reservedAt := time.Now()
 
-		eventID, err := uuid.Parse(event.ID)
+	// All rows of a stream share one partition, so the whole batch is a single
+	// lightweight transaction: if any of the versions already exists nothing
+	// is written and the append is retried or reported as a version conflict.
+	batch := c.session.NewBatch(gocql.LoggedBatch).
+		WithContext(ctx).
+		SerialConsistency(gocql.LocalSerial)
+
+	rows := make([]positionRow, len(eventList))
+	for i, event := range eventList {
+		position := firstPosition + uint64(i)
+
+		stored := *event
+		stored.Position = position
+		data, err := c.serializer.Serialize(&stored)
 		if err != nil {
-			eventID = uuid.New()
+			return false, NewEventStoreError(ErrCodeSerialization, "failed to serialize event", err)
 		}
 
-		aggregateID, err := uuid.Parse(event.AggregateID)
+		eventID, err := gocql.ParseUUID(event.ID)
 		if err != nil {
-			return NewEventStoreError(ErrCodeSerialization, "invalid aggregate ID", err)
+			eventID = gocql.MustRandomUUID()
+		}
+
+		rows[i] = positionRow{
+			position:      position,
+			aggregateType: aggregateType,
+			aggregateID:   aggregateUUID,
+			version:       event.Version,
+			eventID:       eventID,
+			eventType:     string(event.Type),
+			createdAt:     event.Timestamp,
+			schemaVersion: event.SchemaVersion,
+			contentType:   c.serializer.ContentType(),
+			data:          data,
 		}
 
 		batch.Query(
-			`INSERT INTO events (aggregate_type, aggregate_id, version, event_id, event_type, payload, metadata, created_at)
-			 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			`INSERT INTO events (aggregate_type, aggregate_id, version, event_id, event_type, created_at, position, schema_version, content_type, data)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			 IF NOT EXISTS`,
 			aggregateType,
-			aggregateID,
+			aggregateUUID,
 			event.Version,
 			eventID,
 			string(event.Type),
-			string(payloadJSON),
-			string(metadataJSON),
 			event.Timestamp,
+			int64(position),
+			event.SchemaVersion,
+			c.serializer.ContentType(),
+			data,
+		)
+	}
+
+	applied, iter, err := c.session.MapExecuteBatchCAS(batch, make(map[string]interface{}))
+	if err != nil {
+		return false, NewEventStoreError(ErrCodeStorage, "failed to save events", err)
+	}
+	if err := iter.Close(); err != nil {
+		return false, NewEventStoreError(ErrCodeStorage, "failed to save events", err)
+	}
+
+	if !applied {
+		// Readers of the global log stop waiting for the reserved positions.
+		// If this fails they wait for the gap timeout instead.
+		_ = c.releasePositions(ctx, firstPosition, len(eventList))
+		return false, nil
+	}
+
+	// Subscriptions waiting at the reserved positions may have given up on
+	// them, so the events of a slow append move to new positions
+	if time.Since(reservedAt) > positionReindexAfter {
+		if err := c.movePositions(ctx, rows); err == nil {
+			_ = c.releasePositions(ctx, firstPosition, len(eventList))
+		}
+	}
+
+	// The events are committed. The global log rows are written separately
+	// since a conditional batch cannot span partitions; if this fails readers
+	// of the global log rebuild them from the events table.
+	_ = c.indexPositions(ctx, rows)
+
+	// A stream missing from aggregates_by_type is restored by BackfillAggregateTypes
+	if newStream {
+		_ = c.indexAggregateType(ctx, string(eventList[0].AggregateType), aggregateUUID)
+	}
+
+	for i, event := range eventList {
+		event.Position = rows[i].position
+	}
+
+	return true, nil
+}
+
+// positionRow holds the columns of an event in the global log
+type positionRow struct {
+	position      uint64
+	aggregateType string
+	aggregateID   gocql.UUID
+	version       int
+	eventID       gocql.UUID
+	eventType     string
+	createdAt     time.Time
+	schemaVersion int
+	contentType   string
+	data          []byte
+}
+
+// indexPositions writes events to the global log
+func (c *CassandraEventStore) indexPositions(ctx context.Context, rows []positionRow) error {
+	batch := c.session.NewBatch(gocql.LoggedBatch).WithContext(ctx)
+	for _, row := range rows {
+		batch.Query(
+			`INSERT INTO events_by_position (bucket, position, aggregate_type, aggregate_id, version, event_id, event_type, created_at, schema_version, content_type, data)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			int64(row.position/positionBucketSize),
+			int64(row.position),
+			row.aggregateType,
+			row.aggregateID,
+			row.version,
+			row.eventID,
+			row.eventType,
+			row.createdAt,
+			row.schemaVersion,
+			row.contentType,
+			row.data,
+		)
+	}
+
+	if err := c.session.ExecuteBatch(batch); err != nil {
+		return NewEventStoreError(ErrCodeStorage, "failed to index events by position", err)
+	}
+	return nil
+}
+
+// movePositions reserves new global positions for committed events and records them on the events
+func (c *CassandraEventStore) movePositions(ctx context.Context, rows []positionRow) error {
+	firstPosition, err := c.reservePositions(ctx, len(rows))
+	if err != nil {
+		return err
+	}
+
+	batch := c.session.NewBatch(gocql.LoggedBatch).WithContext(ctx)
+	for i, row := range rows {
+		batch.Query(
+			`UPDATE events SET position = ? WHERE aggregate_type = ? AND aggregate_id = ? AND version = ?`,
+			int64(firstPosition+uint64(i)),
+			row.aggregateType,
+			row.aggregateID,
+			row.version,
 		)
 	}
 
 	if err := c.session.ExecuteBatch(batch); err != nil {
-		return NewEventStoreError(ErrCodeStorage, "failed to save events", err)
+		return NewEventStoreError(ErrCodeStorage, "failed to move events to new positions", err)
 	}
 
+	for i := range rows {
+		rows[i].position = firstPosition + uint64(i)
+	}
 	return nil
 }
 
-func (c *CassandraEventStore) GetEventsByAggregateID(ctx context.Context, aggregateID string) ([]*events.Event, error) {
-	aggregateType := c.getAggregateType(aggregateID)
+// releasePositions records reserved global positions that will never hold an event
+func (c *CassandraEventStore) releasePositions(ctx context.Context, firstPosition uint64, count int) error {
+	batch := c.session.NewBatch(gocql.UnloggedBatch).WithContext(ctx)
+	for i := 0; i < count; i++ {
+		position := firstPosition + uint64(i)
+		batch.Query(
+			`INSERT INTO released_positions (bucket, position) VALUES (?, ?)`,
+			int64(position/positionBucketSize),
+			int64(position),
+		)
+	}
+
+	if err := c.session.ExecuteBatch(batch); err != nil {
+		return NewEventStoreError(ErrCodeStorage, "failed to release global positions", err)
+	}
+	return nil
+}
+
+// reservePositions atomically reserves count consecutive global positions and returns the first one.
+// Every append of the cluster goes through this one lightweight transaction row,
+// which bounds the append throughput to what a single Paxos partition sustains,
+// typically a few hundred to a few thousand appends per second. Positions are
+// not handed out in blocks per writer: readers treat a position that is not
+// written yet as an append in flight, so blocks held by idle writers would
+// stall every subscription until the gap timeout.
+func (c *CassandraEventStore) reservePositions(ctx context.Context, count int) (uint64, error) {
+	for attempt := 0; attempt < maxReserveAttempts; attempt++ {
+		current, err := c.headPosition(ctx)
+		if err != nil {
+			return 0, err
+		}
+
+		var applied bool
+		if current == 0 {
+			applied, err = c.session.Query(
+				`INSERT INTO global_sequence (name, value) VALUES (?, ?) IF NOT EXISTS`,
+				globalSequenceName,
+				int64(count),
+			).WithContext(ctx).SerialConsistency(gocql.LocalSerial).MapScanCAS(make(map[string]interface{}))
+		} else {
+			applied, err = c.session.Query(
+				`UPDATE global_sequence SET value = ? WHERE name = ? IF value = ?`,
+				int64(current)+int64(count),
+				globalSequenceName,
+				int64(current),
+			).WithContext(ctx).SerialConsistency(gocql.LocalSerial).MapScanCAS(make(map[string]interface{}))
+		}
+		if err != nil {
+			return 0, NewEventStoreError(ErrCodeStorage, "failed to reserve global positions", err)
+		}
+
+		if applied {
+			return current + 1, nil
+		}
+	}
 
-	aggregateUUID, err := uuid.Parse(aggregateID)
+	return 0, NewEventStoreError(ErrCodeStorage, "failed to reserve global positions", fmt.Errorf("gave up after %d attempts", maxReserveAttempts))
+}
+
+// headPosition returns the last reserved global position, 0 if no event was ever stored
+func (c *CassandraEventStore) headPosition(ctx context.Context) (uint64, error) {
+	var value int64
+	err := c.session.Query(
+		`SELECT value FROM global_sequence WHERE name = ?`,
+		globalSequenceName,
+	).WithContext(ctx).Consistency(gocql.Consistency(gocql.LocalSerial)).Scan(&value)
+	if err == gocql.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, NewEventStoreError(ErrCodeStorage, "failed to read global position", err)
+	}
+
+	return uint64(value), nil
+}
+
+// currentVersion returns the latest version of an aggregate stream, 0 if it has no events.
+// It reads at serial consistency so it observes every committed conditional append.
+func (c *CassandraEventStore) currentVersion(ctx context.Context, aggregateType string, aggregateID gocql.UUID) (int, error) {
+	var version int
+	err := c.session.Query(
+		`SELECT version
+		 FROM events
+		 WHERE aggregate_type = ? AND aggregate_id = ?
+		 ORDER BY version DESC
+		 LIMIT 1`,
+		aggregateType,
+		aggregateID,
+	).WithContext(ctx).Consistency(gocql.Consistency(gocql.LocalSerial)).Scan(&version)
+	if err == gocql.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, NewEventStoreError(ErrCodeStorage, "failed to read stream version", err)
+	}
+
+	return version, nil
+}
+
+func (c *CassandraEventStore) GetEventsByAggregateID(ctx context.Context, aggregateID string) ([]*events.Event, error) {
+	aggregateUUID, err := gocql.ParseUUID(aggregateID)
 	if err != nil {
 		return nil, NewEventStoreError(ErrCodeSerialization, "invalid aggregate ID", err)
 	}
 
+	aggregateType, err := c.getAggregateType(ctx, aggregateUUID)
+	if err != nil {
+		return nil, err
+	}
+
 	iter := c.session.Query(
-		`SELECT event_id, event_type, payload, metadata, created_at, version
+		`SELECT `+eventColumns+`
 		 FROM events
 		 WHERE aggregate_type = ? AND aggregate_id = ?`,
 		aggregateType,
 		aggregateUUID,
-	).Iter()
-
-	var eventList []*events.Event
-	var eventID, eventType, payload, metadata string
-	var createdAt time.Time
-	var version int
-
-	for iter.Scan(&eventID, &eventType, &payload, &metadata, &createdAt, &version) {
-		var metadataMap map[string]interface{}
-		if err := json.Unmarshal([]byte(metadata), &metadataMap); err != nil {
-			metadataMap = make(map[string]interface{})
-		}
-
-		event := &events.Event{
-			ID:          eventID,
-			Type:        events.EventType(eventType),
-			AggregateID: aggregateID,
-			Version:     version,
-			Timestamp:   createdAt,
-			Payload:     json.RawMessage(payload),
-			Metadata:    metadataMap,
-		}
+	).WithContext(ctx).Iter()
 
-		eventList = append(eventList, event)
-	}
+	return c.scanEvents(iter)
+}
 
-	if err := iter.Close(); err != nil {
-		return nil, NewEventStoreError(ErrCodeStorage, "failed to retrieve events", err)
+func (c *CassandraEventStore) GetEventsByType(ctx context.Context, eventType events.EventType) ([]*events.Event, error) {
+	iterator, err := c.IterateEventsByType(ctx, eventType, "")
+	if err != nil {
+		return nil, err
 	}
 
-	return eventList, nil
+	return collectEvents(iterator)
 }
 
-func (c *CassandraEventStore) GetEventsByType(ctx context.Context, eventType events.EventType) ([]*events.Event, error) {
-	iter := c.session.Query(
-		`SELECT aggregate_type, aggregate_id, event_id, event_type, payload, metadata, created_at, version
+func (c *CassandraEventStore) IterateEventsByType(ctx context.Context, eventType events.EventType, pageToken string) (EventIterator, error) {
+	return c.newIterator(ctx, pageToken,
+		`SELECT `+eventColumns+`
 		 FROM events
 		 WHERE event_type = ?`,
 		string(eventType),
-	).Iter()
+	)
+}
 
-	var eventList []*events.Event
-	var aggregateType, aggregateID, eventID, eventTypeStr, payload, metadata string
-	var createdAt time.Time
-	var version int
+func (c *CassandraEventStore) GetEventsByAggregateType(ctx context.Context, aggregateType events.AggregateType) ([]*events.Event, error) {
+	iter := c.session.Query(
+		`SELECT aggregate_id
+		 FROM aggregates_by_type
+		 WHERE aggregate_type = ?`,
+		string(aggregateType),
+	).WithContext(ctx).Iter()
 
-	for iter.Scan(&aggregateType, &aggregateID, &eventID, &eventTypeStr, &payload, &metadata, &createdAt, &version) {
-		var metadataMap map[string]interface{}
-		if err := json.Unmarshal([]byte(metadata), &metadataMap); err != nil {
-			metadataMap = make(map[string]interface{})
-		}
+	var aggregateIDs []gocql.UUID
+	var aggregateID gocql.UUID
+	for iter.Scan(&aggregateID) {
+		aggregateIDs = append(aggregateIDs, aggregateID)
+	}
 
-		event := &events.Event{
-			ID:          eventID,
-			Type:        events.EventType(eventTypeStr),
-			AggregateID: aggregateID,
-			Version:     version,
-			Timestamp:   createdAt,
-			Payload:     json.RawMessage(payload),
-			Metadata:    metadataMap,
+	if err := iter.Close(); err != nil {
+		return nil, NewEventStoreError(ErrCodeStorage, "failed to retrieve aggregates", err)
+	}
+
+	var eventList []*events.Event
+	for _, id := range aggregateIDs {
+		// Streams listed by BackfillAggregateTypes may still live in the default partition
+		partition, err := c.getAggregateType(ctx, id)
+		if err != nil {
+			return nil, err
 		}
 
-		eventList = append(eventList, event)
-	}
+		iter := c.session.Query(
+			`SELECT `+eventColumns+`
+			 FROM events
+			 WHERE aggregate_type = ? AND aggregate_id = ?`,
+			partition,
+			id,
+		).WithContext(ctx).Iter()
 
-	if err := iter.Close(); err != nil {
-		return nil, NewEventStoreError(ErrCodeStorage, "failed to retrieve events", err)
+		aggregateEvents, err := c.scanEvents(iter)
+		if err != nil {
+			return nil, err
+		}
+
+		eventList = append(eventList, aggregateEvents...)
 	}
 
 	return eventList, nil
 }
 
 func (c *CassandraEventStore) GetEventsByAggregateIDAndVersion(ctx context.Context, aggregateID string, version int) ([]*events.Event, error) {
-	aggregateType := c.getAggregateType(aggregateID)
-
-	aggregateUUID, err := uuid.Parse(aggregateID)
+	aggregateUUID, err := gocql.ParseUUID(aggregateID)
 	if err != nil {
 		return nil, NewEventStoreError(ErrCodeSerialization, "invalid aggregate ID", err)
 	}
 
+	aggregateType, err := c.getAggregateType(ctx, aggregateUUID)
+	if err != nil {
+		return nil, err
+	}
+
 	iter := c.session.Query(
-		`SELECT event_id, event_type, payload, metadata, created_at, version
+		`SELECT `+eventColumns+`
 		 FROM events
 		 WHERE aggregate_type = ? AND aggregate_id = ? AND version <= ?`,
 		aggregateType,
 		aggregateUUID,
 		version,
-	).Iter()
-
-	var eventList []*events.Event
-	var eventID, eventType, payload, metadata string
-	var createdAt time.Time
-	var eventVersion int
+	).WithContext(ctx).Iter()
 
-	for iter.Scan(&eventID, &eventType, &payload, &metadata, &createdAt, &eventVersion) {
-		var metadataMap map[string]interface{}
-		if err := json.Unmarshal([]byte(metadata), &metadataMap); err != nil {
-			metadataMap = make(map[string]interface{})
-		}
-
-		event := &events.Event{
-			ID:          eventID,
-			Type:        events.EventType(eventType),
-			AggregateID: aggregateID,
-			Version:     eventVersion,
-			Timestamp:   createdAt,
-			Payload:     json.RawMessage(payload),
-			Metadata:    metadataMap,
-		}
+	return c.scanEvents(iter)
+}
 
-		eventList = append(eventList, event)
+func (c *CassandraEventStore) GetEventsByAggregateIDAfterVersion(ctx context.Context, aggregateID string, version int) ([]*events.Event, error) {
+	aggregateUUID, err := gocql.ParseUUID(aggregateID)
+	if err != nil {
+		return nil, NewEventStoreError(ErrCodeSerialization, "invalid aggregate ID", err)
 	}
 
-	if err := iter.Close(); err != nil {
-		return nil, NewEventStoreError(ErrCodeStorage, "failed to retrieve events", err)
+	aggregateType, err := c.getAggregateType(ctx, aggregateUUID)
+	if err != nil {
+		return nil, err
 	}
 
-	return eventList, nil
+	iter := c.session.Query(
+		`SELECT `+eventColumns+`
+		 FROM events
+		 WHERE aggregate_type = ? AND aggregate_id = ? AND version > ?`,
+		aggregateType,
+		aggregateUUID,
+		version,
+	).WithContext(ctx).Iter()
+
+	return c.scanEvents(iter)
 }
 
 func (c *CassandraEventStore) GetEventsByTimeRange(ctx context.Context, startTime, endTime string) ([]*events.Event, error) {
+	iterator, err := c.IterateEventsByTimeRange(ctx, startTime, endTime, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return collectEvents(iterator)
+}
+
+func (c *CassandraEventStore) IterateEventsByTimeRange(ctx context.Context, startTime, endTime string, pageToken string) (EventIterator, error) {
 	start, err := time.Parse(time.RFC3339, startTime)
 	if err != nil {
 		return nil, NewEventStoreError(ErrCodeSerialization, "invalid start time", err)
@@ -241,33 +652,412 @@ func (c *CassandraEventStore) GetEventsByTimeRange(ctx context.Context, startTim
 		return nil, NewEventStoreError(ErrCodeSerialization, "invalid end time", err)
 	}
 
-	iter := c.session.Query(
-		`SELECT aggregate_type, aggregate_id, event_id, event_type, payload, metadata, created_at, version
+	return c.newIterator(ctx, pageToken,
+		`SELECT `+eventColumns+`
 		 FROM events
 		 WHERE created_at >= ? AND created_at <= ?`,
 		start,
 		end,
-	).Iter()
+	)
+}
+
+// newIterator creates an iterator fetching the rows of a query one page at a time
+func (c *CassandraEventStore) newIterator(ctx context.Context, pageToken string, query string, args ...interface{}) (EventIterator, error) {
+	token, err := decodePageToken(pageToken)
+	if err != nil {
+		return nil, err
+	}
+
+	it := &cassandraEventIterator{
+		store:     c,
+		ctx:       ctx,
+		query:     query,
+		args:      args,
+		nextState: token.State,
+		index:     -1,
+	}
+
+	// Resuming re-reads the page holding the token's event and skips past it
+	if pageToken != "" {
+		if !it.fetchPage() {
+			return it, nil
+		}
+		it.index = int(token.Offset) - 1
+	}
+
+	return it, nil
+}
+
+// cassandraEventIterator pages through a query manually so that every event
+// has a resumable position: the paging state of its page plus its offset
+type cassandraEventIterator struct {
+	store     *CassandraEventStore
+	ctx       context.Context
+	query     string
+	args      []interface{}
+	state     []byte // paging state of the current page
+	nextState []byte // paging state of the following page
+	page      []*events.Event
+	index     int
+	fetched   bool
+	err       error
+}
+
+// Next advances to the next event, fetching the next page when needed
+func (it *cassandraEventIterator) Next() bool {
+	for {
+		if it.index+1 < len(it.page) {
+			it.index++
+			return true
+		}
+
+		if it.fetched && len(it.nextState) == 0 {
+			return false
+		}
+
+		if !it.fetchPage() {
+			return false
+		}
+	}
+}
+
+// fetchPage loads the page following the current one
+func (it *cassandraEventIterator) fetchPage() bool {
+	if it.err != nil {
+		return false
+	}
+
+	iter := it.store.session.Query(it.query, it.args...).
+		WithContext(it.ctx).
+		PageSize(iteratorPageSize).
+		PageState(it.nextState).
+		Iter()
+
+	it.state = it.nextState
+	it.nextState = iter.PageState()
+	it.fetched = true
+	it.index = -1
+
+	it.page, it.err = it.store.scanEvents(iter)
+	return it.err == nil
+}
+
+// Event returns the current event
+func (it *cassandraEventIterator) Event() *events.Event {
+	if it.index < 0 || it.index >= len(it.page) {
+		return nil
+	}
+	return it.page[it.index]
+}
+
+// Err returns the error that stopped the iteration
+func (it *cassandraEventIterator) Err() error {
+	return it.err
+}
+
+// PageToken returns a token resuming after the current event
+func (it *cassandraEventIterator) PageToken() string {
+	return encodePageToken(it.state, uint64(it.index+1))
+}
+
+// Close releases the current page
+func (it *cassandraEventIterator) Close() error {
+	it.page = nil
+	return nil
+}
+
+// ReadAll retrieves events in global order after a position. Positions missing
+// from the global log are looked up in the events table, so events whose log
+// row was not written after their append committed are still read, and the
+// row is rebuilt. Positions released by failed appends are skipped.
+func (c *CassandraEventStore) ReadAll(ctx context.Context, fromPosition uint64, limit int) ([]*events.Event, error) {
+	eventList, _, err := c.readAll(ctx, fromPosition, limit)
+	return eventList, err
+}
+
+// readAll implements ReadAll and returns the position up to which the read is
+// complete: the first reserved position that is neither written nor released
+// may belong to an append in flight.
+func (c *CassandraEventStore) readAll(ctx context.Context, fromPosition uint64, limit int) ([]*events.Event, uint64, error) {
+	head, err := c.headPosition(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
 
 	var eventList []*events.Event
-	var aggregateType, aggregateID, eventID, eventType, payload, metadata string
-	var createdAt time.Time
-	var version int
+	for bucket := (fromPosition + 1) / positionBucketSize; bucket <= head/positionBucketSize; bucket++ {
+		query := `SELECT ` + eventColumns + `
+			 FROM events_by_position
+			 WHERE bucket = ? AND position > ?`
+		args := []interface{}{int64(bucket), int64(fromPosition)}
+		if limit > 0 {
+			query += ` LIMIT ?`
+			args = append(args, limit-len(eventList))
+		}
 
-	for iter.Scan(&aggregateType, &aggregateID, &eventID, &eventType, &payload, &metadata, &createdAt, &version) {
-		var metadataMap map[string]interface{}
-		if err := json.Unmarshal([]byte(metadata), &metadataMap); err != nil {
-			metadataMap = make(map[string]interface{})
+		bucketEvents, err := c.scanEvents(c.session.Query(query, args...).WithContext(ctx).Iter())
+		if err != nil {
+			return nil, 0, err
 		}
 
-		event := &events.Event{
-			ID:          eventID,
-			Type:        events.EventType(eventType),
-			AggregateID: aggregateID,
-			Version:     version,
-			Timestamp:   createdAt,
-			Payload:     json.RawMessage(payload),
-			Metadata:    metadataMap,
+		eventList = append(eventList, bucketEvents...)
+		if limit > 0 && len(eventList) >= limit {
+			break
+		}
+	}
+
+	end := head
+	if limit > 0 && len(eventList) >= limit {
+		end = eventList[len(eventList)-1].Position
+	}
+
+	eventList, checked, err := c.repairGaps(ctx, eventList, fromPosition, end)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if limit > 0 && len(eventList) > limit {
+		eventList = eventList[:limit]
+		checked = min(checked, eventList[limit-1].Position)
+	}
+	if eventList == nil {
+		eventList = []*events.Event{}
+	}
+
+	return eventList, checked, nil
+}
+
+// repairGaps adds to events read from the global log, in position order, the
+// events stored at the positions between fromPosition and end that have no log
+// row, and returns the position up to which every position was found or
+// released. Only the first maxRepairedPositions missing positions are looked
+// up: the events after the first unchecked one are dropped, to be read again
+// by the next read, so callers never move past an unchecked position.
+func (c *CassandraEventStore) repairGaps(ctx context.Context, eventList []*events.Event, fromPosition, end uint64) ([]*events.Event, uint64, error) {
+	var missing []uint64
+	next := fromPosition + 1
+	for _, event := range eventList {
+		for ; next < event.Position && len(missing) <= maxRepairedPositions; next++ {
+			missing = append(missing, next)
+		}
+		next = event.Position + 1
+	}
+	for ; next <= end && len(missing) <= maxRepairedPositions; next++ {
+		missing = append(missing, next)
+	}
+
+	if len(missing) == 0 {
+		return eventList, end, nil
+	}
+
+	checked := end
+	if len(missing) > maxRepairedPositions {
+		unchecked := missing[maxRepairedPositions]
+		checked = unchecked - 1
+		missing = missing[:maxRepairedPositions]
+		for i, event := range eventList {
+			if event.Position > unchecked {
+				eventList = eventList[:i]
+				break
+			}
+		}
+	}
+
+	released, err := c.releasedPositions(ctx, missing[0], missing[len(missing)-1])
+	if err != nil {
+		return nil, 0, err
+	}
+
+	repaired := false
+	for _, position := range missing {
+		if released[position] {
+			continue
+		}
+
+		event, err := c.repairPosition(ctx, position)
+		if err != nil {
+			return nil, 0, err
+		}
+		if event != nil {
+			eventList = append(eventList, event)
+			repaired = true
+		} else if position <= checked {
+			// The position may still be written by an append in flight
+			checked = position - 1
+		}
+	}
+
+	if repaired {
+		sort.Slice(eventList, func(i, j int) bool { return eventList[i].Position < eventList[j].Position })
+	}
+	return eventList, checked, nil
+}
+
+// releasedPositions returns the released global positions between first and last
+func (c *CassandraEventStore) releasedPositions(ctx context.Context, first, last uint64) (map[uint64]bool, error) {
+	released := make(map[uint64]bool)
+	for bucket := first / positionBucketSize; bucket <= last/positionBucketSize; bucket++ {
+		iter := c.session.Query(
+			`SELECT position FROM released_positions WHERE bucket = ? AND position >= ? AND position <= ?`,
+			int64(bucket),
+			int64(first),
+			int64(last),
+		).WithContext(ctx).Iter()
+
+		var position int64
+		for iter.Scan(&position) {
+			released[uint64(position)] = true
+		}
+		if err := iter.Close(); err != nil {
+			return nil, NewEventStoreError(ErrCodeStorage, "failed to read released positions", err)
+		}
+	}
+	return released, nil
+}
+
+// repairPosition looks up the event stored at a global position that has no
+// log row and rebuilds the row. It returns nil if no event holds the position,
+// for an append still in flight or one that failed.
+func (c *CassandraEventStore) repairPosition(ctx context.Context, position uint64) (*events.Event, error) {
+	row := positionRow{position: position}
+	err := c.session.Query(
+		`SELECT aggregate_type, aggregate_id, version, event_id, event_type, created_at, schema_version, content_type, data
+		 FROM events
+		 WHERE position = ?`,
+		int64(position),
+	).WithContext(ctx).Scan(&row.aggregateType, &row.aggregateID, &row.version, &row.eventID, &row.eventType, &row.createdAt, &row.schemaVersion, &row.contentType, &row.data)
+	if err == gocql.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, NewEventStoreError(ErrCodeStorage, "failed to look up event by position", err)
+	}
+
+	event, err := c.serializers.Deserialize(row.contentType, row.data)
+	if err != nil {
+		return nil, NewEventStoreError(ErrCodeSerialization, "failed to deserialize event", err)
+	}
+	event.Position = position
+
+	if err := c.indexPositions(ctx, []positionRow{row}); err != nil {
+		return nil, err
+	}
+
+	return event, nil
+}
+
+// Subscribe polls the global log for matching events after a position.
+// Positions are reserved before events are written, so a gap may be an append
+// still in flight: delivery pauses at a gap until it is filled or times out.
+func (c *CassandraEventStore) Subscribe(ctx context.Context, fromPosition uint64, filter SubscriptionFilter, handler bus.EventHandler) error {
+	return pollSubscription(ctx, c.readAll, fromPosition, filter, handler)
+}
+
+// RedactStream removes payload fields from the events of an aggregate stream,
+// in both the stream partition and the global log
+func (c *CassandraEventStore) RedactStream(ctx context.Context, aggregateID string, fields map[events.EventType][]string) (int, error) {
+	aggregateUUID, err := gocql.ParseUUID(aggregateID)
+	if err != nil {
+		return 0, NewEventStoreError(ErrCodeSerialization, "invalid aggregate ID", err)
+	}
+
+	aggregateType, err := c.getAggregateType(ctx, aggregateUUID)
+	if err != nil {
+		return 0, err
+	}
+
+	eventList, err := c.GetEventsByAggregateID(ctx, aggregateID)
+	if err != nil {
+		return 0, err
+	}
+
+	redacted := 0
+	for _, event := range eventList {
+		payload, changed, err := redactPayload(event.Payload, fields[event.Type])
+		if err != nil {
+			return redacted, err
+		}
+		if !changed {
+			continue
+		}
+
+		// Redacted events are rewritten with the current serializer, which
+		// also clears the payload column of events stored before serializers
+		redactedEvent := *event
+		redactedEvent.Payload = payload
+		data, err := c.serializer.Serialize(&redactedEvent)
+		if err != nil {
+			return redacted, NewEventStoreError(ErrCodeSerialization, "failed to serialize event", err)
+		}
+
+		batch := c.session.NewBatch(gocql.LoggedBatch).WithContext(ctx)
+		batch.Query(
+			`UPDATE events SET payload = null, metadata = null, content_type = ?, data = ? WHERE aggregate_type = ? AND aggregate_id = ? AND version = ?`,
+			c.serializer.ContentType(),
+			data,
+			aggregateType,
+			aggregateUUID,
+			event.Version,
+		)
+		batch.Query(
+			`UPDATE events_by_position SET payload = null, metadata = null, content_type = ?, data = ? WHERE bucket = ? AND position = ?`,
+			c.serializer.ContentType(),
+			data,
+			int64(event.Position/positionBucketSize),
+			int64(event.Position),
+		)
+
+		if err := c.session.ExecuteBatch(batch); err != nil {
+			return redacted, NewEventStoreError(ErrCodeStorage, "failed to redact event", err)
+		}
+		redacted++
+	}
+
+	return redacted, nil
+}
+
+// scanEvents reads all rows of an iterator selecting eventColumns
+func (c *CassandraEventStore) scanEvents(iter *gocql.Iter) ([]*events.Event, error) {
+	var eventList []*events.Event
+	var aggregateType, aggregateID, eventID, eventType, payload, metadata, contentType string
+	var createdAt time.Time
+	var version, schemaVersion int
+	var position int64
+	var data []byte
+
+	for iter.Scan(&aggregateType, &aggregateID, &eventID, &eventType, &payload, &metadata, &createdAt, &version, &position, &schemaVersion, &contentType, &data) {
+		var event *events.Event
+
+		if contentType != "" {
+			var err error
+			if event, err = c.serializers.Deserialize(contentType, data); err != nil {
+				iter.Close()
+				return nil, NewEventStoreError(ErrCodeSerialization, "failed to deserialize event", err)
+			}
+			event.Position = uint64(position)
+		} else {
+			// Events stored before serializers keep payload and metadata in their own columns
+			var metadataMap map[string]interface{}
+			if err := json.Unmarshal([]byte(metadata), &metadataMap); err != nil {
+				metadataMap = make(map[string]interface{})
+			}
+
+			event = &events.Event{
+				ID:            eventID,
+				Type:          events.EventType(eventType),
+				AggregateID:   aggregateID,
+				AggregateType: events.AggregateType(aggregateType),
+				Version:       version,
+				Timestamp:     createdAt,
+				Payload:       json.RawMessage(payload),
+				Metadata:      metadataMap,
+				SchemaVersion: schemaVersion,
+				Position:      uint64(position),
+			}
+
+			if aggregateType == defaultAggregateType {
+				event.AggregateType = events.AggregateTypeOf(event.Type)
+			}
 		}
 
 		eventList = append(eventList, event)
@@ -280,7 +1070,111 @@ func (c *CassandraEventStore) GetEventsByTimeRange(ctx context.Context, startTim
 	return eventList, nil
 }
 
-func (c *CassandraEventStore) getAggregateType(aggregateID string) string {
-	return "default"
+// getAggregateType returns the partition key prefix of an aggregate stream.
+// Streams that were never registered use defaultAggregateType.
+func (c *CassandraEventStore) getAggregateType(ctx context.Context, aggregateID gocql.UUID) (string, error) {
+	if aggregateType, ok := c.aggregateTypes.Load(aggregateID); ok {
+		return aggregateType.(string), nil
+	}
+
+	var aggregateType string
+	err := c.session.Query(
+		`SELECT aggregate_type
+		 FROM aggregates
+		 WHERE aggregate_id = ?`,
+		aggregateID,
+	).WithContext(ctx).Scan(&aggregateType)
+	if err == gocql.ErrNotFound {
+		return defaultAggregateType, nil
+	}
+	if err != nil {
+		return "", NewEventStoreError(ErrCodeStorage, "failed to resolve aggregate type", err)
+	}
+
+	c.aggregateTypes.Store(aggregateID, aggregateType)
+	return aggregateType, nil
+}
+
+// registerAggregate records the partition of a new aggregate stream, before its first events are written
+func (c *CassandraEventStore) registerAggregate(ctx context.Context, aggregateType string, aggregateID gocql.UUID) error {
+	err := c.session.Query(
+		`INSERT INTO aggregates (aggregate_id, aggregate_type) VALUES (?, ?)`,
+		aggregateID,
+		aggregateType,
+	).WithContext(ctx).Exec()
+	if err != nil {
+		return NewEventStoreError(ErrCodeStorage, "failed to register aggregate", err)
+	}
+
+	c.aggregateTypes.Store(aggregateID, aggregateType)
+	return nil
+}
+
+// indexAggregateType lists an aggregate stream under its type for GetEventsByAggregateType
+func (c *CassandraEventStore) indexAggregateType(ctx context.Context, aggregateType string, aggregateID gocql.UUID) error {
+	err := c.session.Query(
+		`INSERT INTO aggregates_by_type (aggregate_type, aggregate_id) VALUES (?, ?)`,
+		aggregateType,
+		aggregateID,
+	).WithContext(ctx).Exec()
+	if err != nil {
+		return NewEventStoreError(ErrCodeStorage, "failed to index aggregate type", err)
+	}
+
+	return nil
 }
 
+// BackfillAggregateTypes lists every stream of the events table under its
+// aggregate type in aggregates_by_type and returns the number of streams.
+//
+// Streams written before aggregate types were recorded live in the "default"
+// partition and are missing from aggregates_by_type, so GetEventsByAggregateType
+// does not return them; their type is derived from their first event. Run it
+// once after upgrading such a keyspace with InitializeSchema. It scans the whole
+// events table and is safe to run again, for example to restore streams whose
+// listing failed after their first append was committed.
+func (c *CassandraEventStore) BackfillAggregateTypes(ctx context.Context) (int, error) {
+	iter := c.session.Query(
+		`SELECT DISTINCT aggregate_type, aggregate_id
+		 FROM events`,
+	).WithContext(ctx).PageSize(iteratorPageSize).Iter()
+
+	count := 0
+	var partition string
+	var aggregateID gocql.UUID
+	for iter.Scan(&partition, &aggregateID) {
+		aggregateType := partition
+		if aggregateType == defaultAggregateType {
+			var eventType string
+			err := c.session.Query(
+				`SELECT event_type
+				 FROM events
+				 WHERE aggregate_type = ? AND aggregate_id = ?
+				 LIMIT 1`,
+				partition,
+				aggregateID,
+			).WithContext(ctx).Scan(&eventType)
+			if err != nil {
+				iter.Close()
+				return count, NewEventStoreError(ErrCodeStorage, "failed to read stream", err)
+			}
+
+			aggregateType = string(events.AggregateTypeOf(events.EventType(eventType)))
+			if aggregateType == "" {
+				continue
+			}
+		}
+
+		if err := c.indexAggregateType(ctx, aggregateType, aggregateID); err != nil {
+			iter.Close()
+			return count, err
+		}
+		count++
+	}
+
+	if err := iter.Close(); err != nil {
+		return count, NewEventStoreError(ErrCodeStorage, "failed to scan streams", err)
+	}
+
+	return count, nil
+}
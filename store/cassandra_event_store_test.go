@@ -0,0 +1,238 @@
+//go:build cassandra
+
+// The Cassandra tests run against a local Cassandra node, for example:
+//
+//	docker run -d --name cassandra -p 9042:9042 cassandra:4.1
+//	CASSANDRA_HOSTS=127.0.0.1 go test -tags cassandra ./store/
+//
+// They are skipped when CASSANDRA_HOSTS is not set.
+
+package store
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/kegazani/metachat-event-sourcing/events"
+)
+
+// cassandraTestKeyspace is the keyspace created by the Cassandra tests
+const cassandraTestKeyspace = "metachat_event_sourcing_test"
+
+// newCassandraTestStore connects to the nodes listed in CASSANDRA_HOSTS and initializes the test keyspace
+func newCassandraTestStore(t *testing.T) *CassandraEventStore {
+	t.Helper()
+
+	hosts := os.Getenv("CASSANDRA_HOSTS")
+	if hosts == "" {
+		t.Skip("CASSANDRA_HOSTS is not set")
+	}
+
+	cluster := gocql.NewCluster(strings.Split(hosts, ",")...)
+	cluster.Timeout = 10 * time.Second
+	cluster.ConnectTimeout = 10 * time.Second
+
+	bootstrap, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatalf("failed to connect to Cassandra: %v", err)
+	}
+	err = NewCassandraEventStore(bootstrap).InitializeSchema(cassandraTestKeyspace)
+	bootstrap.Close()
+	if err != nil {
+		t.Fatalf("failed to initialize schema: %v", err)
+	}
+
+	cluster.Keyspace = cassandraTestKeyspace
+	session, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatalf("failed to connect to keyspace: %v", err)
+	}
+	t.Cleanup(session.Close)
+
+	return NewCassandraEventStore(session)
+}
+
+// appendConcurrently runs two appends at the same expected version at once and returns their errors
+func appendConcurrently(t *testing.T, eventStore *CassandraEventStore, aggregateID string, expectedVersion int) [2]error {
+	t.Helper()
+
+	var results [2]error
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+
+	for i := range results {
+		event := newDiaryTestEvent(t, aggregateID, expectedVersion+1)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			results[i] = eventStore.AppendToStream(context.Background(), aggregateID, expectedVersion, []*events.Event{event})
+		}(i)
+	}
+
+	close(start)
+	wg.Wait()
+	return results
+}
+
+// assertOneConflict checks that exactly one of two concurrent appends won
+func assertOneConflict(t *testing.T, results [2]error) {
+	t.Helper()
+
+	succeeded, conflicts := 0, 0
+	for _, err := range results {
+		switch {
+		case err == nil:
+			succeeded++
+		case errors.Is(err, ErrVersionConflict):
+			conflicts++
+		default:
+			t.Fatalf("unexpected append error: %v", err)
+		}
+	}
+
+	if succeeded != 1 || conflicts != 1 {
+		t.Fatalf("expected one append and one version conflict, got %d appends and %d conflicts", succeeded, conflicts)
+	}
+}
+
+func TestCassandraConcurrentAppendToNewStream(t *testing.T) {
+	eventStore := newCassandraTestStore(t)
+
+	for round := 0; round < 10; round++ {
+		aggregateID := gocql.MustRandomUUID().String()
+
+		assertOneConflict(t, appendConcurrently(t, eventStore, aggregateID, ExpectedVersionNoStream))
+		assertVersions(t, eventStore, aggregateID, 1)
+	}
+}
+
+func TestCassandraConcurrentAppendToExistingStream(t *testing.T) {
+	eventStore := newCassandraTestStore(t)
+	ctx := context.Background()
+
+	for round := 0; round < 10; round++ {
+		aggregateID := gocql.MustRandomUUID().String()
+
+		created := newDiaryTestEvent(t, aggregateID, 1)
+		if err := eventStore.AppendToStream(ctx, aggregateID, ExpectedVersionNoStream, []*events.Event{created}); err != nil {
+			t.Fatalf("failed to create stream: %v", err)
+		}
+
+		assertOneConflict(t, appendConcurrently(t, eventStore, aggregateID, 1))
+		assertVersions(t, eventStore, aggregateID, 2)
+	}
+}
+
+func TestCassandraGetEventsByAggregateTypeFindsLegacyStreams(t *testing.T) {
+	eventStore := newCassandraTestStore(t)
+	ctx := context.Background()
+
+	current := gocql.MustRandomUUID()
+	if err := eventStore.AppendToStream(ctx, current.String(), ExpectedVersionNoStream, []*events.Event{newDiaryTestEvent(t, current.String(), 1)}); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+
+	// A stream written before aggregate types were recorded has no aggregates row
+	legacy := gocql.MustRandomUUID()
+	err := eventStore.session.Query(
+		`INSERT INTO events (aggregate_type, aggregate_id, version, event_id, event_type, created_at, payload, metadata)
+		 VALUES (?, ?, 1, ?, ?, ?, '{"user_id":"user-1"}', '{}')`,
+		defaultAggregateType,
+		legacy,
+		gocql.MustRandomUUID(),
+		string(events.DiaryEntryCreatedEvent),
+		time.Now(),
+	).Exec()
+	if err != nil {
+		t.Fatalf("failed to write legacy stream: %v", err)
+	}
+
+	if _, err := eventStore.BackfillAggregateTypes(ctx); err != nil {
+		t.Fatalf("failed to backfill aggregate types: %v", err)
+	}
+
+	diaryEvents, err := eventStore.GetEventsByAggregateType(ctx, events.DiaryAggregateType)
+	if err != nil {
+		t.Fatalf("failed to read diary events: %v", err)
+	}
+
+	found := make(map[string]bool)
+	for _, event := range diaryEvents {
+		found[event.AggregateID] = true
+		if event.AggregateType != events.DiaryAggregateType {
+			t.Fatalf("expected diary events, got %s of %s", event.Type, event.AggregateType)
+		}
+	}
+	if !found[current.String()] || !found[legacy.String()] {
+		t.Fatalf("expected the current and the legacy stream, got %v", found)
+	}
+}
+
+func TestCassandraConcurrentAppendAtAnyVersion(t *testing.T) {
+	eventStore := newCassandraTestStore(t)
+	ctx := context.Background()
+
+	for round := 0; round < 10; round++ {
+		aggregateID := gocql.MustRandomUUID().String()
+
+		created := newDiaryTestEvent(t, aggregateID, 1)
+		if err := eventStore.AppendToStream(ctx, aggregateID, ExpectedVersionNoStream, []*events.Event{created}); err != nil {
+			t.Fatalf("failed to create stream: %v", err)
+		}
+
+		// The writer losing the race is appended after the winner instead of conflicting
+		for _, err := range appendConcurrently(t, eventStore, aggregateID, ExpectedVersionAny) {
+			if err != nil {
+				t.Fatalf("expected both appends to succeed, got %v", err)
+			}
+		}
+		assertVersions(t, eventStore, aggregateID, 3)
+	}
+}
+
+func TestCassandraSubscriptionPassesOverConflicts(t *testing.T) {
+	eventStore := newCassandraTestStore(t)
+	ctx := context.Background()
+
+	head, err := eventStore.headPosition(ctx)
+	if err != nil {
+		t.Fatalf("failed to read head position: %v", err)
+	}
+
+	aggregateID := gocql.MustRandomUUID().String()
+	if err := eventStore.AppendToStream(ctx, aggregateID, ExpectedVersionNoStream, newDiaryTestEvents(t, aggregateID, 0, 1)); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+
+	// Both appends reserve a position and the losing one releases it
+	assertOneConflict(t, appendConcurrently(t, eventStore, aggregateID, 1))
+
+	if err := eventStore.AppendToStream(ctx, aggregateID, 2, newDiaryTestEvents(t, aggregateID, 2, 1)); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+
+	subscribe := func(ctx context.Context, handler func(ctx context.Context, event *events.Event) error) error {
+		return eventStore.Subscribe(ctx, head, SubscriptionFilter{}, func(ctx context.Context, event *events.Event) error {
+			if event.AggregateID != aggregateID {
+				return nil
+			}
+			return handler(ctx, event)
+		})
+	}
+
+	// The released position must not hold delivery until the gap timeout
+	positions, gaps := collectSubscription(t, subscribe, 3, subscriptionGapTimeout/2)
+	if len(positions) != 3 {
+		t.Fatalf("expected the 3 events of the stream, got %v", positions)
+	}
+	if len(gaps) != 0 {
+		t.Fatalf("expected no reported gap, got %v", gaps)
+	}
+}
@@ -0,0 +1,112 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+type CassandraSnapshotStore struct {
+	session *gocql.Session
+}
+
+func NewCassandraSnapshotStore(session *gocql.Session) *CassandraSnapshotStore {
+	return &CassandraSnapshotStore{
+		session: session,
+	}
+}
+
+// InitializeSchema creates the keyspace and the snapshots table. The session
+// must be created with the keyspace set to read and write snapshots.
+func (c *CassandraSnapshotStore) InitializeSchema(keyspace string) error {
+	queries := []string{
+		`CREATE KEYSPACE IF NOT EXISTS %s WITH replication = {'class': 'SimpleStrategy', 'replication_factor': 1}`,
+		`CREATE TABLE IF NOT EXISTS %s.snapshots (
+			aggregate_id uuid,
+			version int,
+			state blob,
+			created_at timestamp,
+			PRIMARY KEY (aggregate_id, version)
+		) WITH CLUSTERING ORDER BY (version DESC)`,
+	}
+
+	for _, query := range queries {
+		if err := c.session.Query(fmt.Sprintf(query, keyspace)).Exec(); err != nil {
+			return fmt.Errorf("failed to execute schema query: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (c *CassandraSnapshotStore) SaveSnapshot(ctx context.Context, snapshot *Snapshot) error {
+	aggregateID, err := gocql.ParseUUID(snapshot.AggregateID)
+	if err != nil {
+		return NewEventStoreError(ErrCodeSerialization, "invalid aggregate ID", err)
+	}
+
+	err = c.session.Query(
+		`INSERT INTO snapshots (aggregate_id, version, state, created_at)
+		 VALUES (?, ?, ?, ?)`,
+		aggregateID,
+		snapshot.Version,
+		[]byte(snapshot.State),
+		snapshot.Timestamp,
+	).WithContext(ctx).Exec()
+	if err != nil {
+		return NewEventStoreError(ErrCodeStorage, "failed to save snapshot", err)
+	}
+
+	return nil
+}
+
+func (c *CassandraSnapshotStore) GetLatestSnapshot(ctx context.Context, aggregateID string) (*Snapshot, error) {
+	aggregateUUID, err := gocql.ParseUUID(aggregateID)
+	if err != nil {
+		return nil, NewEventStoreError(ErrCodeSerialization, "invalid aggregate ID", err)
+	}
+
+	var version int
+	var state []byte
+	var createdAt time.Time
+
+	err = c.session.Query(
+		`SELECT version, state, created_at
+		 FROM snapshots
+		 WHERE aggregate_id = ?
+		 LIMIT 1`,
+		aggregateUUID,
+	).WithContext(ctx).Scan(&version, &state, &createdAt)
+	if err == gocql.ErrNotFound {
+		return nil, ErrSnapshotNotFound
+	}
+	if err != nil {
+		return nil, NewEventStoreError(ErrCodeStorage, "failed to retrieve snapshot", err)
+	}
+
+	return &Snapshot{
+		AggregateID: aggregateID,
+		Version:     version,
+		State:       state,
+		Timestamp:   createdAt,
+	}, nil
+}
+
+func (c *CassandraSnapshotStore) DeleteSnapshots(ctx context.Context, aggregateID string) error {
+	aggregateUUID, err := gocql.ParseUUID(aggregateID)
+	if err != nil {
+		return NewEventStoreError(ErrCodeSerialization, "invalid aggregate ID", err)
+	}
+
+	err = c.session.Query(
+		`DELETE FROM snapshots WHERE aggregate_id = ?`,
+		aggregateUUID,
+	).WithContext(ctx).Exec()
+	if err != nil {
+		return NewEventStoreError(ErrCodeStorage, "failed to delete snapshots", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,13 @@
+package store
+
+import "context"
+
+// CheckpointStore records how far named consumers of the global event log,
+// such as an outbox relay, have processed it
+type CheckpointStore interface {
+	// SaveCheckpoint records the global position of the last event a consumer processed
+	SaveCheckpoint(ctx context.Context, name string, position uint64) error
+
+	// GetCheckpoint returns the last saved position of a consumer, or 0 if it has none
+	GetCheckpoint(ctx context.Context, name string) (uint64, error)
+}
@@ -0,0 +1,287 @@
+package store
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/kegazani/metachat-event-sourcing/events"
+)
+
+const (
+	// encryptedValuePrefix marks an encrypted payload field value
+	encryptedValuePrefix = "enc:v1:"
+
+	// MetadataEncryptionSubject is the metadata key holding the subject whose key encrypts an event
+	MetadataEncryptionSubject = "encryption_subject"
+
+	// MetadataEncryptionShredded is set in the metadata of events read after their subject's key was deleted
+	MetadataEncryptionShredded = "encryption_shredded"
+)
+
+// DefaultEncryptedFields are the personal payload fields encrypted by default, by event type
+var DefaultEncryptedFields = map[events.EventType][]string{
+	events.UserRegisteredEvent:     {"email", "date_of_birth"},
+	events.UserProfileUpdatedEvent: {"date_of_birth"},
+	events.DiaryEntryCreatedEvent:  {"title", "content"},
+	events.DiaryEntryUpdatedEvent:  {"title", "content"},
+}
+
+// EncryptingEventStore is an EventStore decorator encrypting selected payload
+// fields with AES-GCM under a data key per user, and decrypting them on every read.
+//
+// The subject of an event is the user_id of its payload, the aggregate ID for
+// user events, or else the subject of the first event of its stream. Deleting
+// a subject's key shreds its data: encrypted fields are dropped on read and
+// MetadataEncryptionShredded is set.
+type EncryptingEventStore struct {
+	eventMapper
+	keys     KeyStore
+	fields   map[events.EventType][]string
+	subjects sync.Map // aggregate ID -> subject
+}
+
+// NewEncryptingEventStore creates an encrypting decorator, encrypting DefaultEncryptedFields if fields is nil
+func NewEncryptingEventStore(eventStore EventStore, keyStore KeyStore, fields map[events.EventType][]string) *EncryptingEventStore {
+	if fields == nil {
+		fields = DefaultEncryptedFields
+	}
+
+	e := &EncryptingEventStore{
+		keys:   keyStore,
+		fields: fields,
+	}
+	e.eventMapper = eventMapper{eventStore: eventStore, mapEvent: e.decryptEvent}
+	return e
+}
+
+// ForgetSubject deletes the data key of a subject, making its encrypted fields unreadable
+func (e *EncryptingEventStore) ForgetSubject(ctx context.Context, subjectID string) error {
+	return e.keys.DeleteKey(ctx, subjectID)
+}
+
+// SaveEvents encrypts and saves a batch of events
+func (e *EncryptingEventStore) SaveEvents(ctx context.Context, eventList []*events.Event) error {
+	return saveEventsByAggregate(ctx, e, eventList)
+}
+
+// AppendToStream encrypts events and appends them to an aggregate stream.
+// The given events are left in plaintext, only the fields assigned by the
+// underlying store are copied back.
+func (e *EncryptingEventStore) AppendToStream(ctx context.Context, aggregateID string, expectedVersion int, eventList []*events.Event) error {
+	encrypted := make([]*events.Event, len(eventList))
+	for i, event := range eventList {
+		encryptedEvent, err := e.encryptEvent(ctx, event)
+		if err != nil {
+			return err
+		}
+		encrypted[i] = encryptedEvent
+	}
+
+	if err := e.eventStore.AppendToStream(ctx, aggregateID, expectedVersion, encrypted); err != nil {
+		return err
+	}
+
+	for i, event := range eventList {
+		event.Version = encrypted[i].Version
+		event.AggregateType = encrypted[i].AggregateType
+		event.SchemaVersion = encrypted[i].SchemaVersion
+		event.Position = encrypted[i].Position
+	}
+
+	return nil
+}
+
+// encryptEvent returns a copy of an event with its configured fields encrypted
+func (e *EncryptingEventStore) encryptEvent(ctx context.Context, event *events.Event) (*events.Event, error) {
+	fields := e.fields[event.Type]
+	if len(fields) == 0 {
+		return event, nil
+	}
+
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(event.Payload, &payload); err != nil {
+		return nil, NewEventStoreError(ErrCodeSerialization, "failed to unmarshal payload", err)
+	}
+
+	subject, err := e.subjectOf(ctx, event)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := e.keys.GetOrCreateKey(ctx, subject)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, field := range fields {
+		value, ok := payload[field]
+		if !ok || string(value) == "null" {
+			continue
+		}
+
+		ciphertext, err := sealField(key, value, field)
+		if err != nil {
+			return nil, NewEventStoreError(ErrCodeSerialization, "failed to encrypt payload", err)
+		}
+
+		payload[field], _ = json.Marshal(encryptedValuePrefix + ciphertext)
+	}
+
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return nil, NewEventStoreError(ErrCodeSerialization, "failed to marshal payload", err)
+	}
+
+	encrypted := *event
+	encrypted.Payload = payloadJSON
+	encrypted.Metadata = copyMetadata(event.Metadata)
+	encrypted.Metadata[MetadataEncryptionSubject] = subject
+
+	e.subjects.Store(event.AggregateID, subject)
+	return &encrypted, nil
+}
+
+// decryptEvent returns a copy of an event with its encrypted fields decrypted,
+// or dropped if the key of its subject was deleted
+func (e *EncryptingEventStore) decryptEvent(ctx context.Context, event *events.Event) (*events.Event, error) {
+	subject, ok := event.Metadata[MetadataEncryptionSubject].(string)
+	if !ok {
+		return event, nil
+	}
+
+	key, err := e.keys.GetKey(ctx, subject)
+	shredded := errors.Is(err, ErrKeyNotFound)
+	if err != nil && !shredded {
+		return nil, err
+	}
+
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(event.Payload, &payload); err != nil {
+		return nil, NewEventStoreError(ErrCodeSerialization, "failed to unmarshal payload", err)
+	}
+
+	for field, value := range payload {
+		var ciphertext string
+		if json.Unmarshal(value, &ciphertext) != nil || !strings.HasPrefix(ciphertext, encryptedValuePrefix) {
+			continue
+		}
+
+		if shredded {
+			delete(payload, field)
+			continue
+		}
+
+		plaintext, err := openField(key, strings.TrimPrefix(ciphertext, encryptedValuePrefix), field)
+		if err != nil {
+			return nil, NewEventStoreError(ErrCodeSerialization, "failed to decrypt payload", err)
+		}
+		payload[field] = plaintext
+	}
+
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return nil, NewEventStoreError(ErrCodeSerialization, "failed to marshal payload", err)
+	}
+
+	decrypted := *event
+	decrypted.Payload = payloadJSON
+	if shredded {
+		decrypted.Metadata = copyMetadata(event.Metadata)
+		decrypted.Metadata[MetadataEncryptionShredded] = true
+	}
+
+	return &decrypted, nil
+}
+
+// subjectOf returns the subject whose key encrypts an event
+func (e *EncryptingEventStore) subjectOf(ctx context.Context, event *events.Event) (string, error) {
+	var payload struct {
+		UserID string `json:"user_id"`
+	}
+	if json.Unmarshal(event.Payload, &payload) == nil && payload.UserID != "" {
+		return payload.UserID, nil
+	}
+
+	if events.AggregateTypeOf(event.Type) == events.UserAggregateType {
+		return event.AggregateID, nil
+	}
+
+	if subject, ok := e.subjects.Load(event.AggregateID); ok {
+		return subject.(string), nil
+	}
+
+	// Later events of a stream, such as diary entry updates, use the subject of its first event
+	first, err := e.eventStore.GetEventsByAggregateIDAndVersion(ctx, event.AggregateID, 1)
+	if err != nil {
+		return "", err
+	}
+	if len(first) > 0 {
+		if subject, ok := first[0].Metadata[MetadataEncryptionSubject].(string); ok {
+			e.subjects.Store(event.AggregateID, subject)
+			return subject, nil
+		}
+	}
+
+	return event.AggregateID, nil
+}
+
+// sealField encrypts a value with AES-GCM, binding it to its field name
+func sealField(key, plaintext []byte, field string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, plaintext, []byte(field))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openField decrypts a value sealed by sealField
+func openField(key []byte, ciphertext string, field string) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	nonce, sealed := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, sealed, []byte(field))
+}
+
+// newGCM creates an AES-GCM cipher from a data key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// copyMetadata returns a copy of event metadata that can be modified
+func copyMetadata(metadata map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(metadata)+1)
+	for k, v := range metadata {
+		result[k] = v
+	}
+	return result
+}
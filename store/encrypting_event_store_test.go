@@ -0,0 +1,138 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/kegazani/metachat-event-sourcing/events"
+)
+
+// newUserRegisteredTestEvent creates the registration event of a user
+func newUserRegisteredTestEvent(t *testing.T, userID string) *events.Event {
+	t.Helper()
+
+	event, err := events.NewEvent(events.UserRegisteredEvent, userID, 1, events.UserRegisteredPayload{
+		Username:    "ada",
+		Email:       "ada@example.com",
+		DateOfBirth: "1815-12-10",
+	}, nil)
+	if err != nil {
+		t.Fatalf("failed to create event: %v", err)
+	}
+	return event
+}
+
+// payloadFields decodes the payload of an event into its fields
+func payloadFields(t *testing.T, event *events.Event) map[string]interface{} {
+	t.Helper()
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(event.Payload, &fields); err != nil {
+		t.Fatalf("invalid payload %s: %v", event.Payload, err)
+	}
+	return fields
+}
+
+// newEncryptingTestStore creates an encrypting store over a memory store holding
+// a registered user and a diary entry of theirs written in two events
+func newEncryptingTestStore(t *testing.T) (*EncryptingEventStore, *MemoryEventStore) {
+	t.Helper()
+
+	inner := NewMemoryEventStore()
+	encrypting := NewEncryptingEventStore(inner, NewMemoryKeyStore(), nil)
+	ctx := context.Background()
+
+	if err := encrypting.AppendToStream(ctx, "user-1", ExpectedVersionNoStream, []*events.Event{newUserRegisteredTestEvent(t, "user-1")}); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	if err := encrypting.AppendToStream(ctx, "diary-1", ExpectedVersionNoStream, newDiaryTestEvents(t, "diary-1", 0, 2)); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	return encrypting, inner
+}
+
+func TestEncryptingEventStoreRoundTrip(t *testing.T) {
+	encrypting, inner := newEncryptingTestStore(t)
+	ctx := context.Background()
+
+	// The underlying store only holds ciphertext of the configured fields
+	stored, err := inner.ReadAll(ctx, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	for _, event := range stored {
+		if strings.Contains(string(event.Payload), "ada@example.com") || strings.Contains(string(event.Payload), "Dear diary") ||
+			strings.Contains(string(event.Payload), "Updated") {
+			t.Fatalf("expected encrypted fields in the stored payload, got %s", event.Payload)
+		}
+		if subject := event.Metadata[MetadataEncryptionSubject]; subject != "user-1" {
+			t.Fatalf("expected %s to be encrypted for user-1, got %v", event.Type, subject)
+		}
+	}
+	if username := payloadFields(t, stored[0])["username"]; username != "ada" {
+		t.Fatalf("expected the username to stay in plaintext, got %v", username)
+	}
+
+	user, err := encrypting.GetEventsByAggregateID(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if fields := payloadFields(t, user[0]); fields["email"] != "ada@example.com" || fields["date_of_birth"] != "1815-12-10" {
+		t.Fatalf("expected the decrypted user fields, got %v", fields)
+	}
+
+	diary, err := encrypting.GetEventsByAggregateID(ctx, "diary-1")
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if fields := payloadFields(t, diary[0]); fields["title"] != "First entry" || fields["content"] != "Dear diary" {
+		t.Fatalf("expected the decrypted diary entry, got %v", fields)
+	}
+	if fields := payloadFields(t, diary[1]); fields["content"] != "Updated" {
+		t.Fatalf("expected the decrypted update, got %v", fields)
+	}
+}
+
+func TestEncryptingEventStoreForgetSubject(t *testing.T) {
+	encrypting, _ := newEncryptingTestStore(t)
+	ctx := context.Background()
+
+	other := newUserRegisteredTestEvent(t, "user-2")
+	if err := encrypting.AppendToStream(ctx, "user-2", ExpectedVersionNoStream, []*events.Event{other}); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+
+	if err := encrypting.ForgetSubject(ctx, "user-1"); err != nil {
+		t.Fatalf("failed to forget subject: %v", err)
+	}
+
+	forgotten, err := encrypting.ReadAll(ctx, 0, 3)
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	for _, event := range forgotten {
+		fields := payloadFields(t, event)
+		for _, field := range DefaultEncryptedFields[event.Type] {
+			if _, ok := fields[field]; ok {
+				t.Fatalf("expected %s of %s to be dropped, got %v", field, event.Type, fields)
+			}
+		}
+		if event.Metadata[MetadataEncryptionShredded] != true {
+			t.Fatalf("expected %s to be marked as shredded", event.Type)
+		}
+	}
+	if username := payloadFields(t, forgotten[0])["username"]; username != "ada" {
+		t.Fatalf("expected fields that are not encrypted to remain, got %v", username)
+	}
+
+	// Other subjects keep their key
+	remembered, err := encrypting.GetEventsByAggregateID(ctx, "user-2")
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if email := payloadFields(t, remembered[0])["email"]; email != "ada@example.com" {
+		t.Fatalf("expected the other user's email to decrypt, got %v", email)
+	}
+}
@@ -0,0 +1,58 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/kegazani/metachat-event-sourcing/events"
+)
+
+// EventRedactor is implemented by event stores that can rewrite stored events to erase personal data
+type EventRedactor interface {
+	// RedactStream removes the given payload fields, by event type, from every
+	// event of an aggregate stream and returns the number of events changed
+	RedactStream(ctx context.Context, aggregateID string, fields map[events.EventType][]string) (int, error)
+}
+
+// SubjectForgetter is implemented by event stores that can crypto-shred the personal data of a subject
+type SubjectForgetter interface {
+	// ForgetSubject makes the encrypted data of a subject unreadable
+	ForgetSubject(ctx context.Context, subjectID string) error
+}
+
+// SnapshotDeleter is implemented by snapshot stores that can delete the snapshots of an aggregate
+type SnapshotDeleter interface {
+	// DeleteSnapshots deletes all snapshots of an aggregate
+	DeleteSnapshots(ctx context.Context, aggregateID string) error
+}
+
+// redactPayload removes fields from a JSON object payload and reports whether any was present
+func redactPayload(payload json.RawMessage, fields []string) (json.RawMessage, bool, error) {
+	if len(fields) == 0 {
+		return payload, false, nil
+	}
+
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &object); err != nil {
+		return nil, false, NewEventStoreError(ErrCodeSerialization, "failed to unmarshal payload", err)
+	}
+
+	changed := false
+	for _, field := range fields {
+		if _, ok := object[field]; ok {
+			delete(object, field)
+			changed = true
+		}
+	}
+
+	if !changed {
+		return payload, false, nil
+	}
+
+	redacted, err := json.Marshal(object)
+	if err != nil {
+		return nil, false, NewEventStoreError(ErrCodeSerialization, "failed to marshal payload", err)
+	}
+
+	return redacted, true, nil
+}
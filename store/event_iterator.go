@@ -0,0 +1,77 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/kegazani/metachat-event-sourcing/events"
+)
+
+// EventIterator streams events from a query without loading them all into memory
+type EventIterator interface {
+	// Next advances to the next event, returning false when the iteration is done or failed
+	Next() bool
+
+	// Event returns the current event
+	Event() *events.Event
+
+	// Err returns the error that stopped the iteration, if any
+	Err() error
+
+	// PageToken returns a token that resumes the same query after the current event
+	PageToken() string
+
+	// Close releases the resources held by the iterator
+	Close() error
+}
+
+// iteratorPageSize is the number of events fetched per round trip by paging iterators
+const iteratorPageSize = 500
+
+// pageToken is the decoded form of the opaque tokens returned by EventIterator.PageToken.
+// State is the backend paging state, if any, and Offset locates the event within it.
+type pageToken struct {
+	State  []byte `json:"s,omitempty"`
+	Offset uint64 `json:"o"`
+}
+
+// encodePageToken encodes a page token into its opaque string form
+func encodePageToken(state []byte, offset uint64) string {
+	data, _ := json.Marshal(pageToken{State: state, Offset: offset})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodePageToken decodes an opaque page token, an empty token starts from the beginning
+func decodePageToken(token string) (pageToken, error) {
+	var decoded pageToken
+	if token == "" {
+		return decoded, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return decoded, NewEventStoreError(ErrCodeSerialization, "invalid page token", err)
+	}
+
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return decoded, NewEventStoreError(ErrCodeSerialization, "invalid page token", err)
+	}
+
+	return decoded, nil
+}
+
+// collectEvents drains an iterator into a slice
+func collectEvents(iterator EventIterator) ([]*events.Event, error) {
+	defer iterator.Close()
+
+	result := make([]*events.Event, 0)
+	for iterator.Next() {
+		result = append(result, iterator.Event())
+	}
+
+	if err := iterator.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
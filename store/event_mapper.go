@@ -0,0 +1,165 @@
+package store
+
+import (
+	"context"
+
+	"github.com/kegazani/metachat-event-sourcing/bus"
+	"github.com/kegazani/metachat-event-sourcing/events"
+)
+
+// eventMapper implements the read methods of an EventStore decorator by
+// transforming every event read from the underlying store. Decorators embed
+// it and implement SaveEvents and AppendToStream themselves.
+type eventMapper struct {
+	eventStore EventStore
+	mapEvent   func(ctx context.Context, event *events.Event) (*events.Event, error)
+}
+
+// GetEventsByAggregateID retrieves all events for a specific aggregate
+func (m *eventMapper) GetEventsByAggregateID(ctx context.Context, aggregateID string) ([]*events.Event, error) {
+	eventList, err := m.eventStore.GetEventsByAggregateID(ctx, aggregateID)
+	return m.mapEvents(ctx, eventList, err)
+}
+
+// GetEventsByType retrieves all events of a specific type
+func (m *eventMapper) GetEventsByType(ctx context.Context, eventType events.EventType) ([]*events.Event, error) {
+	eventList, err := m.eventStore.GetEventsByType(ctx, eventType)
+	return m.mapEvents(ctx, eventList, err)
+}
+
+// GetEventsByAggregateType retrieves all events of aggregates of a specific type
+func (m *eventMapper) GetEventsByAggregateType(ctx context.Context, aggregateType events.AggregateType) ([]*events.Event, error) {
+	eventList, err := m.eventStore.GetEventsByAggregateType(ctx, aggregateType)
+	return m.mapEvents(ctx, eventList, err)
+}
+
+// GetEventsByAggregateIDAndVersion retrieves events for an aggregate up to a specific version
+func (m *eventMapper) GetEventsByAggregateIDAndVersion(ctx context.Context, aggregateID string, version int) ([]*events.Event, error) {
+	eventList, err := m.eventStore.GetEventsByAggregateIDAndVersion(ctx, aggregateID, version)
+	return m.mapEvents(ctx, eventList, err)
+}
+
+// GetEventsByAggregateIDAfterVersion retrieves events for an aggregate after a specific version
+func (m *eventMapper) GetEventsByAggregateIDAfterVersion(ctx context.Context, aggregateID string, version int) ([]*events.Event, error) {
+	eventList, err := m.eventStore.GetEventsByAggregateIDAfterVersion(ctx, aggregateID, version)
+	return m.mapEvents(ctx, eventList, err)
+}
+
+// GetEventsByTimeRange retrieves events within a time range
+func (m *eventMapper) GetEventsByTimeRange(ctx context.Context, startTime, endTime string) ([]*events.Event, error) {
+	eventList, err := m.eventStore.GetEventsByTimeRange(ctx, startTime, endTime)
+	return m.mapEvents(ctx, eventList, err)
+}
+
+// IterateEventsByType streams all events of a specific type
+func (m *eventMapper) IterateEventsByType(ctx context.Context, eventType events.EventType, pageToken string) (EventIterator, error) {
+	iterator, err := m.eventStore.IterateEventsByType(ctx, eventType, pageToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mappingEventIterator{EventIterator: iterator, mapper: m, ctx: ctx}, nil
+}
+
+// IterateEventsByTimeRange streams events within a time range
+func (m *eventMapper) IterateEventsByTimeRange(ctx context.Context, startTime, endTime string, pageToken string) (EventIterator, error) {
+	iterator, err := m.eventStore.IterateEventsByTimeRange(ctx, startTime, endTime, pageToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mappingEventIterator{EventIterator: iterator, mapper: m, ctx: ctx}, nil
+}
+
+// ReadAll retrieves events in global order after a position
+func (m *eventMapper) ReadAll(ctx context.Context, fromPosition uint64, limit int) ([]*events.Event, error) {
+	eventList, err := m.eventStore.ReadAll(ctx, fromPosition, limit)
+	return m.mapEvents(ctx, eventList, err)
+}
+
+// Subscribe delivers transformed events if the underlying store supports subscriptions
+func (m *eventMapper) Subscribe(ctx context.Context, fromPosition uint64, filter SubscriptionFilter, handler bus.EventHandler) error {
+	subscriber, ok := m.eventStore.(EventSubscriber)
+	if !ok {
+		return NewEventStoreError(ErrCodeStorage, "event store does not support subscriptions", nil)
+	}
+
+	return subscriber.Subscribe(ctx, fromPosition, filter, func(ctx context.Context, event *events.Event) error {
+		mapped, err := m.mapEvent(ctx, event)
+		if err != nil {
+			return err
+		}
+		return handler(ctx, mapped)
+	})
+}
+
+// RedactStream redacts the events of the underlying store, so decorated stores
+// can still erase personal data. It returns ErrNotSupported if the underlying
+// store cannot redact events.
+func (m *eventMapper) RedactStream(ctx context.Context, aggregateID string, fields map[events.EventType][]string) (int, error) {
+	redactor, ok := m.eventStore.(EventRedactor)
+	if !ok {
+		return 0, NewEventStoreError(ErrCodeNotSupported, "event store does not support redaction", nil)
+	}
+
+	return redactor.RedactStream(ctx, aggregateID, fields)
+}
+
+// ForgetSubject shreds the data key of a subject in the underlying store. It
+// returns ErrNotSupported if the underlying store does not encrypt events.
+func (m *eventMapper) ForgetSubject(ctx context.Context, subjectID string) error {
+	forgetter, ok := m.eventStore.(SubjectForgetter)
+	if !ok {
+		return NewEventStoreError(ErrCodeNotSupported, "event store does not support crypto-shredding", nil)
+	}
+
+	return forgetter.ForgetSubject(ctx, subjectID)
+}
+
+// mapEvents transforms the result of a query
+func (m *eventMapper) mapEvents(ctx context.Context, eventList []*events.Event, err error) ([]*events.Event, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*events.Event, len(eventList))
+	for i, event := range eventList {
+		if result[i], err = m.mapEvent(ctx, event); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// mappingEventIterator transforms the events of an underlying iterator
+type mappingEventIterator struct {
+	EventIterator
+	mapper  *eventMapper
+	ctx     context.Context
+	current *events.Event
+	err     error
+}
+
+// Next advances to the next event and transforms it
+func (it *mappingEventIterator) Next() bool {
+	if it.err != nil || !it.EventIterator.Next() {
+		it.current = nil
+		return false
+	}
+
+	it.current, it.err = it.mapper.mapEvent(it.ctx, it.EventIterator.Event())
+	return it.err == nil
+}
+
+// Event returns the current transformed event
+func (it *mappingEventIterator) Event() *events.Event {
+	return it.current
+}
+
+// Err returns the error that stopped the iteration
+func (it *mappingEventIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.EventIterator.Err()
+}
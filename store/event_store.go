@@ -9,20 +9,113 @@ import (
 
 // EventStore defines the interface for event storage
 type EventStore interface {
-	// SaveEvents saves a batch of events to the store
+	// SaveEvents saves a batch of events to the store, expecting each aggregate
+	// to be at the version preceding its first event
 	SaveEvents(ctx context.Context, events []*events.Event) error
 
+	// AppendToStream appends events to an aggregate stream if the stream is at
+	// expectedVersion, or at any version for ExpectedVersionAny
+	AppendToStream(ctx context.Context, aggregateID string, expectedVersion int, events []*events.Event) error
+
 	// GetEventsByAggregateID retrieves all events for a specific aggregate
 	GetEventsByAggregateID(ctx context.Context, aggregateID string) ([]*events.Event, error)
 
 	// GetEventsByType retrieves all events of a specific type
 	GetEventsByType(ctx context.Context, eventType events.EventType) ([]*events.Event, error)
 
+	// GetEventsByAggregateType retrieves all events of aggregates of a specific type
+	GetEventsByAggregateType(ctx context.Context, aggregateType events.AggregateType) ([]*events.Event, error)
+
 	// GetEventsByAggregateIDAndVersion retrieves events for an aggregate up to a specific version
 	GetEventsByAggregateIDAndVersion(ctx context.Context, aggregateID string, version int) ([]*events.Event, error)
 
+	// GetEventsByAggregateIDAfterVersion retrieves events for an aggregate with a version greater than the given one
+	GetEventsByAggregateIDAfterVersion(ctx context.Context, aggregateID string, version int) ([]*events.Event, error)
+
 	// GetEventsByTimeRange retrieves events within a time range
 	GetEventsByTimeRange(ctx context.Context, startTime, endTime string) ([]*events.Event, error)
+
+	// IterateEventsByType streams all events of a specific type, resuming after pageToken if it is not empty
+	IterateEventsByType(ctx context.Context, eventType events.EventType, pageToken string) (EventIterator, error)
+
+	// IterateEventsByTimeRange streams events within a time range, resuming after pageToken if it is not empty
+	IterateEventsByTimeRange(ctx context.Context, startTime, endTime string, pageToken string) (EventIterator, error)
+
+	// ReadAll retrieves up to limit events across all aggregates in global order,
+	// starting after fromPosition. A limit of 0 or less reads to the end.
+	ReadAll(ctx context.Context, fromPosition uint64, limit int) ([]*events.Event, error)
+}
+
+// Expected version modes for AppendToStream. Any other non-negative value
+// requires the stream to be exactly at that version.
+const (
+	// ExpectedVersionAny appends regardless of the current stream version.
+	// The store assigns the event versions following the current one.
+	ExpectedVersionAny = -1
+
+	// ExpectedVersionNoStream requires the stream to have no events yet
+	ExpectedVersionNoStream = 0
+)
+
+// prepareAppend checks the expected version against the current stream version
+// and validates, or for ExpectedVersionAny assigns, the versions of the events.
+// It also fills in missing aggregate types and schema versions. The events are
+// only modified once the whole batch is valid.
+func prepareAppend(aggregateID string, currentVersion, expectedVersion int, eventList []*events.Event) error {
+	if expectedVersion < ExpectedVersionAny {
+		return NewEventStoreError(ErrCodeInvalidEvent, "invalid expected version", nil)
+	}
+
+	for i, event := range eventList {
+		if event.AggregateID != aggregateID {
+			return NewEventStoreError(ErrCodeInvalidEvent, "all events must have the same aggregate ID", nil)
+		}
+
+		if expectedVersion != ExpectedVersionAny && event.Version != currentVersion+i+1 {
+			return ErrVersionConflict
+		}
+	}
+
+	if expectedVersion != ExpectedVersionAny && expectedVersion != currentVersion {
+		return ErrVersionConflict
+	}
+
+	for i, event := range eventList {
+		if event.AggregateType == "" {
+			event.AggregateType = events.AggregateTypeOf(event.Type)
+		}
+
+		// Events built without NewEvent are assumed to have the current payload shape
+		if event.SchemaVersion == 0 {
+			event.SchemaVersion = events.LatestSchemaVersion(event.Type)
+		}
+
+		if expectedVersion == ExpectedVersionAny {
+			event.Version = currentVersion + i + 1
+		}
+	}
+
+	return nil
+}
+
+// saveEventsByAggregate implements SaveEvents on top of AppendToStream by
+// appending each run of events of the same aggregate in order
+func saveEventsByAggregate(ctx context.Context, eventStore EventStore, eventList []*events.Event) error {
+	for start := 0; start < len(eventList); {
+		end := start + 1
+		for end < len(eventList) && eventList[end].AggregateID == eventList[start].AggregateID {
+			end++
+		}
+
+		batch := eventList[start:end]
+		if err := eventStore.AppendToStream(ctx, batch[0].AggregateID, batch[0].Version-1, batch); err != nil {
+			return err
+		}
+
+		start = end
+	}
+
+	return nil
 }
 
 // EventStoreError represents an error from the event store
@@ -45,6 +138,16 @@ func (e *EventStoreError) Unwrap() error {
 	return e.Err
 }
 
+// Is reports whether target is an EventStoreError with the same code,
+// so errors.Is(err, ErrVersionConflict) matches any version conflict
+func (e *EventStoreError) Is(target error) bool {
+	t, ok := target.(*EventStoreError)
+	if !ok {
+		return false
+	}
+	return e.Code == t.Code
+}
+
 // NewEventStoreError creates a new event store error
 func NewEventStoreError(code, message string, err error) *EventStoreError {
 	return &EventStoreError{
@@ -58,18 +161,26 @@ func NewEventStoreError(code, message string, err error) *EventStoreError {
 const (
 	ErrCodeConnectionFailed = "CONNECTION_FAILED"
 	ErrCodeEventNotFound    = "EVENT_NOT_FOUND"
+	ErrCodeKeyNotFound      = "KEY_NOT_FOUND"
+	ErrCodeSnapshotNotFound = "SNAPSHOT_NOT_FOUND"
 	ErrCodeVersionConflict  = "VERSION_CONFLICT"
 	ErrCodeSerialization    = "SERIALIZATION_ERROR"
 	ErrCodeStorage          = "STORAGE_ERROR"
+	ErrCodeInvalidEvent     = "INVALID_EVENT"
+	ErrCodeNotSupported     = "NOT_SUPPORTED"
 )
 
 // Predefined errors
 var (
 	ErrConnectionFailed = NewEventStoreError(ErrCodeConnectionFailed, "failed to connect to event store", nil)
 	ErrEventNotFound    = NewEventStoreError(ErrCodeEventNotFound, "event not found", nil)
+	ErrKeyNotFound      = NewEventStoreError(ErrCodeKeyNotFound, "data key not found", nil)
+	ErrSnapshotNotFound = NewEventStoreError(ErrCodeSnapshotNotFound, "snapshot not found", nil)
 	ErrVersionConflict  = NewEventStoreError(ErrCodeVersionConflict, "version conflict", nil)
 	ErrSerialization    = NewEventStoreError(ErrCodeSerialization, "serialization error", nil)
 	ErrStorage          = NewEventStoreError(ErrCodeStorage, "storage error", nil)
+	ErrInvalidEvent     = NewEventStoreError(ErrCodeInvalidEvent, "invalid event", nil)
+	ErrNotSupported     = NewEventStoreError(ErrCodeNotSupported, "operation not supported by event store", nil)
 )
 
 // IsEventStoreError checks if an error is an EventStoreError
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kegazani/metachat-event-sourcing/events"
+)
+
+// newDiaryTestEvent creates a diary event at a version
+func newDiaryTestEvent(t *testing.T, aggregateID string, version int) *events.Event {
+	t.Helper()
+
+	var event *events.Event
+	var err error
+	if version == 1 {
+		event, err = events.NewEvent(events.DiaryEntryCreatedEvent, aggregateID, version, events.DiaryEntryCreatedPayload{
+			UserID:  "user-1",
+			Title:   "First entry",
+			Content: "Dear diary",
+		}, nil)
+	} else {
+		event, err = events.NewEvent(events.DiaryEntryUpdatedEvent, aggregateID, version, events.DiaryEntryUpdatedPayload{
+			Content: "Updated",
+		}, nil)
+	}
+	if err != nil {
+		t.Fatalf("failed to create event: %v", err)
+	}
+	return event
+}
+
+// newDiaryTestEvents creates count diary events following version
+func newDiaryTestEvents(t *testing.T, aggregateID string, version, count int) []*events.Event {
+	t.Helper()
+
+	eventList := make([]*events.Event, 0, count)
+	for v := version + 1; v <= version+count; v++ {
+		eventList = append(eventList, newDiaryTestEvent(t, aggregateID, v))
+	}
+	return eventList
+}
+
+// assertVersions checks that a stream holds each version from 1 to count exactly once
+func assertVersions(t *testing.T, eventStore EventStore, aggregateID string, count int) {
+	t.Helper()
+
+	stored, err := eventStore.GetEventsByAggregateID(context.Background(), aggregateID)
+	if err != nil {
+		t.Fatalf("failed to read stream: %v", err)
+	}
+
+	if len(stored) != count {
+		t.Fatalf("expected %d events, got %d", count, len(stored))
+	}
+	for i, event := range stored {
+		if event.Version != i+1 {
+			t.Fatalf("expected version %d at index %d, got %d", i+1, i, event.Version)
+		}
+	}
+}
+
+// assertReadAll checks the global positions read from a store after a position
+func assertReadAll(t *testing.T, eventStore EventStore, fromPosition uint64, positions ...uint64) {
+	t.Helper()
+
+	read, err := eventStore.ReadAll(context.Background(), fromPosition, 100)
+	if err != nil {
+		t.Fatalf("failed to read all events: %v", err)
+	}
+
+	if len(read) != len(positions) {
+		t.Fatalf("expected %d events after position %d, got %d", len(positions), fromPosition, len(read))
+	}
+	for i, event := range read {
+		if event.Position != positions[i] {
+			t.Fatalf("expected position %d at index %d, got %d", positions[i], i, event.Position)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	client "github.com/EventStore/EventStore-Client-Go/v3/esdb"
+	"github.com/gofrs/uuid"
+)
+
+const checkpointEventType = "Checkpoint"
+
+// checkpointStreamMaxCount is the number of checkpoints EventStoreDB keeps per consumer
+const checkpointStreamMaxCount = 1
+
+type EventStoreDBCheckpointStore struct {
+	client       *client.Client
+	streamPrefix string
+}
+
+// NewEventStoreDBCheckpointStore creates a checkpoint store sharing the connection of an event store
+func NewEventStoreDBCheckpointStore(eventStore *EventStoreDBEventStore) *EventStoreDBCheckpointStore {
+	return &EventStoreDBCheckpointStore{
+		client:       eventStore.client,
+		streamPrefix: eventStore.streamPrefix,
+	}
+}
+
+func (e *EventStoreDBCheckpointStore) getStreamName(name string) string {
+	return fmt.Sprintf("%s_checkpoint-%s", e.streamPrefix, name)
+}
+
+// checkpointData is the body of a checkpoint event
+type checkpointData struct {
+	Position uint64 `json:"position"`
+}
+
+func (e *EventStoreDBCheckpointStore) SaveCheckpoint(ctx context.Context, name string, position uint64) error {
+	streamName := e.getStreamName(name)
+
+	data, err := json.Marshal(checkpointData{Position: position})
+	if err != nil {
+		return NewEventStoreError(ErrCodeSerialization, "failed to marshal checkpoint", err)
+	}
+
+	eventID, err := uuid.NewV4()
+	if err != nil {
+		return NewEventStoreError(ErrCodeSerialization, "failed to generate checkpoint ID", err)
+	}
+
+	result, err := e.client.AppendToStream(ctx, streamName, client.AppendToStreamOptions{}, client.EventData{
+		EventID:     eventID,
+		ContentType: client.ContentTypeJson,
+		EventType:   checkpointEventType,
+		Data:        data,
+	})
+	if err != nil {
+		return NewEventStoreError(ErrCodeStorage, "failed to append checkpoint", err)
+	}
+
+	// Older checkpoints are never read again, let the server scavenge them
+	if result.NextExpectedVersion == 0 {
+		streamMetadata := client.StreamMetadata{}
+		streamMetadata.SetMaxCount(checkpointStreamMaxCount)
+		if _, err := e.client.SetStreamMetadata(ctx, streamName, client.AppendToStreamOptions{}, streamMetadata); err != nil {
+			return NewEventStoreError(ErrCodeStorage, "failed to set checkpoint stream metadata", err)
+		}
+	}
+
+	return nil
+}
+
+func (e *EventStoreDBCheckpointStore) GetCheckpoint(ctx context.Context, name string) (uint64, error) {
+	stream, err := e.client.ReadStream(ctx, e.getStreamName(name), client.ReadStreamOptions{
+		Direction: client.Backwards,
+		From:      client.End{},
+	}, 1)
+	if err != nil {
+		if isStreamNotFound(err) {
+			return 0, nil
+		}
+		return 0, NewEventStoreError(ErrCodeStorage, "failed to read checkpoint stream", err)
+	}
+	defer stream.Close()
+
+	resolved, err := stream.Recv()
+	if err != nil {
+		if errors.Is(err, io.EOF) || isStreamNotFound(err) {
+			return 0, nil
+		}
+		return 0, NewEventStoreError(ErrCodeStorage, "failed to read checkpoint", err)
+	}
+
+	if resolved.Event == nil {
+		return 0, nil
+	}
+
+	var checkpoint checkpointData
+	if err := json.Unmarshal(resolved.Event.Data, &checkpoint); err != nil {
+		return 0, NewEventStoreError(ErrCodeSerialization, "failed to unmarshal checkpoint", err)
+	}
+
+	return checkpoint.Position, nil
+}
@@ -3,21 +3,51 @@ package store
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	client "github.com/EventStore/EventStore-Client-Go/v3/esdb"
 	"github.com/gofrs/uuid"
+	"github.com/kegazani/metachat-event-sourcing/bus"
 	"github.com/kegazani/metachat-event-sourcing/events"
+	"github.com/kegazani/metachat-event-sourcing/serializer"
 )
 
+// timeRangeClockSkew is the tolerance between writer timestamps and server creation dates
+const timeRangeClockSkew = time.Minute
+
+// timeMarkerEventType is the type of the events of the time marker stream
+const timeMarkerEventType = "TimeMarker"
+
+// timeMarkerInterval is how often a store appending events writes a time marker
+const timeMarkerInterval = time.Minute
+
 type EventStoreDBEventStore struct {
-	client *client.Client
+	client       *client.Client
 	streamPrefix string
+	serializer   serializer.Serializer
+	serializers  *serializer.Registry
+
+	// lastTimeMarker is when this store last wrote a time marker, in Unix nanoseconds
+	lastTimeMarker atomic.Int64
 }
 
+// NewEventStoreDBEventStore creates an EventStoreDB event store writing events as JSON
 func NewEventStoreDBEventStore(connectionString string, streamPrefix string) (*EventStoreDBEventStore, error) {
+	return NewEventStoreDBEventStoreWithSerializer(connectionString, streamPrefix, nil)
+}
+
+// NewEventStoreDBEventStoreWithSerializer creates an EventStoreDB event store
+// writing events with eventSerializer, or JSON if it is nil. Events are read
+// back with the serializer of the content type recorded in their metadata.
+func NewEventStoreDBEventStoreWithSerializer(connectionString string, streamPrefix string, eventSerializer serializer.Serializer) (*EventStoreDBEventStore, error) {
 	config, err := client.ParseConnectionString(connectionString)
 	if err != nil {
 		return nil, NewEventStoreError(ErrCodeConnectionFailed, "failed to parse connection string", err)
@@ -32,9 +62,15 @@ func NewEventStoreDBEventStore(connectionString string, streamPrefix string) (*E
 		streamPrefix = "metachat"
 	}
 
+	if eventSerializer == nil {
+		eventSerializer = serializer.NewJSONSerializer()
+	}
+
 	return &EventStoreDBEventStore{
-		client: dbClient,
+		client:       dbClient,
 		streamPrefix: streamPrefix,
+		serializer:   eventSerializer,
+		serializers:  serializer.NewRegistry(serializer.NewJSONSerializer(), serializer.NewBinarySerializer(), eventSerializer),
 	}, nil
 }
 
@@ -53,95 +89,139 @@ func (e *EventStoreDBEventStore) getStreamName(aggregateID string) string {
 }
 
 func (e *EventStoreDBEventStore) SaveEvents(ctx context.Context, eventList []*events.Event) error {
+	return saveEventsByAggregate(ctx, e, eventList)
+}
+
+func (e *EventStoreDBEventStore) AppendToStream(ctx context.Context, aggregateID string, expectedVersion int, eventList []*events.Event) error {
 	if len(eventList) == 0 {
 		return nil
 	}
 
-	aggregateID := eventList[0].AggregateID
 	if aggregateID == "" {
 		return NewEventStoreError(ErrCodeSerialization, "aggregate ID cannot be empty", nil)
 	}
 
 	streamName := e.getStreamName(aggregateID)
 
+	// The server enforces exact expected versions itself. For "any" the current
+	// version is needed to number the events, and appending against it keeps
+	// two concurrent writers from producing the same version.
+	currentVersion := expectedVersion
+	if expectedVersion == ExpectedVersionAny {
+		var err error
+		currentVersion, err = e.currentVersion(ctx, streamName)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := prepareAppend(aggregateID, currentVersion, expectedVersion, eventList); err != nil {
+		return err
+	}
+
 	proposedEvents := make([]client.EventData, 0, len(eventList))
-	
+
 	for _, event := range eventList {
-		if event.AggregateID != aggregateID {
-			return NewEventStoreError(ErrCodeSerialization, "all events must have the same aggregate ID", nil)
+		eventData, err := e.convertToEventStoreEvent(event)
+		if err != nil {
+			return err
 		}
 
-			payloadBytes := event.Payload
+		proposedEvents = append(proposedEvents, eventData)
+	}
 
-		metadata := map[string]interface{}{
-			"type":        string(event.Type),
-			"aggregate_id": event.AggregateID,
-			"version":     event.Version,
-			"timestamp":   event.Timestamp.Format(time.RFC3339),
-		}
+	// Stream revisions are zero-based, so version N is stored at revision N-1
+	var expectedRevision client.ExpectedRevision = client.NoStream{}
+	if currentVersion > 0 {
+		expectedRevision = client.Revision(uint64(currentVersion - 1))
+	}
 
-		if event.Metadata != nil {
-			for k, v := range event.Metadata {
-				metadata[k] = v
-			}
-		}
+	opts := client.AppendToStreamOptions{
+		ExpectedRevision: expectedRevision,
+	}
 
-		metadataBytes, err := json.Marshal(metadata)
-		if err != nil {
-			return NewEventStoreError(ErrCodeSerialization, "failed to marshal metadata", err)
+	_, err := e.client.AppendToStream(ctx, streamName, opts, proposedEvents...)
+	if err != nil {
+		if esdbErr, ok := client.FromError(err); !ok && esdbErr.Code() == client.ErrorCodeWrongExpectedVersion {
+			return ErrVersionConflict
 		}
+		return NewEventStoreError(ErrCodeStorage, "failed to append events to stream", err)
+	}
 
-		eventUUID, err := uuid.FromString(event.ID)
-		if err != nil {
-			return NewEventStoreError(ErrCodeSerialization, "failed to parse event ID as UUID", err)
-		}
+	e.markTime(ctx)
 
-		eventData := client.EventData{
-			EventID:     eventUUID,
-			ContentType: client.ContentTypeJson,
-			EventType:   string(event.Type),
-			Data:        payloadBytes,
-			Metadata:    metadataBytes,
-		}
+	return nil
+}
 
-		proposedEvents = append(proposedEvents, eventData)
+// currentVersion returns the latest version of a stream, 0 if it has no events
+func (e *EventStoreDBEventStore) currentVersion(ctx context.Context, streamName string) (int, error) {
+	stream, err := e.client.ReadStream(ctx, streamName, client.ReadStreamOptions{
+		Direction: client.Backwards,
+		From:      client.End{},
+	}, 1)
+	if err != nil {
+		return 0, NewEventStoreError(ErrCodeStorage, "failed to read stream", err)
 	}
+	defer stream.Close()
 
-	existingEvents, err := e.GetEventsByAggregateID(ctx, aggregateID)
+	event, err := stream.Recv()
 	if err != nil {
-		return NewEventStoreError(ErrCodeStorage, "failed to get existing events", err)
+		if errors.Is(err, io.EOF) {
+			return 0, nil
+		}
+		if esdbErr, ok := client.FromError(err); !ok && esdbErr.Code() == client.ErrorCodeResourceNotFound {
+			return 0, nil
+		}
+		return 0, NewEventStoreError(ErrCodeStorage, "failed to read stream", err)
 	}
 
-	firstEventVersion := eventList[0].Version
+	return int(event.OriginalEvent().EventNumber) + 1, nil
+}
 
-	var expectedRevision client.ExpectedRevision
-	if len(existingEvents) == 0 {
-		if firstEventVersion != 1 {
-			return ErrVersionConflict
-		}
-		expectedRevision = client.NoStream{}
-	} else {
-		lastVersion := existingEvents[len(existingEvents)-1].Version
-		expectedLastVersion := firstEventVersion - len(eventList)
-		if lastVersion != expectedLastVersion {
-			return ErrVersionConflict
+func (e *EventStoreDBEventStore) convertToEventStoreEvent(event *events.Event) (client.EventData, error) {
+	metadata := map[string]interface{}{
+		"type":           string(event.Type),
+		"aggregate_id":   event.AggregateID,
+		"aggregate_type": string(event.AggregateType),
+		"version":        event.Version,
+		"timestamp":      event.Timestamp.Format(time.RFC3339),
+		"schema_version": event.SchemaVersion,
+		"content_type":   e.serializer.ContentType(),
+	}
+
+	if event.Metadata != nil {
+		for k, v := range event.Metadata {
+			metadata[k] = v
 		}
-		expectedRevision = client.Revision(uint64(lastVersion))
 	}
 
-	opts := client.AppendToStreamOptions{
-		ExpectedRevision: expectedRevision,
+	metadataBytes, err := json.Marshal(metadata)
+	if err != nil {
+		return client.EventData{}, NewEventStoreError(ErrCodeSerialization, "failed to marshal metadata", err)
 	}
 
-	_, err = e.client.AppendToStream(ctx, streamName, opts, proposedEvents...)
+	eventUUID, err := uuid.FromString(event.ID)
 	if err != nil {
-		if err.Error() == "wrong expected stream revision" {
-			return ErrVersionConflict
-		}
-		return NewEventStoreError(ErrCodeStorage, "failed to append events to stream", err)
+		return client.EventData{}, NewEventStoreError(ErrCodeSerialization, "failed to parse event ID as UUID", err)
 	}
 
-	return nil
+	data, err := e.serializer.Serialize(event)
+	if err != nil {
+		return client.EventData{}, NewEventStoreError(ErrCodeSerialization, "failed to serialize event", err)
+	}
+
+	contentType := client.ContentTypeBinary
+	if e.serializer.ContentType() == serializer.ContentTypeJSON {
+		contentType = client.ContentTypeJson
+	}
+
+	return client.EventData{
+		EventID:     eventUUID,
+		ContentType: contentType,
+		EventType:   string(event.Type),
+		Data:        data,
+		Metadata:    metadataBytes,
+	}, nil
 }
 
 func (e *EventStoreDBEventStore) GetEventsByAggregateID(ctx context.Context, aggregateID string) ([]*events.Event, error) {
@@ -186,12 +266,76 @@ func (e *EventStoreDBEventStore) GetEventsByAggregateID(ctx context.Context, agg
 }
 
 func (e *EventStoreDBEventStore) GetEventsByType(ctx context.Context, eventType events.EventType) ([]*events.Event, error) {
-	stream, err := e.client.ReadAll(ctx, client.ReadAllOptions{
+	iterator, err := e.IterateEventsByType(ctx, eventType, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return collectEvents(iterator)
+}
+
+// IterateEventsByType reads the $et-<type> stream maintained by the $by_event_type
+// system projection, so the cost is proportional to the number of matching events.
+// The projection must be enabled on the server.
+func (e *EventStoreDBEventStore) IterateEventsByType(ctx context.Context, eventType events.EventType, pageToken string) (EventIterator, error) {
+	token, err := decodePageToken(pageToken)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := e.client.ReadStream(ctx, "$et-"+string(eventType), client.ReadStreamOptions{
+		Direction:      client.Forwards,
+		From:           client.Revision(token.Offset),
+		ResolveLinkTos: true,
+	}, ^uint64(0))
+	if err != nil {
+		return nil, NewEventStoreError(ErrCodeStorage, "failed to read event type stream", err)
+	}
+
+	return &eventStoreDBEventIterator{
+		store:      e,
+		stream:     stream,
+		linkStream: true,
+		after:      token.Offset,
+	}, nil
+}
+
+func (e *EventStoreDBEventStore) GetEventsByAggregateType(ctx context.Context, aggregateType events.AggregateType) ([]*events.Event, error) {
+	result := make([]*events.Event, 0)
+	for _, eventType := range events.EventTypesOf(aggregateType) {
+		eventList, err := e.GetEventsByType(ctx, eventType)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, eventList...)
+	}
+
+	// Restore the global order across the per-type streams
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Position < result[j].Position
+	})
+
+	return result, nil
+}
+
+func (e *EventStoreDBEventStore) GetEventsByAggregateIDAndVersion(ctx context.Context, aggregateID string, version int) ([]*events.Event, error) {
+	if aggregateID == "" {
+		return nil, NewEventStoreError(ErrCodeSerialization, "aggregate ID cannot be empty", nil)
+	}
+
+	streamName := e.getStreamName(aggregateID)
+
+	readOpts := client.ReadStreamOptions{
 		Direction: client.Forwards,
 		From:      client.Start{},
-	}, ^uint64(0))
+	}
+	stream, err := e.client.ReadStream(ctx, streamName, readOpts, uint64(version+1))
 	if err != nil {
-		return nil, NewEventStoreError(ErrCodeStorage, "failed to read all events", err)
+		if err.Error() == "stream not found" {
+			return []*events.Event{}, nil
+		}
+		return nil, NewEventStoreError(ErrCodeStorage, "failed to read stream", err)
 	}
 	defer stream.Close()
 
@@ -202,7 +346,7 @@ func (e *EventStoreDBEventStore) GetEventsByType(ctx context.Context, eventType
 			break
 		}
 
-		if event.Event == nil || event.Event.EventType != string(eventType) {
+		if event.Event == nil {
 			continue
 		}
 
@@ -211,26 +355,30 @@ func (e *EventStoreDBEventStore) GetEventsByType(ctx context.Context, eventType
 			return nil, NewEventStoreError(ErrCodeSerialization, "failed to convert event", err)
 		}
 
-		result = append(result, domainEvent)
+		if domainEvent.Version <= version {
+			result = append(result, domainEvent)
+		}
 	}
 
 	return result, nil
 }
 
-func (e *EventStoreDBEventStore) GetEventsByAggregateIDAndVersion(ctx context.Context, aggregateID string, version int) ([]*events.Event, error) {
+func (e *EventStoreDBEventStore) GetEventsByAggregateIDAfterVersion(ctx context.Context, aggregateID string, version int) ([]*events.Event, error) {
 	if aggregateID == "" {
 		return nil, NewEventStoreError(ErrCodeSerialization, "aggregate ID cannot be empty", nil)
 	}
 
 	streamName := e.getStreamName(aggregateID)
 
+	// Stream revisions are zero-based, so version N is stored at revision N-1
+	// and the first event after version N is at revision N.
 	readOpts := client.ReadStreamOptions{
 		Direction: client.Forwards,
-		From:      client.Start{},
+		From:      client.Revision(uint64(version)),
 	}
-	stream, err := e.client.ReadStream(ctx, streamName, readOpts, uint64(version+1))
+	stream, err := e.client.ReadStream(ctx, streamName, readOpts, ^uint64(0))
 	if err != nil {
-		if err.Error() == "stream not found" {
+		if isStreamNotFound(err) {
 			return []*events.Event{}, nil
 		}
 		return nil, NewEventStoreError(ErrCodeStorage, "failed to read stream", err)
@@ -239,10 +387,18 @@ func (e *EventStoreDBEventStore) GetEventsByAggregateIDAndVersion(ctx context.Co
 
 	var result []*events.Event
 	for {
+		// A failed read must not pass for the end of the stream, or the
+		// aggregate would be rebuilt on top of its snapshot with events missing
 		event, err := stream.Recv()
-		if err != nil {
+		if errors.Is(err, io.EOF) {
 			break
 		}
+		if isStreamNotFound(err) {
+			return []*events.Event{}, nil
+		}
+		if err != nil {
+			return nil, NewEventStoreError(ErrCodeStorage, "failed to read stream", err)
+		}
 
 		if event.Event == nil {
 			continue
@@ -253,7 +409,7 @@ func (e *EventStoreDBEventStore) GetEventsByAggregateIDAndVersion(ctx context.Co
 			return nil, NewEventStoreError(ErrCodeSerialization, "failed to convert event", err)
 		}
 
-		if domainEvent.Version <= version {
+		if domainEvent.Version > version {
 			result = append(result, domainEvent)
 		}
 	}
@@ -262,6 +418,19 @@ func (e *EventStoreDBEventStore) GetEventsByAggregateIDAndVersion(ctx context.Co
 }
 
 func (e *EventStoreDBEventStore) GetEventsByTimeRange(ctx context.Context, startTime, endTime string) ([]*events.Event, error) {
+	iterator, err := e.IterateEventsByTimeRange(ctx, startTime, endTime, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return collectEvents(iterator)
+}
+
+// IterateEventsByTimeRange reads $all forwards from a position shortly before
+// the range, found in the time marker stream, and stops after the range, so
+// only events around the range are read. Ranges older than the first time
+// marker are read from the start of $all.
+func (e *EventStoreDBEventStore) IterateEventsByTimeRange(ctx context.Context, startTime, endTime string, pageToken string) (EventIterator, error) {
 	start, err := time.Parse(time.RFC3339, startTime)
 	if err != nil {
 		return nil, NewEventStoreError(ErrCodeSerialization, "invalid start time", err)
@@ -272,39 +441,335 @@ func (e *EventStoreDBEventStore) GetEventsByTimeRange(ctx context.Context, start
 		return nil, NewEventStoreError(ErrCodeSerialization, "invalid end time", err)
 	}
 
+	token, err := decodePageToken(pageToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// Event timestamps are set by the writer while $all is ordered by server
+	// time, the tolerance keeps events near the range boundaries in scope
+	from := token.Offset
+	if pageToken == "" {
+		from, err = e.positionBefore(ctx, start.Add(-timeRangeClockSkew))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	iterator, err := e.newAllIterator(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+
+	iterator.stop = func(event *client.RecordedEvent) bool {
+		return event.CreatedDate.After(end.Add(timeRangeClockSkew))
+	}
+	iterator.matchEvent = func(event *events.Event) bool {
+		return event.Timestamp.After(start) && event.Timestamp.Before(end)
+	}
+
+	return iterator, nil
+}
+
+// timeMarkerStream is the stream of time markers. Each marker is a sample of
+// $all: the events after it were created no earlier than the marker.
+func (e *EventStoreDBEventStore) timeMarkerStream() string {
+	return e.streamPrefix + "_time"
+}
+
+// markTime appends a time marker if this store wrote none for timeMarkerInterval.
+// Markers only speed up time range reads, so a failed append is retried with
+// the next events rather than reported.
+func (e *EventStoreDBEventStore) markTime(ctx context.Context) {
+	last := e.lastTimeMarker.Load()
+	now := time.Now().UnixNano()
+	if now-last < int64(timeMarkerInterval) || !e.lastTimeMarker.CompareAndSwap(last, now) {
+		return
+	}
+
+	eventID, err := uuid.NewV4()
+	if err != nil {
+		e.lastTimeMarker.Store(last)
+		return
+	}
+
+	_, err = e.client.AppendToStream(ctx, e.timeMarkerStream(), client.AppendToStreamOptions{}, client.EventData{
+		EventID:     eventID,
+		ContentType: client.ContentTypeJson,
+		EventType:   timeMarkerEventType,
+		Data:        []byte("{}"),
+	})
+	if err != nil {
+		e.lastTimeMarker.Store(last)
+	}
+}
+
+// positionBefore returns the commit position of the last time marker created
+// before a time, or 0 if there is none. No event created at or after the time
+// precedes it in $all. The marker stream is ordered by creation date and is
+// bisected, so the cost is logarithmic in the number of markers.
+func (e *EventStoreDBEventStore) positionBefore(ctx context.Context, before time.Time) (uint64, error) {
+	last, err := e.readTimeMarker(ctx, client.ReadStreamOptions{
+		Direction: client.Backwards,
+		From:      client.End{},
+	})
+	if err != nil || last == nil {
+		return 0, err
+	}
+
+	var position uint64
+	low, high := int64(0), int64(last.EventNumber)
+	for low <= high {
+		middle := low + (high-low)/2
+
+		marker, err := e.readTimeMarker(ctx, client.ReadStreamOptions{
+			Direction: client.Forwards,
+			From:      client.Revision(uint64(middle)),
+		})
+		if err != nil {
+			return 0, err
+		}
+		if marker == nil {
+			return position, nil
+		}
+
+		if marker.CreatedDate.Before(before) {
+			position = marker.Position.Commit
+			low = middle + 1
+		} else {
+			high = middle - 1
+		}
+	}
+
+	return position, nil
+}
+
+// readTimeMarker reads one time marker, nil if there is none
+func (e *EventStoreDBEventStore) readTimeMarker(ctx context.Context, options client.ReadStreamOptions) (*client.RecordedEvent, error) {
+	stream, err := e.client.ReadStream(ctx, e.timeMarkerStream(), options, 1)
+	if err != nil {
+		if isStreamNotFound(err) {
+			return nil, nil
+		}
+		return nil, NewEventStoreError(ErrCodeStorage, "failed to read time markers", err)
+	}
+	defer stream.Close()
+
+	resolved, err := stream.Recv()
+	if err != nil {
+		if errors.Is(err, io.EOF) || isStreamNotFound(err) {
+			return nil, nil
+		}
+		return nil, NewEventStoreError(ErrCodeStorage, "failed to read time marker", err)
+	}
+
+	return resolved.Event, nil
+}
+
+// newAllIterator creates an iterator over the events of this store's streams in $all
+// after a commit position
+func (e *EventStoreDBEventStore) newAllIterator(ctx context.Context, fromPosition uint64) (*eventStoreDBEventIterator, error) {
+	// On a single node the prepare position equals the commit position
+	var from client.AllPosition = client.Start{}
+	if fromPosition > 0 {
+		from = client.Position{Commit: fromPosition, Prepare: fromPosition}
+	}
+
 	stream, err := e.client.ReadAll(ctx, client.ReadAllOptions{
 		Direction: client.Forwards,
-		From:      client.Start{},
+		From:      from,
 	}, ^uint64(0))
 	if err != nil {
 		return nil, NewEventStoreError(ErrCodeStorage, "failed to read all events", err)
 	}
-	defer stream.Close()
 
-	var result []*events.Event
-	for {
-		event, err := stream.Recv()
+	return &eventStoreDBEventIterator{
+		store:  e,
+		stream: stream,
+		after:  fromPosition,
+	}, nil
+}
+
+// eventStoreDBEventIterator streams events from a read of $all, resuming by
+// commit position, or of a projection stream of links, resuming by revision
+type eventStoreDBEventIterator struct {
+	store      *EventStoreDBEventStore
+	stream     *client.ReadStream
+	linkStream bool
+	after      uint64
+	stop       func(*client.RecordedEvent) bool
+	matchEvent func(*events.Event) bool
+	current    *events.Event
+	done       bool
+	err        error
+}
+
+// Next advances to the next matching event
+func (it *eventStoreDBEventIterator) Next() bool {
+	for !it.done && it.err == nil {
+		resolved, err := it.stream.Recv()
 		if err != nil {
-			break
+			if !errors.Is(err, io.EOF) && !isStreamNotFound(err) {
+				it.err = NewEventStoreError(ErrCodeStorage, "failed to read events", err)
+			}
+			return false
 		}
 
-			if event.Event == nil {
-			continue
+		recorded := resolved.Event
+		if it.linkStream {
+			// Links to deleted events resolve to nothing
+			if resolved.Link != nil {
+				it.after = resolved.Link.EventNumber + 1
+			}
+			if recorded == nil || !it.store.isOwnStream(recorded.StreamID) {
+				continue
+			}
+		} else {
+			if recorded == nil || !it.store.isOwnStream(recorded.StreamID) || recorded.Position.Commit <= it.after {
+				continue
+			}
+			if it.stop != nil && it.stop(recorded) {
+				it.done = true
+				return false
+			}
+			it.after = recorded.Position.Commit
 		}
 
-		domainEvent, err := e.convertFromEventStoreEvent(event.Event)
+		event, err := it.store.convertFromEventStoreEvent(recorded)
 		if err != nil {
-			return nil, NewEventStoreError(ErrCodeSerialization, "failed to convert event", err)
+			it.err = NewEventStoreError(ErrCodeSerialization, "failed to convert event", err)
+			return false
 		}
 
-		if domainEvent.Timestamp.After(start) && domainEvent.Timestamp.Before(end) {
-			result = append(result, domainEvent)
+		if it.matchEvent != nil && !it.matchEvent(event) {
+			continue
 		}
+
+		it.current = event
+		return true
+	}
+
+	return false
+}
+
+// Event returns the current event
+func (it *eventStoreDBEventIterator) Event() *events.Event {
+	return it.current
+}
+
+// Err returns the error that stopped the iteration
+func (it *eventStoreDBEventIterator) Err() error {
+	return it.err
+}
+
+// PageToken returns a token resuming after the current event
+func (it *eventStoreDBEventIterator) PageToken() string {
+	return encodePageToken(nil, it.after)
+}
+
+// Close closes the underlying read
+func (it *eventStoreDBEventIterator) Close() error {
+	it.stream.Close()
+	return nil
+}
+
+// isStreamNotFound reports whether a read failed because the stream does not exist
+func isStreamNotFound(err error) bool {
+	esdbErr, ok := client.FromError(err)
+	return !ok && esdbErr.Code() == client.ErrorCodeResourceNotFound
+}
+
+// ReadAll retrieves events of this store's streams from $all after a commit position
+func (e *EventStoreDBEventStore) ReadAll(ctx context.Context, fromPosition uint64, limit int) ([]*events.Event, error) {
+	iterator, err := e.newAllIterator(ctx, fromPosition)
+	if err != nil {
+		return nil, err
+	}
+	defer iterator.Close()
+
+	result := make([]*events.Event, 0)
+	for (limit <= 0 || len(result) < limit) && iterator.Next() {
+		result = append(result, iterator.Event())
+	}
+
+	if err := iterator.Err(); err != nil {
+		return nil, err
 	}
 
 	return result, nil
 }
 
+// Subscribe follows $all from a commit position with a server-side filter on
+// event types, or on this store's stream prefix when the filter matches any type
+func (e *EventStoreDBEventStore) Subscribe(ctx context.Context, fromPosition uint64, filter SubscriptionFilter, handler bus.EventHandler) error {
+	var from client.AllPosition = client.Start{}
+	if fromPosition > 0 {
+		from = client.Position{Commit: fromPosition, Prepare: fromPosition}
+	}
+
+	serverFilter := &client.SubscriptionFilter{
+		Type:     client.StreamFilterType,
+		Prefixes: []string{e.streamPrefix + "-"},
+	}
+	if eventTypes := filter.eventTypes(); eventTypes != nil {
+		names := make([]string, 0, len(eventTypes))
+		for _, eventType := range eventTypes {
+			names = append(names, regexp.QuoteMeta(string(eventType)))
+		}
+		serverFilter = &client.SubscriptionFilter{
+			Type:  client.EventFilterType,
+			Regex: "^(" + strings.Join(names, "|") + ")$",
+		}
+	}
+
+	subscription, err := e.client.SubscribeToAll(ctx, client.SubscribeToAllOptions{
+		From:   from,
+		Filter: serverFilter,
+	})
+	if err != nil {
+		return NewEventStoreError(ErrCodeStorage, "failed to subscribe to all events", err)
+	}
+	defer subscription.Close()
+
+	for {
+		message := subscription.Recv()
+
+		if message.SubscriptionDropped != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return NewEventStoreError(ErrCodeStorage, "subscription dropped", message.SubscriptionDropped.Error)
+		}
+
+		if message.EventAppeared == nil {
+			continue
+		}
+
+		recorded := message.EventAppeared.Event
+		if recorded == nil || !e.isOwnStream(recorded.StreamID) || recorded.Position.Commit <= fromPosition {
+			continue
+		}
+
+		event, err := e.convertFromEventStoreEvent(recorded)
+		if err != nil {
+			return NewEventStoreError(ErrCodeSerialization, "failed to convert event", err)
+		}
+
+		if !filter.Matches(event) {
+			continue
+		}
+
+		if err := handler(ctx, event); err != nil {
+			return err
+		}
+	}
+}
+
+// isOwnStream reports whether a stream holds aggregate events written by this store
+func (e *EventStoreDBEventStore) isOwnStream(streamID string) bool {
+	return strings.HasPrefix(streamID, e.streamPrefix+"-")
+}
+
 func (e *EventStoreDBEventStore) convertFromEventStoreEvent(event *client.RecordedEvent) (*events.Event, error) {
 	var metadata map[string]interface{}
 	if len(event.UserMetadata) > 0 {
@@ -313,6 +778,16 @@ func (e *EventStoreDBEventStore) convertFromEventStoreEvent(event *client.Record
 		}
 	}
 
+	// Events stored before serializers hold the bare payload as data
+	if contentType, ok := metadata["content_type"].(string); ok {
+		decoded, err := e.serializers.Deserialize(contentType, event.Data)
+		if err != nil {
+			return nil, err
+		}
+		decoded.Position = event.Position.Commit
+		return decoded, nil
+	}
+
 	aggregateID, ok := metadata["aggregate_id"].(string)
 	if !ok {
 		return nil, fmt.Errorf("aggregate_id not found in metadata")
@@ -337,23 +812,41 @@ func (e *EventStoreDBEventStore) convertFromEventStoreEvent(event *client.Record
 
 	eventType := events.EventType(event.EventType)
 
+	aggregateType := events.AggregateTypeOf(eventType)
+	if value, ok := metadata["aggregate_type"].(string); ok && value != "" {
+		aggregateType = events.AggregateType(value)
+	}
+
+	schemaVersion, _ := metadata["schema_version"].(float64)
+
 	delete(metadata, "type")
 	delete(metadata, "aggregate_id")
+	delete(metadata, "aggregate_type")
 	delete(metadata, "version")
 	delete(metadata, "timestamp")
+	delete(metadata, "schema_version")
 
 	return &events.Event{
-		ID:          event.EventID.String(),
-		Type:        eventType,
-		AggregateID: aggregateID,
-		Version:     int(version),
-		Timestamp:   timestamp,
-		Payload:     event.Data,
-		Metadata:    metadata,
+		ID:            event.EventID.String(),
+		Type:          eventType,
+		AggregateID:   aggregateID,
+		AggregateType: aggregateType,
+		Version:       int(version),
+		Timestamp:     timestamp,
+		Payload:       event.Data,
+		Metadata:      metadata,
+		SchemaVersion: int(schemaVersion),
+		Position:      event.Position.Commit,
 	}, nil
 }
 
+// RedactStream always returns ErrNotSupported: EventStoreDB events are
+// immutable. Wrap the store in an EncryptingEventStore to erase personal data
+// by crypto-shredding instead.
+func (e *EventStoreDBEventStore) RedactStream(ctx context.Context, aggregateID string, fields map[events.EventType][]string) (int, error) {
+	return 0, NewEventStoreError(ErrCodeNotSupported, "EventStoreDB events cannot be redacted", nil)
+}
+
 func (e *EventStoreDBEventStore) Close() error {
 	return e.client.Close()
 }
-
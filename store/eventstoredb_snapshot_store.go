@@ -0,0 +1,136 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	client "github.com/EventStore/EventStore-Client-Go/v3/esdb"
+	"github.com/gofrs/uuid"
+)
+
+const snapshotEventType = "Snapshot"
+
+// snapshotStreamMaxCount is the number of snapshots EventStoreDB keeps per aggregate
+const snapshotStreamMaxCount = 1
+
+type EventStoreDBSnapshotStore struct {
+	client       *client.Client
+	streamPrefix string
+}
+
+// NewEventStoreDBSnapshotStore creates a snapshot store sharing the connection of an event store
+func NewEventStoreDBSnapshotStore(eventStore *EventStoreDBEventStore) *EventStoreDBSnapshotStore {
+	return &EventStoreDBSnapshotStore{
+		client:       eventStore.client,
+		streamPrefix: eventStore.streamPrefix,
+	}
+}
+
+func (e *EventStoreDBSnapshotStore) getStreamName(aggregateID string) string {
+	return fmt.Sprintf("%s_snapshot-%s", e.streamPrefix, aggregateID)
+}
+
+func (e *EventStoreDBSnapshotStore) SaveSnapshot(ctx context.Context, snapshot *Snapshot) error {
+	streamName := e.getStreamName(snapshot.AggregateID)
+
+	metadataBytes, err := json.Marshal(map[string]interface{}{
+		"aggregate_id": snapshot.AggregateID,
+		"version":      snapshot.Version,
+		"timestamp":    snapshot.Timestamp.Format(time.RFC3339),
+	})
+	if err != nil {
+		return NewEventStoreError(ErrCodeSerialization, "failed to marshal snapshot metadata", err)
+	}
+
+	eventID, err := uuid.NewV4()
+	if err != nil {
+		return NewEventStoreError(ErrCodeSerialization, "failed to generate snapshot ID", err)
+	}
+
+	result, err := e.client.AppendToStream(ctx, streamName, client.AppendToStreamOptions{}, client.EventData{
+		EventID:     eventID,
+		ContentType: client.ContentTypeJson,
+		EventType:   snapshotEventType,
+		Data:        snapshot.State,
+		Metadata:    metadataBytes,
+	})
+	if err != nil {
+		return NewEventStoreError(ErrCodeStorage, "failed to append snapshot", err)
+	}
+
+	// Older snapshots are never read again, let the server scavenge them
+	if result.NextExpectedVersion == 0 {
+		streamMetadata := client.StreamMetadata{}
+		streamMetadata.SetMaxCount(snapshotStreamMaxCount)
+		if _, err := e.client.SetStreamMetadata(ctx, streamName, client.AppendToStreamOptions{}, streamMetadata); err != nil {
+			return NewEventStoreError(ErrCodeStorage, "failed to set snapshot stream metadata", err)
+		}
+	}
+
+	return nil
+}
+
+func (e *EventStoreDBSnapshotStore) GetLatestSnapshot(ctx context.Context, aggregateID string) (*Snapshot, error) {
+	streamName := e.getStreamName(aggregateID)
+
+	stream, err := e.client.ReadStream(ctx, streamName, client.ReadStreamOptions{
+		Direction: client.Backwards,
+		From:      client.End{},
+	}, 1)
+	if err != nil {
+		return nil, NewEventStoreError(ErrCodeStorage, "failed to read snapshot stream", err)
+	}
+	defer stream.Close()
+
+	resolved, err := stream.Recv()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, ErrSnapshotNotFound
+		}
+		if esdbErr, ok := client.FromError(err); !ok && esdbErr.Code() == client.ErrorCodeResourceNotFound {
+			return nil, ErrSnapshotNotFound
+		}
+		return nil, NewEventStoreError(ErrCodeStorage, "failed to read snapshot", err)
+	}
+
+	if resolved.Event == nil {
+		return nil, ErrSnapshotNotFound
+	}
+
+	var metadata struct {
+		Version   int    `json:"version"`
+		Timestamp string `json:"timestamp"`
+	}
+	if err := json.Unmarshal(resolved.Event.UserMetadata, &metadata); err != nil {
+		return nil, NewEventStoreError(ErrCodeSerialization, "failed to unmarshal snapshot metadata", err)
+	}
+
+	timestamp, err := time.Parse(time.RFC3339, metadata.Timestamp)
+	if err != nil {
+		timestamp = resolved.Event.CreatedDate
+	}
+
+	return &Snapshot{
+		AggregateID: aggregateID,
+		Version:     metadata.Version,
+		State:       resolved.Event.Data,
+		Timestamp:   timestamp,
+	}, nil
+}
+
+// DeleteSnapshots deletes the snapshot stream of an aggregate. The stream is
+// soft deleted, so a later snapshot recreates it.
+func (e *EventStoreDBSnapshotStore) DeleteSnapshots(ctx context.Context, aggregateID string) error {
+	_, err := e.client.DeleteStream(ctx, e.getStreamName(aggregateID), client.DeleteStreamOptions{
+		ExpectedRevision: client.Any{},
+	})
+	if err != nil && !isStreamNotFound(err) {
+		return NewEventStoreError(ErrCodeStorage, "failed to delete snapshot stream", err)
+	}
+
+	return nil
+}
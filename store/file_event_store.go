@@ -0,0 +1,803 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kegazani/metachat-event-sourcing/bus"
+	"github.com/kegazani/metachat-event-sourcing/events"
+)
+
+// FsyncPolicy controls when the file event store flushes writes to disk
+type FsyncPolicy int
+
+const (
+	// FsyncAlways syncs after every append, so no acknowledged event is lost on a crash
+	FsyncAlways FsyncPolicy = iota
+
+	// FsyncInterval syncs periodically, a crash loses at most one interval of appends
+	FsyncInterval
+
+	// FsyncNever leaves flushing to the operating system
+	FsyncNever
+)
+
+const (
+	defaultSegmentSize   = 64 << 20
+	defaultFsyncInterval = time.Second
+	indexFileName        = "index.idx"
+)
+
+// FileEventStoreOptions configures a FileEventStore
+type FileEventStoreOptions struct {
+	// SegmentSize is the size in bytes after which a new log segment is started
+	SegmentSize int64
+
+	// Fsync is the fsync policy
+	Fsync FsyncPolicy
+
+	// FsyncInterval is the sync period used by FsyncInterval
+	FsyncInterval time.Duration
+}
+
+// recordRef locates an event in the log: the record of its append and its index within it
+type recordRef struct {
+	segment       int
+	offset        int64
+	item          int
+	version       int
+	eventType     events.EventType
+	aggregateType events.AggregateType
+}
+
+// indexEntry is the on-disk index entry of an event
+type indexEntry struct {
+	AggregateID   string               `json:"a"`
+	Version       int                  `json:"v"`
+	Position      uint64               `json:"p"`
+	Segment       uint64               `json:"s"`
+	Offset        int64                `json:"o"`
+	Item          int                  `json:"i"`
+	EventType     events.EventType     `json:"t"`
+	AggregateType events.AggregateType `json:"g,omitempty"`
+}
+
+// FileEventStore is a durable EventStore backed by append-only files.
+// Each append is written as one checksummed record to the active log segment,
+// so it is atomic, and described in an index file used to open the store
+// without replaying the log. Torn writes left by a crash are truncated on open.
+type FileEventStore struct {
+	mu       sync.RWMutex
+	dir      string
+	options  FileEventStoreOptions
+	segments []*segment
+	index    *os.File
+	refs     []recordRef         // position-1 -> location
+	streams  map[string][]uint64 // aggregateID -> positions ordered by version
+	notify   chan struct{}       // closed and replaced whenever events are appended
+	dirty    bool
+	closed   bool
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+// NewFileEventStore opens or creates a file event store in a directory
+func NewFileEventStore(dir string, options FileEventStoreOptions) (*FileEventStore, error) {
+	if options.SegmentSize <= 0 {
+		options.SegmentSize = defaultSegmentSize
+	}
+	if options.FsyncInterval <= 0 {
+		options.FsyncInterval = defaultFsyncInterval
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, NewEventStoreError(ErrCodeConnectionFailed, "failed to create event store directory", err)
+	}
+
+	segments, err := openSegments(dir)
+	if err != nil {
+		return nil, NewEventStoreError(ErrCodeConnectionFailed, "failed to open log segments", err)
+	}
+	if len(segments) == 0 {
+		first, err := openSegment(dir, 1)
+		if err != nil {
+			return nil, NewEventStoreError(ErrCodeConnectionFailed, "failed to create log segment", err)
+		}
+		segments = append(segments, first)
+	}
+
+	index, err := os.OpenFile(filepath.Join(dir, indexFileName), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		closeSegments(segments)
+		return nil, NewEventStoreError(ErrCodeConnectionFailed, "failed to open index", err)
+	}
+
+	if options.Fsync == FsyncAlways {
+		if err := syncDir(dir); err != nil {
+			index.Close()
+			closeSegments(segments)
+			return nil, NewEventStoreError(ErrCodeConnectionFailed, "failed to sync event store directory", err)
+		}
+	}
+
+	f := &FileEventStore{
+		dir:      dir,
+		options:  options,
+		segments: segments,
+		index:    index,
+		streams:  make(map[string][]uint64),
+		notify:   make(chan struct{}),
+	}
+
+	if err := f.recover(); err != nil {
+		index.Close()
+		closeSegments(segments)
+		return nil, NewEventStoreError(ErrCodeStorage, "failed to recover event store", err)
+	}
+
+	if options.Fsync == FsyncInterval {
+		f.stop = make(chan struct{})
+		f.stopped = make(chan struct{})
+		go f.syncPeriodically()
+	}
+
+	return f, nil
+}
+
+// recover loads the index, then scans the log past the last indexed event to
+// index events the crash left out and truncate a torn record at the tail
+func (f *FileEventStore) recover() error {
+	entries, validSize, err := readIndex(f.index)
+	if err != nil {
+		return err
+	}
+	if err := f.index.Truncate(validSize); err != nil {
+		return err
+	}
+
+	if !f.applyIndex(entries) {
+		// The index does not match the log, rebuild it from scratch
+		f.refs = nil
+		f.streams = make(map[string][]uint64)
+		if err := f.index.Truncate(0); err != nil {
+			return err
+		}
+	}
+
+	segmentIndex, offset := 0, int64(0)
+	if len(f.refs) > 0 {
+		last := f.refs[len(f.refs)-1]
+		_, next, err := readRecord(f.segments[last.segment].file, f.segments[last.segment].size, last.offset)
+		if err != nil {
+			return err
+		}
+		segmentIndex, offset = last.segment, next
+	}
+
+	var missing []indexEntry
+	for ; segmentIndex < len(f.segments); segmentIndex, offset = segmentIndex+1, 0 {
+		seg := f.segments[segmentIndex]
+		for {
+			data, next, err := readRecord(seg.file, seg.size, offset)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if errors.Is(err, errTornRecord) {
+				if segmentIndex != len(f.segments)-1 {
+					return fmt.Errorf("corrupt record in segment %s at offset %d", seg.path, offset)
+				}
+				if err := seg.file.Truncate(offset); err != nil {
+					return err
+				}
+				seg.size = offset
+				break
+			}
+			if err != nil {
+				return err
+			}
+
+			var batch []*events.Event
+			if err := json.Unmarshal(data, &batch); err != nil {
+				return fmt.Errorf("invalid record in segment %s at offset %d: %w", seg.path, offset, err)
+			}
+
+			for item, event := range batch {
+				entry := indexEntry{
+					AggregateID:   event.AggregateID,
+					Version:       event.Version,
+					Position:      uint64(len(f.refs)) + 1,
+					Segment:       seg.base,
+					Offset:        offset,
+					Item:          item,
+					EventType:     event.Type,
+					AggregateType: event.AggregateType,
+				}
+				if event.Position != entry.Position {
+					return fmt.Errorf("unexpected position %d in segment %s, expected %d", event.Position, seg.path, entry.Position)
+				}
+
+				f.addRef(segmentIndex, entry)
+				missing = append(missing, entry)
+			}
+
+			offset = next
+		}
+	}
+
+	if len(missing) > 0 {
+		if err := f.writeIndex(missing); err != nil {
+			return err
+		}
+	}
+
+	return f.syncAll()
+}
+
+// applyIndex loads index entries, returning false if they do not match the log
+func (f *FileEventStore) applyIndex(entries []indexEntry) bool {
+	segmentsByBase := make(map[uint64]int, len(f.segments))
+	for i, seg := range f.segments {
+		segmentsByBase[seg.base] = i
+	}
+
+	for _, entry := range entries {
+		segmentIndex, ok := segmentsByBase[entry.Segment]
+		if !ok || entry.Position != uint64(len(f.refs))+1 {
+			return false
+		}
+		f.addRef(segmentIndex, entry)
+	}
+
+	if len(f.refs) == 0 {
+		return true
+	}
+
+	// The last indexed event must still be readable, the log may have lost its tail
+	last := f.refs[len(f.refs)-1]
+	data, _, err := readRecord(f.segments[last.segment].file, f.segments[last.segment].size, last.offset)
+	if err != nil {
+		return false
+	}
+
+	var batch []*events.Event
+	if err := json.Unmarshal(data, &batch); err != nil || last.item >= len(batch) {
+		return false
+	}
+
+	return batch[last.item].Position == uint64(len(f.refs))
+}
+
+// addRef records the location of the next event
+func (f *FileEventStore) addRef(segmentIndex int, entry indexEntry) {
+	f.refs = append(f.refs, recordRef{
+		segment:       segmentIndex,
+		offset:        entry.Offset,
+		item:          entry.Item,
+		version:       entry.Version,
+		eventType:     entry.EventType,
+		aggregateType: entry.AggregateType,
+	})
+	f.streams[entry.AggregateID] = append(f.streams[entry.AggregateID], entry.Position)
+}
+
+// readIndex reads the valid index entries and returns the size of the valid prefix of the file
+func readIndex(index *os.File) ([]indexEntry, int64, error) {
+	info, err := index.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var entries []indexEntry
+	var offset int64
+
+	for {
+		data, next, err := readRecord(index, info.Size(), offset)
+		if errors.Is(err, io.EOF) || errors.Is(err, errTornRecord) {
+			return entries, offset, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		var batch []indexEntry
+		if err := json.Unmarshal(data, &batch); err != nil {
+			return entries, offset, nil
+		}
+
+		entries = append(entries, batch...)
+		offset = next
+	}
+}
+
+// writeIndex appends index entries as one record
+func (f *FileEventStore) writeIndex(entries []indexEntry) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.index.Write(encodeRecord(data))
+	return err
+}
+
+// SaveEvents saves a batch of events to the store
+func (f *FileEventStore) SaveEvents(ctx context.Context, eventList []*events.Event) error {
+	return saveEventsByAggregate(ctx, f, eventList)
+}
+
+// AppendToStream appends events to an aggregate stream at the expected version
+func (f *FileEventStore) AppendToStream(ctx context.Context, aggregateID string, expectedVersion int, eventList []*events.Event) error {
+	if len(eventList) == 0 {
+		return nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return NewEventStoreError(ErrCodeStorage, "event store is closed", nil)
+	}
+
+	if err := prepareAppend(aggregateID, f.currentVersion(aggregateID), expectedVersion, eventList); err != nil {
+		return err
+	}
+
+	// The caller's events only get their positions once the write succeeded
+	first := uint64(len(f.refs)) + 1
+	stored := make([]*events.Event, len(eventList))
+	for i, event := range eventList {
+		positioned := *event
+		positioned.Position = first + uint64(i)
+		stored[i] = &positioned
+	}
+
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return NewEventStoreError(ErrCodeSerialization, "failed to marshal events", err)
+	}
+	record := encodeRecord(data)
+
+	seg, segmentIndex, err := f.activeSegment(int64(len(record)), first)
+	if err != nil {
+		return NewEventStoreError(ErrCodeStorage, "failed to rotate log segment", err)
+	}
+
+	offset := seg.size
+	entries := make([]indexEntry, 0, len(stored))
+	for item, event := range stored {
+		entries = append(entries, indexEntry{
+			AggregateID:   aggregateID,
+			Version:       event.Version,
+			Position:      event.Position,
+			Segment:       seg.base,
+			Offset:        offset,
+			Item:          item,
+			EventType:     event.Type,
+			AggregateType: event.AggregateType,
+		})
+	}
+
+	if err := f.write(seg, offset, record, entries); err != nil {
+		return NewEventStoreError(ErrCodeStorage, "failed to save events", err)
+	}
+
+	for i, entry := range entries {
+		f.addRef(segmentIndex, entry)
+		eventList[i].Position = entry.Position
+	}
+
+	// Wake up subscribers waiting for new events
+	close(f.notify)
+	f.notify = make(chan struct{})
+
+	return nil
+}
+
+// write writes a record and its index entries, rolling the log back on failure
+func (f *FileEventStore) write(seg *segment, offset int64, record []byte, entries []indexEntry) error {
+	rollback := func(err error) error {
+		seg.file.Truncate(offset)
+		return err
+	}
+
+	if _, err := seg.file.WriteAt(record, offset); err != nil {
+		return rollback(err)
+	}
+
+	if err := f.writeIndex(entries); err != nil {
+		return rollback(err)
+	}
+
+	switch f.options.Fsync {
+	case FsyncAlways:
+		if err := seg.file.Sync(); err != nil {
+			return rollback(err)
+		}
+		if err := f.index.Sync(); err != nil {
+			return rollback(err)
+		}
+	case FsyncInterval:
+		f.dirty = true
+	}
+
+	seg.size = offset + int64(len(record))
+	return nil
+}
+
+// activeSegment returns the segment to append a record to, starting a new one when it is full
+func (f *FileEventStore) activeSegment(recordSize int64, nextPosition uint64) (*segment, int, error) {
+	last := f.segments[len(f.segments)-1]
+	if last.size == 0 || last.size+recordSize <= f.options.SegmentSize {
+		return last, len(f.segments) - 1, nil
+	}
+
+	if err := last.file.Sync(); err != nil {
+		return nil, 0, err
+	}
+
+	seg, err := openSegment(f.dir, nextPosition)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// The new segment's directory entry must survive a crash like its records
+	if f.options.Fsync == FsyncAlways {
+		if err := syncDir(f.dir); err != nil {
+			seg.file.Close()
+			return nil, 0, err
+		}
+	}
+
+	f.segments = append(f.segments, seg)
+	return seg, len(f.segments) - 1, nil
+}
+
+// currentVersion returns the latest version of an aggregate stream, the caller must hold the lock
+func (f *FileEventStore) currentVersion(aggregateID string) int {
+	positions := f.streams[aggregateID]
+	if len(positions) == 0 {
+		return 0
+	}
+
+	return f.refs[positions[len(positions)-1]-1].version
+}
+
+// syncAll flushes the active segment and the index to disk
+func (f *FileEventStore) syncAll() error {
+	if err := f.segments[len(f.segments)-1].file.Sync(); err != nil {
+		return err
+	}
+	return f.index.Sync()
+}
+
+// syncPeriodically flushes pending writes for FsyncInterval until the store is closed
+func (f *FileEventStore) syncPeriodically() {
+	defer close(f.stopped)
+
+	ticker := time.NewTicker(f.options.FsyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+			f.mu.Lock()
+			if f.dirty && f.syncAll() == nil {
+				f.dirty = false
+			}
+			f.mu.Unlock()
+		}
+	}
+}
+
+// batchCache keeps the last decoded record so events of the same append are decoded once
+type batchCache struct {
+	segment int
+	offset  int64
+	batch   []*events.Event
+}
+
+// eventAt reads the event at a position, the caller must hold the lock
+func (f *FileEventStore) eventAt(position uint64, cache *batchCache) (*events.Event, error) {
+	ref := f.refs[position-1]
+
+	if cache.batch == nil || cache.segment != ref.segment || cache.offset != ref.offset {
+		data, _, err := readRecord(f.segments[ref.segment].file, f.segments[ref.segment].size, ref.offset)
+		if err != nil {
+			return nil, NewEventStoreError(ErrCodeStorage, "failed to read record", err)
+		}
+
+		var batch []*events.Event
+		if err := json.Unmarshal(data, &batch); err != nil {
+			return nil, NewEventStoreError(ErrCodeSerialization, "failed to unmarshal record", err)
+		}
+
+		cache.segment, cache.offset, cache.batch = ref.segment, ref.offset, batch
+	}
+
+	if ref.item >= len(cache.batch) {
+		return nil, NewEventStoreError(ErrCodeStorage, "record does not hold the indexed event", nil)
+	}
+
+	return cache.batch[ref.item], nil
+}
+
+// readStream reads the events of an aggregate whose versions match a predicate
+func (f *FileEventStore) readStream(aggregateID string, match func(version int) bool) ([]*events.Event, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	result := make([]*events.Event, 0)
+	cache := &batchCache{}
+	for _, position := range f.streams[aggregateID] {
+		if !match(f.refs[position-1].version) {
+			continue
+		}
+
+		event, err := f.eventAt(position, cache)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, event)
+	}
+
+	// Sort events by version
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Version < result[j].Version
+	})
+
+	return result, nil
+}
+
+// GetEventsByAggregateID retrieves all events for a specific aggregate
+func (f *FileEventStore) GetEventsByAggregateID(ctx context.Context, aggregateID string) ([]*events.Event, error) {
+	return f.readStream(aggregateID, func(int) bool { return true })
+}
+
+// GetEventsByAggregateIDAndVersion retrieves events for an aggregate up to a specific version
+func (f *FileEventStore) GetEventsByAggregateIDAndVersion(ctx context.Context, aggregateID string, version int) ([]*events.Event, error) {
+	return f.readStream(aggregateID, func(v int) bool { return v <= version })
+}
+
+// GetEventsByAggregateIDAfterVersion retrieves events for an aggregate after a specific version
+func (f *FileEventStore) GetEventsByAggregateIDAfterVersion(ctx context.Context, aggregateID string, version int) ([]*events.Event, error) {
+	return f.readStream(aggregateID, func(v int) bool { return v > version })
+}
+
+// GetEventsByType retrieves all events of a specific type
+func (f *FileEventStore) GetEventsByType(ctx context.Context, eventType events.EventType) ([]*events.Event, error) {
+	iterator, err := f.IterateEventsByType(ctx, eventType, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return collectEvents(iterator)
+}
+
+// GetEventsByAggregateType retrieves all events of aggregates of a specific type
+func (f *FileEventStore) GetEventsByAggregateType(ctx context.Context, aggregateType events.AggregateType) ([]*events.Event, error) {
+	iterator, err := f.newIterator("", func(ref recordRef) bool {
+		return ref.aggregateType == aggregateType
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return collectEvents(iterator)
+}
+
+// GetEventsByTimeRange retrieves events within a time range
+func (f *FileEventStore) GetEventsByTimeRange(ctx context.Context, startTime, endTime string) ([]*events.Event, error) {
+	iterator, err := f.IterateEventsByTimeRange(ctx, startTime, endTime, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return collectEvents(iterator)
+}
+
+// IterateEventsByType streams all events of a specific type using the in-memory index
+func (f *FileEventStore) IterateEventsByType(ctx context.Context, eventType events.EventType, pageToken string) (EventIterator, error) {
+	return f.newIterator(pageToken, func(ref recordRef) bool {
+		return ref.eventType == eventType
+	}, nil)
+}
+
+// IterateEventsByTimeRange streams events within a time range
+func (f *FileEventStore) IterateEventsByTimeRange(ctx context.Context, startTime, endTime string, pageToken string) (EventIterator, error) {
+	start, err := time.Parse(time.RFC3339, startTime)
+	if err != nil {
+		return nil, NewEventStoreError(ErrCodeSerialization, "invalid start time", err)
+	}
+
+	end, err := time.Parse(time.RFC3339, endTime)
+	if err != nil {
+		return nil, NewEventStoreError(ErrCodeSerialization, "invalid end time", err)
+	}
+
+	return f.newIterator(pageToken, nil, func(event *events.Event) bool {
+		return event.Timestamp.After(start) && event.Timestamp.Before(end)
+	})
+}
+
+// ReadAll retrieves events in global order after a position
+func (f *FileEventStore) ReadAll(ctx context.Context, fromPosition uint64, limit int) ([]*events.Event, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	result := make([]*events.Event, 0)
+	cache := &batchCache{}
+	for position := fromPosition + 1; position <= uint64(len(f.refs)); position++ {
+		if limit > 0 && len(result) >= limit {
+			break
+		}
+
+		event, err := f.eventAt(position, cache)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, event)
+	}
+
+	return result, nil
+}
+
+// Subscribe delivers matching events after a position, then waits for new ones
+func (f *FileEventStore) Subscribe(ctx context.Context, fromPosition uint64, filter SubscriptionFilter, handler bus.EventHandler) error {
+	position := fromPosition
+	for {
+		// Take the notification channel before reading so an append in between is not missed
+		f.mu.RLock()
+		notify := f.notify
+		f.mu.RUnlock()
+
+		batch, err := f.ReadAll(ctx, position, subscriptionBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, event := range batch {
+			if filter.Matches(event) {
+				if err := handler(ctx, event); err != nil {
+					return err
+				}
+			}
+			position = event.Position
+		}
+
+		if len(batch) > 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-notify:
+		}
+	}
+}
+
+// RedactStream always returns ErrNotSupported: records are never rewritten in
+// the append-only log. Wrap the store in an EncryptingEventStore to erase
+// personal data by crypto-shredding instead.
+func (f *FileEventStore) RedactStream(ctx context.Context, aggregateID string, fields map[events.EventType][]string) (int, error) {
+	return 0, NewEventStoreError(ErrCodeNotSupported, "file event store records cannot be redacted", nil)
+}
+
+// Close flushes pending writes and closes the files
+func (f *FileEventStore) Close() error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return nil
+	}
+	f.closed = true
+	f.mu.Unlock()
+
+	// The sync goroutine takes the lock, it is stopped without holding it
+	if f.stop != nil {
+		close(f.stop)
+		<-f.stopped
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	syncErr := f.syncAll()
+	indexErr := f.index.Close()
+	segmentsErr := closeSegments(f.segments)
+
+	return errors.Join(syncErr, indexErr, segmentsErr)
+}
+
+// newIterator creates an iterator over the events matching an index and an event predicate, either may be nil
+func (f *FileEventStore) newIterator(pageToken string, matchRef func(recordRef) bool, matchEvent func(*events.Event) bool) (EventIterator, error) {
+	token, err := decodePageToken(pageToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileEventIterator{
+		store:      f,
+		matchRef:   matchRef,
+		matchEvent: matchEvent,
+		next:       token.Offset + 1,
+		cache:      &batchCache{},
+	}, nil
+}
+
+// fileEventIterator scans the log lazily by position, taking the read lock for each step
+type fileEventIterator struct {
+	store      *FileEventStore
+	matchRef   func(recordRef) bool
+	matchEvent func(*events.Event) bool
+	next       uint64 // position of the next event to examine
+	cache      *batchCache
+	current    *events.Event
+	err        error
+}
+
+// Next advances to the next matching event
+func (it *fileEventIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	it.store.mu.RLock()
+	defer it.store.mu.RUnlock()
+
+	for it.next <= uint64(len(it.store.refs)) {
+		position := it.next
+		it.next++
+
+		if it.matchRef != nil && !it.matchRef(it.store.refs[position-1]) {
+			continue
+		}
+
+		event, err := it.store.eventAt(position, it.cache)
+		if err != nil {
+			it.err = err
+			return false
+		}
+
+		if it.matchEvent != nil && !it.matchEvent(event) {
+			continue
+		}
+
+		it.current = event
+		return true
+	}
+
+	it.current = nil
+	return false
+}
+
+// Event returns the current event
+func (it *fileEventIterator) Event() *events.Event {
+	return it.current
+}
+
+// Err returns the error that stopped the iteration
+func (it *fileEventIterator) Err() error {
+	return it.err
+}
+
+// PageToken returns a token resuming after the current event
+func (it *fileEventIterator) PageToken() string {
+	return encodePageToken(nil, it.next-1)
+}
+
+// Close releases the decoded record cache
+func (it *fileEventIterator) Close() error {
+	it.cache = nil
+	return nil
+}
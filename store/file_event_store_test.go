@@ -0,0 +1,147 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/kegazani/metachat-event-sourcing/events"
+)
+
+// newFileTestStore opens a file event store in dir, closing it when the test ends
+func newFileTestStore(t *testing.T, dir string, options FileEventStoreOptions) *FileEventStore {
+	t.Helper()
+
+	eventStore, err := NewFileEventStore(dir, options)
+	if err != nil {
+		t.Fatalf("failed to open file event store: %v", err)
+	}
+	t.Cleanup(func() { eventStore.Close() })
+	return eventStore
+}
+
+func TestFileEventStoreAppendAndRead(t *testing.T) {
+	eventStore := newFileTestStore(t, t.TempDir(), FileEventStoreOptions{})
+	ctx := context.Background()
+
+	if err := eventStore.AppendToStream(ctx, "diary-1", ExpectedVersionNoStream, newDiaryTestEvents(t, "diary-1", 0, 2)); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	if err := eventStore.AppendToStream(ctx, "diary-2", ExpectedVersionNoStream, newDiaryTestEvents(t, "diary-2", 0, 1)); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	if err := eventStore.AppendToStream(ctx, "diary-1", 2, newDiaryTestEvents(t, "diary-1", 2, 1)); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+
+	err := eventStore.AppendToStream(ctx, "diary-1", 2, newDiaryTestEvents(t, "diary-1", 2, 1))
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("expected ErrVersionConflict, got %v", err)
+	}
+
+	assertVersions(t, eventStore, "diary-1", 3)
+	assertVersions(t, eventStore, "diary-2", 1)
+	assertReadAll(t, eventStore, 0, 1, 2, 3, 4)
+	assertReadAll(t, eventStore, 2, 3, 4)
+
+	after, err := eventStore.GetEventsByAggregateIDAfterVersion(ctx, "diary-1", 1)
+	if err != nil || len(after) != 2 || after[0].Version != 2 {
+		t.Fatalf("expected versions 2 and 3, got %d events (%v)", len(after), err)
+	}
+}
+
+func TestFileEventStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	// Small segments spread the appends over several files
+	options := FileEventStoreOptions{SegmentSize: 512}
+	eventStore := newFileTestStore(t, dir, options)
+	for version := 0; version < 10; version += 2 {
+		if err := eventStore.AppendToStream(ctx, "diary-1", version, newDiaryTestEvents(t, "diary-1", version, 2)); err != nil {
+			t.Fatalf("failed to append: %v", err)
+		}
+	}
+	if err := eventStore.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	reopened := newFileTestStore(t, dir, options)
+	assertVersions(t, reopened, "diary-1", 10)
+	assertReadAll(t, reopened, 8, 9, 10)
+}
+
+func TestFileEventStoreRecoversTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	eventStore := newFileTestStore(t, dir, FileEventStoreOptions{})
+	if err := eventStore.AppendToStream(ctx, "diary-1", ExpectedVersionNoStream, newDiaryTestEvents(t, "diary-1", 0, 2)); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+
+	eventStore.mu.RLock()
+	seg := eventStore.segments[len(eventStore.segments)-1]
+	path, complete := seg.path, seg.size
+	eventStore.mu.RUnlock()
+
+	if err := eventStore.AppendToStream(ctx, "diary-1", 2, newDiaryTestEvents(t, "diary-1", 2, 1)); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	if err := eventStore.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	// Cut the last record in half, as a crash during the write would
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat segment: %v", err)
+	}
+	if err := os.Truncate(path, complete+(info.Size()-complete)/2); err != nil {
+		t.Fatalf("failed to truncate segment: %v", err)
+	}
+
+	reopened := newFileTestStore(t, dir, FileEventStoreOptions{})
+	assertVersions(t, reopened, "diary-1", 2)
+	assertReadAll(t, reopened, 0, 1, 2)
+
+	info, err = os.Stat(path)
+	if err != nil || info.Size() != complete {
+		t.Fatalf("expected the torn record to be truncated to %d bytes, got %v (%v)", complete, info.Size(), err)
+	}
+
+	// The stream continues at the version and position of the lost append
+	if err := reopened.AppendToStream(ctx, "diary-1", 2, newDiaryTestEvents(t, "diary-1", 2, 1)); err != nil {
+		t.Fatalf("failed to append after recovery: %v", err)
+	}
+	assertVersions(t, reopened, "diary-1", 3)
+	assertReadAll(t, reopened, 2, 3)
+}
+
+func TestFileEventStoreCloseTwice(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, options := range []FileEventStoreOptions{
+		{Fsync: FsyncAlways},
+		{Fsync: FsyncInterval, FsyncInterval: time.Millisecond},
+	} {
+		eventStore, err := NewFileEventStore(dir, options)
+		if err != nil {
+			t.Fatalf("failed to open file event store: %v", err)
+		}
+
+		if err := eventStore.Close(); err != nil {
+			t.Fatalf("failed to close: %v", err)
+		}
+		if err := eventStore.Close(); err != nil {
+			t.Fatalf("expected a second Close to do nothing, got %v", err)
+		}
+
+		err = eventStore.AppendToStream(context.Background(), "diary-1", ExpectedVersionAny, []*events.Event{newDiaryTestEvent(t, "diary-1", 1)})
+		if err == nil {
+			t.Fatal("expected appending to a closed store to fail")
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// recordHeaderSize is the size of the length and checksum preceding each record
+const recordHeaderSize = 8
+
+// segmentExtension is the file extension of log segments
+const segmentExtension = ".log"
+
+// crcTable is the Castagnoli table used to checksum records
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// errTornRecord is returned when a record is incomplete or fails its checksum
+var errTornRecord = errors.New("torn record")
+
+// segment is a log file holding records for positions starting at base
+type segment struct {
+	base uint64
+	path string
+	file *os.File
+	size int64
+}
+
+// segmentPath returns the path of the segment starting at a position
+func segmentPath(dir string, base uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", base, segmentExtension))
+}
+
+// openSegments opens the segments of a directory ordered by base position
+func openSegments(dir string) ([]*segment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []*segment
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExtension) {
+			continue
+		}
+
+		base, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExtension), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		seg, err := openSegment(dir, base)
+		if err != nil {
+			closeSegments(segments)
+			return nil, err
+		}
+		segments = append(segments, seg)
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].base < segments[j].base
+	})
+
+	return segments, nil
+}
+
+// syncDir flushes a directory to disk, making the files created in it durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// openSegment opens or creates the segment starting at a position
+func openSegment(dir string, base uint64) (*segment, error) {
+	path := segmentPath(dir, base)
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &segment{
+		base: base,
+		path: path,
+		file: file,
+		size: info.Size(),
+	}, nil
+}
+
+// closeSegments closes the files of all segments
+func closeSegments(segments []*segment) error {
+	var firstErr error
+	for _, seg := range segments {
+		if err := seg.file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// encodeRecord frames data with its length and checksum
+func encodeRecord(data []byte) []byte {
+	record := make([]byte, recordHeaderSize+len(data))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(data, crcTable))
+	copy(record[recordHeaderSize:], data)
+	return record
+}
+
+// readRecord reads the record at an offset of a file of the given size and returns its data and
+// the offset of the next record. It returns io.EOF at the end of the file and errTornRecord for
+// an incomplete or corrupt record.
+func readRecord(file io.ReaderAt, size, offset int64) ([]byte, int64, error) {
+	header := make([]byte, recordHeaderSize)
+	n, err := file.ReadAt(header, offset)
+	if n == 0 && errors.Is(err, io.EOF) {
+		return nil, offset, io.EOF
+	}
+	if n < recordHeaderSize {
+		if err == nil || errors.Is(err, io.EOF) {
+			return nil, offset, errTornRecord
+		}
+		return nil, offset, err
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	checksum := binary.BigEndian.Uint32(header[4:8])
+
+	// A torn header may hold any length, check it against the file before allocating
+	if offset+recordHeaderSize+int64(length) > size {
+		return nil, offset, errTornRecord
+	}
+
+	data := make([]byte, length)
+	n, err = file.ReadAt(data, offset+recordHeaderSize)
+	if n < int(length) {
+		if err == nil || errors.Is(err, io.EOF) {
+			return nil, offset, errTornRecord
+		}
+		return nil, offset, err
+	}
+
+	if crc32.Checksum(data, crcTable) != checksum {
+		return nil, offset, errTornRecord
+	}
+
+	return data, offset + recordHeaderSize + int64(length), nil
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"sync"
+)
+
+// dataKeySize is the size of the AES-256 data keys
+const dataKeySize = 32
+
+// KeyStore holds the data keys used to encrypt the personal data of each subject
+type KeyStore interface {
+	// GetOrCreateKey returns the data key of a subject, creating it if the subject has none
+	GetOrCreateKey(ctx context.Context, subjectID string) ([]byte, error)
+
+	// GetKey returns the data key of a subject, or ErrKeyNotFound if it has none or it was deleted
+	GetKey(ctx context.Context, subjectID string) ([]byte, error)
+
+	// DeleteKey deletes the data key of a subject, so its encrypted data can no longer be read
+	DeleteKey(ctx context.Context, subjectID string) error
+}
+
+// MemoryKeyStore is an in-memory implementation of KeyStore
+// This is mainly for testing and development purposes
+type MemoryKeyStore struct {
+	mu      sync.RWMutex
+	keys    map[string][]byte
+	deleted map[string]bool // subjects whose keys were deleted, never given a new key
+}
+
+// NewMemoryKeyStore creates a new in-memory key store
+func NewMemoryKeyStore() *MemoryKeyStore {
+	return &MemoryKeyStore{
+		keys:    make(map[string][]byte),
+		deleted: make(map[string]bool),
+	}
+}
+
+// GetOrCreateKey returns the data key of a subject, creating it if the subject has none.
+// A subject whose key was deleted is not given a new one.
+func (m *MemoryKeyStore) GetOrCreateKey(ctx context.Context, subjectID string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if key, ok := m.keys[subjectID]; ok {
+		return key, nil
+	}
+
+	if m.deleted[subjectID] {
+		return nil, ErrKeyNotFound
+	}
+
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, NewEventStoreError(ErrCodeStorage, "failed to generate data key", err)
+	}
+
+	m.keys[subjectID] = key
+	return key, nil
+}
+
+// GetKey returns the data key of a subject
+func (m *MemoryKeyStore) GetKey(ctx context.Context, subjectID string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	key, ok := m.keys[subjectID]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+
+	return key, nil
+}
+
+// DeleteKey deletes the data key of a subject
+func (m *MemoryKeyStore) DeleteKey(ctx context.Context, subjectID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.keys, subjectID)
+	m.deleted[subjectID] = true
+	return nil
+}
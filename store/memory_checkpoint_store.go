@@ -0,0 +1,45 @@
+package store
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryCheckpointStore is an in-memory implementation of CheckpointStore
+// This is mainly for testing and development purposes
+type MemoryCheckpointStore struct {
+	mu          sync.RWMutex
+	checkpoints map[string]uint64 // consumer name -> position
+}
+
+// NewMemoryCheckpointStore creates a new in-memory checkpoint store
+func NewMemoryCheckpointStore() *MemoryCheckpointStore {
+	return &MemoryCheckpointStore{
+		checkpoints: make(map[string]uint64),
+	}
+}
+
+// SaveCheckpoint records the position of a consumer
+func (m *MemoryCheckpointStore) SaveCheckpoint(ctx context.Context, name string, position uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.checkpoints[name] = position
+	return nil
+}
+
+// GetCheckpoint returns the position of a consumer, or 0 if it has none
+func (m *MemoryCheckpointStore) GetCheckpoint(ctx context.Context, name string) (uint64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.checkpoints[name], nil
+}
+
+// Clear clears all checkpoints from the store (mainly for testing)
+func (m *MemoryCheckpointStore) Clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.checkpoints = make(map[string]uint64)
+}
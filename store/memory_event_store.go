@@ -6,6 +6,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/kegazani/metachat-event-sourcing/bus"
 	"github.com/kegazani/metachat-event-sourcing/events"
 )
 
@@ -15,6 +16,7 @@ type MemoryEventStore struct {
 	mu     sync.RWMutex
 	events []*events.Event
 	index  map[string][]int // aggregateID -> event indices
+	notify chan struct{}    // closed and replaced whenever events are appended
 }
 
 // NewMemoryEventStore creates a new in-memory event store
@@ -22,32 +24,50 @@ func NewMemoryEventStore() *MemoryEventStore {
 	return &MemoryEventStore{
 		events: make([]*events.Event, 0),
 		index:  make(map[string][]int),
+		notify: make(chan struct{}),
 	}
 }
 
 // SaveEvents saves a batch of events to the store
 func (m *MemoryEventStore) SaveEvents(ctx context.Context, eventList []*events.Event) error {
+	return saveEventsByAggregate(ctx, m, eventList)
+}
+
+// AppendToStream appends events to an aggregate stream at the expected version
+func (m *MemoryEventStore) AppendToStream(ctx context.Context, aggregateID string, expectedVersion int, eventList []*events.Event) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	for _, event := range eventList {
-		// Check for version conflicts
-		for _, existingEvent := range m.events {
-			if existingEvent.AggregateID == event.AggregateID && existingEvent.Version == event.Version {
-				return ErrVersionConflict
-			}
-		}
+	if err := prepareAppend(aggregateID, m.currentVersion(aggregateID), expectedVersion, eventList); err != nil {
+		return err
+	}
 
+	for _, event := range eventList {
 		// Add event to the store
 		m.events = append(m.events, event)
+		event.Position = uint64(len(m.events))
 
 		// Update index
 		m.index[event.AggregateID] = append(m.index[event.AggregateID], len(m.events)-1)
 	}
 
+	// Wake up subscribers waiting for new events
+	close(m.notify)
+	m.notify = make(chan struct{})
+
 	return nil
 }
 
+// currentVersion returns the latest version of an aggregate stream, the caller must hold the lock
+func (m *MemoryEventStore) currentVersion(aggregateID string) int {
+	indices := m.index[aggregateID]
+	if len(indices) == 0 {
+		return 0
+	}
+
+	return m.events[indices[len(indices)-1]].Version
+}
+
 // GetEventsByAggregateID retrieves all events for a specific aggregate
 func (m *MemoryEventStore) GetEventsByAggregateID(ctx context.Context, aggregateID string) ([]*events.Event, error) {
 	m.mu.RLock()
@@ -86,6 +106,21 @@ func (m *MemoryEventStore) GetEventsByType(ctx context.Context, eventType events
 	return result, nil
 }
 
+// GetEventsByAggregateType retrieves all events of aggregates of a specific type
+func (m *MemoryEventStore) GetEventsByAggregateType(ctx context.Context, aggregateType events.AggregateType) ([]*events.Event, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make([]*events.Event, 0)
+	for _, event := range m.events {
+		if event.AggregateType == aggregateType {
+			result = append(result, event)
+		}
+	}
+
+	return result, nil
+}
+
 // GetEventsByAggregateIDAndVersion retrieves events for an aggregate up to a specific version
 func (m *MemoryEventStore) GetEventsByAggregateIDAndVersion(ctx context.Context, aggregateID string, version int) ([]*events.Event, error) {
 	m.mu.RLock()
@@ -112,6 +147,32 @@ func (m *MemoryEventStore) GetEventsByAggregateIDAndVersion(ctx context.Context,
 	return result, nil
 }
 
+// GetEventsByAggregateIDAfterVersion retrieves events for an aggregate after a specific version
+func (m *MemoryEventStore) GetEventsByAggregateIDAfterVersion(ctx context.Context, aggregateID string, version int) ([]*events.Event, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	indices, ok := m.index[aggregateID]
+	if !ok {
+		return []*events.Event{}, nil
+	}
+
+	result := make([]*events.Event, 0, len(indices))
+	for _, idx := range indices {
+		event := m.events[idx]
+		if event.Version > version {
+			result = append(result, event)
+		}
+	}
+
+	// Sort events by version
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Version < result[j].Version
+	})
+
+	return result, nil
+}
+
 // GetEventsByTimeRange retrieves events within a time range
 func (m *MemoryEventStore) GetEventsByTimeRange(ctx context.Context, startTime, endTime string) ([]*events.Event, error) {
 	m.mu.RLock()
@@ -137,6 +198,173 @@ func (m *MemoryEventStore) GetEventsByTimeRange(ctx context.Context, startTime,
 	return result, nil
 }
 
+// IterateEventsByType streams all events of a specific type
+func (m *MemoryEventStore) IterateEventsByType(ctx context.Context, eventType events.EventType, pageToken string) (EventIterator, error) {
+	return m.newIterator(pageToken, func(event *events.Event) bool {
+		return event.Type == eventType
+	})
+}
+
+// IterateEventsByTimeRange streams events within a time range
+func (m *MemoryEventStore) IterateEventsByTimeRange(ctx context.Context, startTime, endTime string, pageToken string) (EventIterator, error) {
+	start, err := time.Parse(time.RFC3339, startTime)
+	if err != nil {
+		return nil, err
+	}
+
+	end, err := time.Parse(time.RFC3339, endTime)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.newIterator(pageToken, func(event *events.Event) bool {
+		return event.Timestamp.After(start) && event.Timestamp.Before(end)
+	})
+}
+
+// newIterator creates an iterator over the events matching a predicate
+func (m *MemoryEventStore) newIterator(pageToken string, match func(*events.Event) bool) (EventIterator, error) {
+	token, err := decodePageToken(pageToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return &memoryEventIterator{
+		store: m,
+		match: match,
+		next:  int(token.Offset),
+	}, nil
+}
+
+// memoryEventIterator scans the event log lazily, taking the read lock for each step
+type memoryEventIterator struct {
+	store   *MemoryEventStore
+	match   func(*events.Event) bool
+	next    int // index of the next event to examine
+	current *events.Event
+}
+
+// Next advances to the next matching event
+func (it *memoryEventIterator) Next() bool {
+	it.store.mu.RLock()
+	defer it.store.mu.RUnlock()
+
+	for it.next < len(it.store.events) {
+		event := it.store.events[it.next]
+		it.next++
+
+		if it.match(event) {
+			it.current = event
+			return true
+		}
+	}
+
+	it.current = nil
+	return false
+}
+
+// Event returns the current event
+func (it *memoryEventIterator) Event() *events.Event {
+	return it.current
+}
+
+// Err always returns nil, scanning memory cannot fail
+func (it *memoryEventIterator) Err() error {
+	return nil
+}
+
+// PageToken returns a token resuming after the current event
+func (it *memoryEventIterator) PageToken() string {
+	return encodePageToken(nil, uint64(it.next))
+}
+
+// Close is a no-op for the memory iterator
+func (it *memoryEventIterator) Close() error {
+	return nil
+}
+
+// ReadAll retrieves events in global order after a position, the position of an event is its index plus one
+func (m *MemoryEventStore) ReadAll(ctx context.Context, fromPosition uint64, limit int) ([]*events.Event, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if fromPosition >= uint64(len(m.events)) {
+		return []*events.Event{}, nil
+	}
+
+	end := len(m.events)
+	if limit > 0 && int(fromPosition)+limit < end {
+		end = int(fromPosition) + limit
+	}
+
+	result := make([]*events.Event, end-int(fromPosition))
+	copy(result, m.events[fromPosition:end])
+
+	return result, nil
+}
+
+// Subscribe delivers matching events after a position, then waits for new ones
+func (m *MemoryEventStore) Subscribe(ctx context.Context, fromPosition uint64, filter SubscriptionFilter, handler bus.EventHandler) error {
+	position := fromPosition
+	for {
+		// Take the notification channel before reading so an append in between is not missed
+		m.mu.RLock()
+		notify := m.notify
+		m.mu.RUnlock()
+
+		batch, err := m.ReadAll(ctx, position, subscriptionBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, event := range batch {
+			if filter.Matches(event) {
+				if err := handler(ctx, event); err != nil {
+					return err
+				}
+			}
+			position = event.Position
+		}
+
+		if len(batch) > 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-notify:
+		}
+	}
+}
+
+// RedactStream removes payload fields from the events of an aggregate stream
+func (m *MemoryEventStore) RedactStream(ctx context.Context, aggregateID string, fields map[events.EventType][]string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	redacted := 0
+	for _, idx := range m.index[aggregateID] {
+		event := m.events[idx]
+
+		payload, changed, err := redactPayload(event.Payload, fields[event.Type])
+		if err != nil {
+			return redacted, err
+		}
+		if !changed {
+			continue
+		}
+
+		// Replace the event rather than modify it, readers may still hold it
+		redactedEvent := *event
+		redactedEvent.Payload = payload
+		m.events[idx] = &redactedEvent
+		redacted++
+	}
+
+	return redacted, nil
+}
+
 // Clear clears all events from the store (mainly for testing)
 func (m *MemoryEventStore) Clear() {
 	m.mu.Lock()
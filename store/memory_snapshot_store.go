@@ -0,0 +1,63 @@
+package store
+
+import (
+	"context"
+	"sync"
+)
+
+// MemorySnapshotStore is an in-memory implementation of SnapshotStore
+// This is mainly for testing and development purposes
+type MemorySnapshotStore struct {
+	mu        sync.RWMutex
+	snapshots map[string]*Snapshot // aggregateID -> latest snapshot
+}
+
+// NewMemorySnapshotStore creates a new in-memory snapshot store
+func NewMemorySnapshotStore() *MemorySnapshotStore {
+	return &MemorySnapshotStore{
+		snapshots: make(map[string]*Snapshot),
+	}
+}
+
+// SaveSnapshot saves a snapshot, keeping only the latest one per aggregate
+func (m *MemorySnapshotStore) SaveSnapshot(ctx context.Context, snapshot *Snapshot) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if existing, ok := m.snapshots[snapshot.AggregateID]; ok && existing.Version >= snapshot.Version {
+		return nil
+	}
+
+	m.snapshots[snapshot.AggregateID] = snapshot
+	return nil
+}
+
+// GetLatestSnapshot retrieves the most recent snapshot of an aggregate
+func (m *MemorySnapshotStore) GetLatestSnapshot(ctx context.Context, aggregateID string) (*Snapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	snapshot, ok := m.snapshots[aggregateID]
+	if !ok {
+		return nil, ErrSnapshotNotFound
+	}
+
+	return snapshot, nil
+}
+
+// DeleteSnapshots deletes the snapshot of an aggregate
+func (m *MemorySnapshotStore) DeleteSnapshots(ctx context.Context, aggregateID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.snapshots, aggregateID)
+	return nil
+}
+
+// Clear clears all snapshots from the store (mainly for testing)
+func (m *MemorySnapshotStore) Clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.snapshots = make(map[string]*Snapshot)
+}
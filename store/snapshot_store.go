@@ -0,0 +1,25 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Snapshot represents the serialized state of an aggregate at a specific version
+type Snapshot struct {
+	AggregateID string          `json:"aggregate_id"`
+	Version     int             `json:"version"`
+	State       json.RawMessage `json:"state"`
+	Timestamp   time.Time       `json:"timestamp"`
+}
+
+// SnapshotStore defines the interface for aggregate snapshot storage
+type SnapshotStore interface {
+	// SaveSnapshot saves a snapshot of an aggregate
+	SaveSnapshot(ctx context.Context, snapshot *Snapshot) error
+
+	// GetLatestSnapshot retrieves the most recent snapshot of an aggregate.
+	// It returns ErrSnapshotNotFound if the aggregate has no snapshot.
+	GetLatestSnapshot(ctx context.Context, aggregateID string) (*Snapshot, error)
+}
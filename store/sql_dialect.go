@@ -0,0 +1,167 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SQLDialect adapts SQLEventStore to a database
+type SQLDialect interface {
+	// Placeholder returns the bind parameter for the n-th argument of a query, starting at 1
+	Placeholder(n int) string
+
+	// SchemaStatements returns the statements creating the event table and its indexes
+	SchemaStatements() []string
+
+	// MigrationStatements returns the statements adding the columns missing
+	// from event tables created by earlier versions of the store
+	MigrationStatements() []string
+
+	// IsDuplicateColumn reports whether a migration failed because its column exists
+	IsDuplicateColumn(err error) bool
+
+	// IsUniqueViolation reports whether an error is a unique constraint violation
+	IsUniqueViolation(err error) bool
+
+	// AppendLockStatement returns the statement each append runs first in its
+	// transaction, holding a shared lock until it ends, or "" if appends commit
+	// in position order
+	AppendLockStatement() string
+
+	// AwaitAppendsStatement returns the statement taking the lock of
+	// AppendLockStatement exclusively until the end of its transaction, which
+	// waits for the appends in flight, or "" if appends commit in position order
+	AwaitAppendsStatement() string
+}
+
+// postgresAppendLockKey is the advisory lock key shared by appends and readers waiting for them
+const postgresAppendLockKey = 7236276847523561
+
+// PostgresDialect is the SQLDialect for PostgreSQL
+type PostgresDialect struct{}
+
+// Placeholder returns $n
+func (PostgresDialect) Placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// SchemaStatements returns the PostgreSQL schema
+func (PostgresDialect) SchemaStatements() []string {
+	return []string{
+		`CREATE TABLE IF NOT EXISTS events (
+			position BIGSERIAL PRIMARY KEY,
+			event_id TEXT NOT NULL,
+			aggregate_id TEXT NOT NULL,
+			aggregate_type TEXT NOT NULL,
+			version INTEGER NOT NULL,
+			event_type TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			metadata TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL,
+			schema_version INTEGER NOT NULL DEFAULT 1,
+			UNIQUE (aggregate_id, version)
+		)`,
+		`CREATE INDEX IF NOT EXISTS events_event_type_idx ON events (event_type, position)`,
+		`CREATE INDEX IF NOT EXISTS events_aggregate_type_idx ON events (aggregate_type, position)`,
+		`CREATE INDEX IF NOT EXISTS events_created_at_idx ON events (created_at)`,
+	}
+}
+
+// MigrationStatements returns the PostgreSQL migrations
+func (PostgresDialect) MigrationStatements() []string {
+	return []string{
+		`ALTER TABLE events ADD COLUMN IF NOT EXISTS schema_version INTEGER NOT NULL DEFAULT 1`,
+	}
+}
+
+// IsDuplicateColumn checks for SQLSTATE 42701
+func (PostgresDialect) IsDuplicateColumn(err error) bool {
+	var stateErr interface{ SQLState() string }
+	if errors.As(err, &stateErr) {
+		return stateErr.SQLState() == "42701"
+	}
+	return strings.Contains(err.Error(), "already exists")
+}
+
+// IsUniqueViolation checks for SQLSTATE 23505
+func (PostgresDialect) IsUniqueViolation(err error) bool {
+	// Both lib/pq and pgx errors expose their SQLSTATE
+	var stateErr interface{ SQLState() string }
+	if errors.As(err, &stateErr) {
+		return stateErr.SQLState() == "23505"
+	}
+	return strings.Contains(err.Error(), "duplicate key value violates unique constraint")
+}
+
+// AppendLockStatement takes the append advisory lock in shared mode. Sequence
+// values are assigned before transactions commit, so appends may commit out of
+// position order and rolled back appends leave unused positions.
+func (PostgresDialect) AppendLockStatement() string {
+	return fmt.Sprintf(`SELECT pg_advisory_xact_lock_shared(%d)`, postgresAppendLockKey)
+}
+
+// AwaitAppendsStatement takes the append advisory lock in exclusive mode
+func (PostgresDialect) AwaitAppendsStatement() string {
+	return fmt.Sprintf(`SELECT pg_advisory_xact_lock(%d)`, postgresAppendLockKey)
+}
+
+// SQLiteDialect is the SQLDialect for SQLite
+type SQLiteDialect struct{}
+
+// Placeholder returns ?
+func (SQLiteDialect) Placeholder(n int) string {
+	return "?"
+}
+
+// SchemaStatements returns the SQLite schema
+func (SQLiteDialect) SchemaStatements() []string {
+	return []string{
+		`CREATE TABLE IF NOT EXISTS events (
+			position INTEGER PRIMARY KEY AUTOINCREMENT,
+			event_id TEXT NOT NULL,
+			aggregate_id TEXT NOT NULL,
+			aggregate_type TEXT NOT NULL,
+			version INTEGER NOT NULL,
+			event_type TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			metadata TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			schema_version INTEGER NOT NULL DEFAULT 1,
+			UNIQUE (aggregate_id, version)
+		)`,
+		`CREATE INDEX IF NOT EXISTS events_event_type_idx ON events (event_type, position)`,
+		`CREATE INDEX IF NOT EXISTS events_aggregate_type_idx ON events (aggregate_type, position)`,
+		`CREATE INDEX IF NOT EXISTS events_created_at_idx ON events (created_at)`,
+	}
+}
+
+// MigrationStatements returns the SQLite migrations. SQLite has no ADD COLUMN
+// IF NOT EXISTS, existing columns are detected with IsDuplicateColumn.
+func (SQLiteDialect) MigrationStatements() []string {
+	return []string{
+		`ALTER TABLE events ADD COLUMN schema_version INTEGER NOT NULL DEFAULT 1`,
+	}
+}
+
+// IsDuplicateColumn checks for the SQLite duplicate column message
+func (SQLiteDialect) IsDuplicateColumn(err error) bool {
+	return strings.Contains(err.Error(), "duplicate column name")
+}
+
+// IsUniqueViolation checks for the SQLite unique constraint message
+func (SQLiteDialect) IsUniqueViolation(err error) bool {
+	return strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+// AppendLockStatement returns "". SQLite runs one write transaction at a time
+// and rolls AUTOINCREMENT back with it, so positions are committed in order.
+func (SQLiteDialect) AppendLockStatement() string {
+	return ""
+}
+
+// AwaitAppendsStatement returns "", SQLite appends commit in position order
+func (SQLiteDialect) AwaitAppendsStatement() string {
+	return ""
+}
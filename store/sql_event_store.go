@@ -0,0 +1,487 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kegazani/metachat-event-sourcing/bus"
+	"github.com/kegazani/metachat-event-sourcing/events"
+)
+
+// sqlEventColumns are the columns selected by every SQL event query, in scan order
+const sqlEventColumns = `position, event_id, aggregate_id, aggregate_type, version, event_type, payload, metadata, created_at, schema_version`
+
+// SQLEventStore is an EventStore on database/sql. The unique (aggregate_id, version)
+// constraint provides optimistic concurrency and the position column the global order.
+type SQLEventStore struct {
+	db      *sql.DB
+	dialect SQLDialect
+}
+
+// NewSQLEventStore creates a new SQL event store
+func NewSQLEventStore(db *sql.DB, dialect SQLDialect) *SQLEventStore {
+	return &SQLEventStore{
+		db:      db,
+		dialect: dialect,
+	}
+}
+
+// InitializeSchema creates the event table and its indexes if they do not
+// exist, and adds the columns missing from tables created by earlier versions
+func (s *SQLEventStore) InitializeSchema() error {
+	for _, statement := range s.dialect.SchemaStatements() {
+		if _, err := s.db.Exec(statement); err != nil {
+			return fmt.Errorf("failed to execute schema statement: %w", err)
+		}
+	}
+
+	for _, statement := range s.dialect.MigrationStatements() {
+		if _, err := s.db.Exec(statement); err != nil && !s.dialect.IsDuplicateColumn(err) {
+			return fmt.Errorf("failed to execute schema migration: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// SaveEvents saves a batch of events to the store
+func (s *SQLEventStore) SaveEvents(ctx context.Context, eventList []*events.Event) error {
+	return saveEventsByAggregate(ctx, s, eventList)
+}
+
+// AppendToStream appends events to an aggregate stream in one transaction.
+// A concurrent append of the same versions fails the unique constraint and
+// is reported as a version conflict.
+func (s *SQLEventStore) AppendToStream(ctx context.Context, aggregateID string, expectedVersion int, eventList []*events.Event) error {
+	if len(eventList) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return NewEventStoreError(ErrCodeStorage, "failed to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	// Readers waiting for the appends in flight wait for this one
+	if lock := s.dialect.AppendLockStatement(); lock != "" {
+		if _, err := tx.ExecContext(ctx, lock); err != nil {
+			return NewEventStoreError(ErrCodeStorage, "failed to lock appends", err)
+		}
+	}
+
+	var currentVersion int
+	err = tx.QueryRowContext(ctx,
+		s.rebind(`SELECT COALESCE(MAX(version), 0) FROM events WHERE aggregate_id = ?`),
+		aggregateID,
+	).Scan(&currentVersion)
+	if err != nil {
+		return NewEventStoreError(ErrCodeStorage, "failed to read stream version", err)
+	}
+
+	if err := prepareAppend(aggregateID, currentVersion, expectedVersion, eventList); err != nil {
+		return err
+	}
+
+	insert := s.rebind(`INSERT INTO events (event_id, aggregate_id, aggregate_type, version, event_type, payload, metadata, created_at, schema_version)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		 RETURNING position`)
+
+	positions := make([]uint64, len(eventList))
+	for i, event := range eventList {
+		metadataJSON, err := json.Marshal(event.Metadata)
+		if err != nil {
+			return NewEventStoreError(ErrCodeSerialization, "failed to marshal metadata", err)
+		}
+
+		eventID := event.ID
+		if eventID == "" {
+			eventID = uuid.New().String()
+		}
+
+		var position int64
+		err = tx.QueryRowContext(ctx, insert,
+			eventID,
+			aggregateID,
+			string(event.AggregateType),
+			event.Version,
+			string(event.Type),
+			string(event.Payload),
+			string(metadataJSON),
+			event.Timestamp.UTC(),
+			event.SchemaVersion,
+		).Scan(&position)
+		if err != nil {
+			if s.dialect.IsUniqueViolation(err) {
+				return ErrVersionConflict
+			}
+			return NewEventStoreError(ErrCodeStorage, "failed to save events", err)
+		}
+
+		positions[i] = uint64(position)
+	}
+
+	if err := tx.Commit(); err != nil {
+		if s.dialect.IsUniqueViolation(err) {
+			return ErrVersionConflict
+		}
+		return NewEventStoreError(ErrCodeStorage, "failed to commit events", err)
+	}
+
+	for i, event := range eventList {
+		event.Position = positions[i]
+	}
+
+	return nil
+}
+
+// GetEventsByAggregateID retrieves all events for a specific aggregate
+func (s *SQLEventStore) GetEventsByAggregateID(ctx context.Context, aggregateID string) ([]*events.Event, error) {
+	return s.queryEvents(ctx,
+		`SELECT `+sqlEventColumns+`
+		 FROM events
+		 WHERE aggregate_id = ?
+		 ORDER BY version`,
+		aggregateID,
+	)
+}
+
+// GetEventsByType retrieves all events of a specific type
+func (s *SQLEventStore) GetEventsByType(ctx context.Context, eventType events.EventType) ([]*events.Event, error) {
+	iterator, err := s.IterateEventsByType(ctx, eventType, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return collectEvents(iterator)
+}
+
+// GetEventsByAggregateType retrieves all events of aggregates of a specific type
+func (s *SQLEventStore) GetEventsByAggregateType(ctx context.Context, aggregateType events.AggregateType) ([]*events.Event, error) {
+	return s.queryEvents(ctx,
+		`SELECT `+sqlEventColumns+`
+		 FROM events
+		 WHERE aggregate_type = ?
+		 ORDER BY position`,
+		string(aggregateType),
+	)
+}
+
+// GetEventsByAggregateIDAndVersion retrieves events for an aggregate up to a specific version
+func (s *SQLEventStore) GetEventsByAggregateIDAndVersion(ctx context.Context, aggregateID string, version int) ([]*events.Event, error) {
+	return s.queryEvents(ctx,
+		`SELECT `+sqlEventColumns+`
+		 FROM events
+		 WHERE aggregate_id = ? AND version <= ?
+		 ORDER BY version`,
+		aggregateID,
+		version,
+	)
+}
+
+// GetEventsByAggregateIDAfterVersion retrieves events for an aggregate after a specific version
+func (s *SQLEventStore) GetEventsByAggregateIDAfterVersion(ctx context.Context, aggregateID string, version int) ([]*events.Event, error) {
+	return s.queryEvents(ctx,
+		`SELECT `+sqlEventColumns+`
+		 FROM events
+		 WHERE aggregate_id = ? AND version > ?
+		 ORDER BY version`,
+		aggregateID,
+		version,
+	)
+}
+
+// GetEventsByTimeRange retrieves events within a time range
+func (s *SQLEventStore) GetEventsByTimeRange(ctx context.Context, startTime, endTime string) ([]*events.Event, error) {
+	iterator, err := s.IterateEventsByTimeRange(ctx, startTime, endTime, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return collectEvents(iterator)
+}
+
+// IterateEventsByType streams all events of a specific type in global order
+func (s *SQLEventStore) IterateEventsByType(ctx context.Context, eventType events.EventType, pageToken string) (EventIterator, error) {
+	return s.newIterator(ctx, pageToken, `event_type = ?`, string(eventType))
+}
+
+// IterateEventsByTimeRange streams events within a time range in global order
+func (s *SQLEventStore) IterateEventsByTimeRange(ctx context.Context, startTime, endTime string, pageToken string) (EventIterator, error) {
+	start, err := time.Parse(time.RFC3339, startTime)
+	if err != nil {
+		return nil, NewEventStoreError(ErrCodeSerialization, "invalid start time", err)
+	}
+
+	end, err := time.Parse(time.RFC3339, endTime)
+	if err != nil {
+		return nil, NewEventStoreError(ErrCodeSerialization, "invalid end time", err)
+	}
+
+	return s.newIterator(ctx, pageToken, `created_at >= ? AND created_at <= ?`, start.UTC(), end.UTC())
+}
+
+// ReadAll retrieves events in global order after a position. The positions of
+// the events may have gaps: those of rolled back appends are never used, and on
+// PostgreSQL an append in flight may still commit at a position before the last
+// one read.
+func (s *SQLEventStore) ReadAll(ctx context.Context, fromPosition uint64, limit int) ([]*events.Event, error) {
+	query := `SELECT ` + sqlEventColumns + `
+		 FROM events
+		 WHERE position > ?
+		 ORDER BY position`
+	args := []interface{}{int64(fromPosition)}
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+
+	return s.queryEvents(ctx, query, args...)
+}
+
+// readAll implements ReadAll and returns the position up to which the read is
+// complete. A gap is either an append in flight or the position of a rolled
+// back append: when a read has gaps it waits for the appends in flight, which
+// briefly holds back new appends, and reads again, so the gaps left are unused.
+func (s *SQLEventStore) readAll(ctx context.Context, fromPosition uint64, limit int) ([]*events.Event, uint64, error) {
+	eventList, err := s.ReadAll(ctx, fromPosition, limit)
+	if err != nil || len(eventList) == 0 {
+		return eventList, fromPosition, err
+	}
+
+	last := eventList[len(eventList)-1].Position
+	if last-fromPosition == uint64(len(eventList)) || s.dialect.AwaitAppendsStatement() == "" {
+		return eventList, last, nil
+	}
+
+	if err := s.awaitAppends(ctx); err != nil {
+		return nil, 0, err
+	}
+
+	eventList, err = s.ReadAll(ctx, fromPosition, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Events filling the gaps may push the last ones past the limit
+	checked := last
+	if limit > 0 && len(eventList) == limit {
+		checked = min(checked, eventList[len(eventList)-1].Position)
+	}
+	return eventList, checked, nil
+}
+
+// awaitAppends waits until the appends in flight are committed or rolled back
+func (s *SQLEventStore) awaitAppends(ctx context.Context) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return NewEventStoreError(ErrCodeStorage, "failed to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, s.dialect.AwaitAppendsStatement()); err != nil {
+		return NewEventStoreError(ErrCodeStorage, "failed to wait for appends", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return NewEventStoreError(ErrCodeStorage, "failed to wait for appends", err)
+	}
+	return nil
+}
+
+// Subscribe polls the global log for matching events after a position.
+// Delivery only pauses at positions an append in flight may still commit,
+// positions left unused by rolled back appends are passed over.
+func (s *SQLEventStore) Subscribe(ctx context.Context, fromPosition uint64, filter SubscriptionFilter, handler bus.EventHandler) error {
+	return pollSubscription(ctx, s.readAll, fromPosition, filter, handler)
+}
+
+// RedactStream removes payload fields from the events of an aggregate stream in one transaction
+func (s *SQLEventStore) RedactStream(ctx context.Context, aggregateID string, fields map[events.EventType][]string) (int, error) {
+	eventList, err := s.GetEventsByAggregateID(ctx, aggregateID)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, NewEventStoreError(ErrCodeStorage, "failed to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	update := s.rebind(`UPDATE events SET payload = ? WHERE position = ?`)
+
+	redacted := 0
+	for _, event := range eventList {
+		payload, changed, err := redactPayload(event.Payload, fields[event.Type])
+		if err != nil {
+			return 0, err
+		}
+		if !changed {
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, update, string(payload), int64(event.Position)); err != nil {
+			return 0, NewEventStoreError(ErrCodeStorage, "failed to redact event", err)
+		}
+		redacted++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, NewEventStoreError(ErrCodeStorage, "failed to commit redaction", err)
+	}
+
+	return redacted, nil
+}
+
+// rebind replaces the ? placeholders of a query with those of the dialect
+func (s *SQLEventStore) rebind(query string) string {
+	var builder strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			builder.WriteString(s.dialect.Placeholder(n))
+			continue
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
+
+// queryEvents runs a query selecting sqlEventColumns and scans all rows
+func (s *SQLEventStore) queryEvents(ctx context.Context, query string, args ...interface{}) ([]*events.Event, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return nil, NewEventStoreError(ErrCodeStorage, "failed to retrieve events", err)
+	}
+	defer rows.Close()
+
+	eventList := make([]*events.Event, 0)
+	for rows.Next() {
+		var position int64
+		var eventID, aggregateID, aggregateType, eventType, payload, metadata string
+		var version, schemaVersion int
+		var createdAt time.Time
+
+		if err := rows.Scan(&position, &eventID, &aggregateID, &aggregateType, &version, &eventType, &payload, &metadata, &createdAt, &schemaVersion); err != nil {
+			return nil, NewEventStoreError(ErrCodeStorage, "failed to scan event", err)
+		}
+
+		var metadataMap map[string]interface{}
+		if err := json.Unmarshal([]byte(metadata), &metadataMap); err != nil {
+			metadataMap = make(map[string]interface{})
+		}
+
+		eventList = append(eventList, &events.Event{
+			ID:            eventID,
+			Type:          events.EventType(eventType),
+			AggregateID:   aggregateID,
+			AggregateType: events.AggregateType(aggregateType),
+			Version:       version,
+			Timestamp:     createdAt,
+			Payload:       json.RawMessage(payload),
+			Metadata:      metadataMap,
+			SchemaVersion: schemaVersion,
+			Position:      uint64(position),
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, NewEventStoreError(ErrCodeStorage, "failed to retrieve events", err)
+	}
+
+	return eventList, nil
+}
+
+// newIterator creates an iterator over the events matching a condition, paging by position
+func (s *SQLEventStore) newIterator(ctx context.Context, pageToken string, condition string, args ...interface{}) (EventIterator, error) {
+	token, err := decodePageToken(pageToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqlEventIterator{
+		store:     s,
+		ctx:       ctx,
+		condition: condition,
+		args:      args,
+		after:     token.Offset,
+		index:     -1,
+	}, nil
+}
+
+// sqlEventIterator pages through matching events with keyset pagination on the
+// global position, so its token is the position of the current event
+type sqlEventIterator struct {
+	store     *SQLEventStore
+	ctx       context.Context
+	condition string
+	args      []interface{}
+	after     uint64 // position of the last event of the current page
+	page      []*events.Event
+	index     int
+	done      bool
+	err       error
+}
+
+// Next advances to the next event, fetching the next page when needed
+func (it *sqlEventIterator) Next() bool {
+	for {
+		if it.index+1 < len(it.page) {
+			it.index++
+			return true
+		}
+
+		if it.done || it.err != nil {
+			return false
+		}
+
+		if it.index >= 0 {
+			it.after = it.page[len(it.page)-1].Position
+		}
+
+		it.page, it.err = it.store.queryEvents(it.ctx,
+			`SELECT `+sqlEventColumns+`
+			 FROM events
+			 WHERE `+it.condition+` AND position > ?
+			 ORDER BY position
+			 LIMIT ?`,
+			append(append([]interface{}{}, it.args...), int64(it.after), iteratorPageSize)...,
+		)
+		it.index = -1
+		it.done = len(it.page) < iteratorPageSize
+	}
+}
+
+// Event returns the current event
+func (it *sqlEventIterator) Event() *events.Event {
+	if it.index < 0 || it.index >= len(it.page) {
+		return nil
+	}
+	return it.page[it.index]
+}
+
+// Err returns the error that stopped the iteration
+func (it *sqlEventIterator) Err() error {
+	return it.err
+}
+
+// PageToken returns a token resuming after the current event
+func (it *sqlEventIterator) PageToken() string {
+	if event := it.Event(); event != nil {
+		return encodePageToken(nil, event.Position)
+	}
+	return encodePageToken(nil, it.after)
+}
+
+// Close releases the current page
+func (it *sqlEventIterator) Close() error {
+	it.page = nil
+	return nil
+}
@@ -0,0 +1,143 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kegazani/metachat-event-sourcing/events"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// newSQLiteTestStore creates a SQL event store on a new SQLite database
+func newSQLiteTestStore(t *testing.T) (*SQLEventStore, *sql.DB) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "events.db")+"?_busy_timeout=5000")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	eventStore := NewSQLEventStore(db, SQLiteDialect{})
+	if err := eventStore.InitializeSchema(); err != nil {
+		t.Fatalf("failed to initialize schema: %v", err)
+	}
+	return eventStore, db
+}
+
+func TestSQLEventStoreAppendAndRead(t *testing.T) {
+	eventStore, _ := newSQLiteTestStore(t)
+	ctx := context.Background()
+
+	if err := eventStore.AppendToStream(ctx, "diary-1", ExpectedVersionNoStream, newDiaryTestEvents(t, "diary-1", 0, 2)); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	if err := eventStore.AppendToStream(ctx, "diary-2", ExpectedVersionNoStream, newDiaryTestEvents(t, "diary-2", 0, 1)); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+
+	appended := newDiaryTestEvents(t, "diary-1", 0, 1)
+	appended[0].Version = 0
+	if err := eventStore.AppendToStream(ctx, "diary-1", ExpectedVersionAny, appended); err != nil {
+		t.Fatalf("failed to append at any version: %v", err)
+	}
+	if appended[0].Version != 3 || appended[0].Position != 4 {
+		t.Fatalf("expected version 3 at position 4, got version %d at %d", appended[0].Version, appended[0].Position)
+	}
+
+	assertVersions(t, eventStore, "diary-1", 3)
+	assertReadAll(t, eventStore, 0, 1, 2, 3, 4)
+	assertReadAll(t, eventStore, 3, 4)
+
+	diaryEvents, err := eventStore.GetEventsByAggregateType(ctx, events.DiaryAggregateType)
+	if err != nil || len(diaryEvents) != 4 {
+		t.Fatalf("expected 4 diary events, got %d (%v)", len(diaryEvents), err)
+	}
+}
+
+func TestSQLEventStoreVersionConflict(t *testing.T) {
+	eventStore, _ := newSQLiteTestStore(t)
+	ctx := context.Background()
+
+	if err := eventStore.AppendToStream(ctx, "diary-1", ExpectedVersionNoStream, newDiaryTestEvents(t, "diary-1", 0, 1)); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+
+	err := eventStore.AppendToStream(ctx, "diary-1", ExpectedVersionNoStream, newDiaryTestEvents(t, "diary-1", 0, 1))
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("expected ErrVersionConflict, got %v", err)
+	}
+
+	err = eventStore.AppendToStream(ctx, "diary-1", 1, newDiaryTestEvents(t, "diary-1", 0, 1))
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("expected ErrVersionConflict for an event at the wrong version, got %v", err)
+	}
+
+	assertVersions(t, eventStore, "diary-1", 1)
+	assertReadAll(t, eventStore, 0, 1)
+}
+
+func TestSQLEventStoreSubscribe(t *testing.T) {
+	eventStore, db := newSQLiteTestStore(t)
+	ctx := context.Background()
+
+	if err := eventStore.AppendToStream(ctx, "diary-1", ExpectedVersionNoStream, newDiaryTestEvents(t, "diary-1", 0, 2)); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+
+	// A conflicting append must neither stall the subscription nor be reported as a gap
+	err := eventStore.AppendToStream(ctx, "diary-1", ExpectedVersionNoStream, newDiaryTestEvents(t, "diary-1", 0, 1))
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("expected ErrVersionConflict, got %v", err)
+	}
+
+	// Leave a position unused, as a rolled back append does on other databases
+	if _, err := db.Exec(`UPDATE sqlite_sequence SET seq = seq + 1 WHERE name = 'events'`); err != nil {
+		t.Fatalf("failed to skip a position: %v", err)
+	}
+
+	if err := eventStore.AppendToStream(ctx, "diary-1", 2, newDiaryTestEvents(t, "diary-1", 2, 1)); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	if err := eventStore.AppendToStream(ctx, "diary-2", ExpectedVersionNoStream, newDiaryTestEvents(t, "diary-2", 0, 1)); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+
+	subscribe := func(ctx context.Context, handler func(ctx context.Context, event *events.Event) error) error {
+		filter := SubscriptionFilter{EventTypes: []events.EventType{events.DiaryEntryUpdatedEvent}}
+		return eventStore.Subscribe(ctx, 0, filter, handler)
+	}
+
+	// The gap timeout is far longer than the test waits
+	positions, gaps := collectSubscription(t, subscribe, 2, subscriptionGapTimeout/2)
+	if len(positions) != 2 || positions[0] != 2 || positions[1] != 4 {
+		t.Fatalf("expected the updates at positions 2 and 4, got %v", positions)
+	}
+	if len(gaps) != 0 {
+		t.Fatalf("expected no reported gap, got %v", gaps)
+	}
+}
+
+func TestSQLEventStoreSubscribeFollowsNewEvents(t *testing.T) {
+	eventStore, _ := newSQLiteTestStore(t)
+	ctx := context.Background()
+
+	eventList := newDiaryTestEvents(t, "diary-1", 0, 2)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		eventStore.AppendToStream(ctx, "diary-1", ExpectedVersionNoStream, eventList)
+	}()
+
+	subscribe := func(ctx context.Context, handler func(ctx context.Context, event *events.Event) error) error {
+		return eventStore.Subscribe(ctx, 0, SubscriptionFilter{}, handler)
+	}
+
+	positions, _ := collectSubscription(t, subscribe, 2, 5*time.Second)
+	if len(positions) != 2 || positions[0] != 1 || positions[1] != 2 {
+		t.Fatalf("expected positions 1 and 2, got %v", positions)
+	}
+}
@@ -0,0 +1,175 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/kegazani/metachat-event-sourcing/bus"
+	"github.com/kegazani/metachat-event-sourcing/events"
+)
+
+// subscriptionBatchSize is the number of events read per round trip while catching up
+const subscriptionBatchSize = 500
+
+// subscriptionPollInterval is how often polling subscriptions look for new events once caught up
+const subscriptionPollInterval = time.Second
+
+// subscriptionGapTimeout is how long a polling subscription waits for a missing global
+// position to be written before assuming its append failed and skipping it
+const subscriptionGapTimeout = 10 * time.Second
+
+// SubscriptionFilter selects the events delivered to a subscription.
+// Empty fields match every event.
+type SubscriptionFilter struct {
+	EventTypes     []events.EventType
+	AggregateTypes []events.AggregateType
+}
+
+// Matches reports whether an event passes the filter
+func (f SubscriptionFilter) Matches(event *events.Event) bool {
+	if len(f.EventTypes) > 0 && !containsEventType(f.EventTypes, event.Type) {
+		return false
+	}
+
+	if len(f.AggregateTypes) > 0 && !containsAggregateType(f.AggregateTypes, event.AggregateType) {
+		return false
+	}
+
+	return true
+}
+
+// eventTypes returns the event types the filter can match, nil if it matches any type
+func (f SubscriptionFilter) eventTypes() []events.EventType {
+	if len(f.AggregateTypes) == 0 {
+		return f.EventTypes
+	}
+
+	var result []events.EventType
+	for _, aggregateType := range f.AggregateTypes {
+		for _, eventType := range events.EventTypesOf(aggregateType) {
+			if len(f.EventTypes) == 0 || containsEventType(f.EventTypes, eventType) {
+				result = append(result, eventType)
+			}
+		}
+	}
+	return result
+}
+
+// GapHandler is told of the global positions from first to last that a
+// polling subscription skipped because no event was committed at them within
+// the gap timeout. An event committed later at a skipped position is not
+// delivered to the subscription, so it must be recovered from the store.
+type GapHandler func(ctx context.Context, first, last uint64)
+
+// gapHandlerKey is the context key of the GapHandler of a subscription
+type gapHandlerKey struct{}
+
+// ContextWithGapHandler returns a context making the subscriptions started
+// with it report the positions they skip to handler
+func ContextWithGapHandler(ctx context.Context, handler GapHandler) context.Context {
+	return context.WithValue(ctx, gapHandlerKey{}, handler)
+}
+
+// gapHandlerFromContext returns the GapHandler of a context, nil if there is none
+func gapHandlerFromContext(ctx context.Context) GapHandler {
+	handler, _ := ctx.Value(gapHandlerKey{}).(GapHandler)
+	return handler
+}
+
+// EventSubscriber is implemented by event stores that can be tailed
+type EventSubscriber interface {
+	// Subscribe delivers the events stored after fromPosition that match the
+	// filter, first catching up with the history and then following new events.
+	// It blocks until the context is cancelled or the handler returns an error,
+	// which is returned; the position of the last handled event can be used to resume.
+	Subscribe(ctx context.Context, fromPosition uint64, filter SubscriptionFilter, handler bus.EventHandler) error
+}
+
+// checkedReader reads up to limit events in global order after fromPosition,
+// like ReadAll, and returns the position up to which the read is complete:
+// every position after fromPosition and up to it is either among the events
+// or known to hold none, such as the positions of failed appends. Positions
+// after it without an event may still be written by appends in flight.
+type checkedReader func(ctx context.Context, fromPosition uint64, limit int) ([]*events.Event, uint64, error)
+
+// pollSubscription implements Subscribe for stores whose global positions may be
+// committed out of order, by polling readAll and pausing delivery at gaps. Only
+// positions readAll could not check pause delivery; gaps still open after
+// subscriptionGapTimeout are skipped and reported to the GapHandler of the context.
+func pollSubscription(ctx context.Context, readAll checkedReader, fromPosition uint64, filter SubscriptionFilter, handler bus.EventHandler) error {
+	position := fromPosition
+	onGap := gapHandlerFromContext(ctx)
+	var gapSince time.Time
+
+	ticker := time.NewTicker(subscriptionPollInterval)
+	defer ticker.Stop()
+
+	for {
+		batch, checked, err := readAll(ctx, position, subscriptionBatchSize)
+		if err != nil {
+			return err
+		}
+
+		delivered := 0
+		for _, event := range batch {
+			// Positions up to checked without an event are known to be unused
+			unknown := position + 1
+			if checked >= unknown {
+				unknown = checked + 1
+			}
+
+			if event.Position > unknown {
+				if gapSince.IsZero() {
+					gapSince = time.Now()
+				}
+				if time.Since(gapSince) < subscriptionGapTimeout {
+					break
+				}
+				if onGap != nil {
+					onGap(ctx, unknown, event.Position-1)
+				}
+			}
+			gapSince = time.Time{}
+
+			if filter.Matches(event) {
+				if err := handler(ctx, event); err != nil {
+					return err
+				}
+			}
+			position = event.Position
+			delivered++
+		}
+
+		if delivered == len(batch) && checked > position {
+			position = checked
+		}
+
+		if delivered == subscriptionBatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func containsEventType(eventTypes []events.EventType, eventType events.EventType) bool {
+	for _, t := range eventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAggregateType(aggregateTypes []events.AggregateType, aggregateType events.AggregateType) bool {
+	for _, t := range aggregateTypes {
+		if t == aggregateType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kegazani/metachat-event-sourcing/events"
+)
+
+// collectSubscription subscribes until the handler received count events or
+// the timeout expires, and returns the delivered positions and reported gaps
+func collectSubscription(t *testing.T, subscribe func(ctx context.Context, handler func(ctx context.Context, event *events.Event) error) error, count int, timeout time.Duration) ([]uint64, [][2]uint64) {
+	t.Helper()
+
+	var mu sync.Mutex
+	var positions []uint64
+	var gaps [][2]uint64
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ctx = ContextWithGapHandler(ctx, func(ctx context.Context, first, last uint64) {
+		mu.Lock()
+		defer mu.Unlock()
+		gaps = append(gaps, [2]uint64{first, last})
+	})
+
+	err := subscribe(ctx, func(ctx context.Context, event *events.Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		positions = append(positions, event.Position)
+		if len(positions) == count {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected subscription error: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	return positions, gaps
+}
+
+// fixedReader returns a checkedReader over events at the given positions,
+// reporting the read complete up to checked
+func fixedReader(checked uint64, positions ...uint64) checkedReader {
+	return func(ctx context.Context, fromPosition uint64, limit int) ([]*events.Event, uint64, error) {
+		var result []*events.Event
+		for _, position := range positions {
+			if position > fromPosition {
+				result = append(result, &events.Event{Position: position})
+			}
+		}
+		return result, max(checked, fromPosition), nil
+	}
+}
+
+func TestPollSubscriptionPassesOverCheckedGaps(t *testing.T) {
+	subscribe := func(ctx context.Context, handler func(ctx context.Context, event *events.Event) error) error {
+		return pollSubscription(ctx, fixedReader(5, 1, 2, 5), 0, SubscriptionFilter{}, handler)
+	}
+
+	positions, gaps := collectSubscription(t, subscribe, 3, time.Second)
+	if len(positions) != 3 || positions[2] != 5 {
+		t.Fatalf("expected positions 1, 2 and 5 without waiting, got %v", positions)
+	}
+	if len(gaps) != 0 {
+		t.Fatalf("expected no reported gap, got %v", gaps)
+	}
+}
+
+func TestPollSubscriptionWaitsAtUncheckedGaps(t *testing.T) {
+	subscribe := func(ctx context.Context, handler func(ctx context.Context, event *events.Event) error) error {
+		return pollSubscription(ctx, fixedReader(2, 1, 2, 5), 0, SubscriptionFilter{}, handler)
+	}
+
+	// Positions 3 and 4 may still be committed, so 5 is held back until the gap timeout
+	positions, gaps := collectSubscription(t, subscribe, 3, 100*time.Millisecond)
+	if len(positions) != 2 || positions[1] != 2 {
+		t.Fatalf("expected delivery to pause after position 2, got %v", positions)
+	}
+	if len(gaps) != 0 {
+		t.Fatalf("expected no reported gap before the timeout, got %v", gaps)
+	}
+}
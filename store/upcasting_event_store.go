@@ -0,0 +1,55 @@
+package store
+
+import (
+	"context"
+
+	"github.com/kegazani/metachat-event-sourcing/events"
+)
+
+// UpcastingEventStore is an EventStore decorator bringing the payload of every
+// event read to its latest schema version. It must wrap any decorator that
+// transforms payloads on read, such as EncryptingEventStore, so upcasters see
+// the decoded payloads.
+type UpcastingEventStore struct {
+	eventMapper
+	upcasters *events.UpcasterRegistry
+}
+
+// NewUpcastingEventStore creates an upcasting decorator, using events.DefaultUpcasters if upcasters is nil
+func NewUpcastingEventStore(eventStore EventStore, upcasters *events.UpcasterRegistry) *UpcastingEventStore {
+	if upcasters == nil {
+		upcasters = events.DefaultUpcasters
+	}
+
+	u := &UpcastingEventStore{
+		upcasters: upcasters,
+	}
+	u.eventMapper = eventMapper{eventStore: eventStore, mapEvent: u.upcast}
+	return u
+}
+
+// SaveEvents saves a batch of events to the store
+func (u *UpcastingEventStore) SaveEvents(ctx context.Context, eventList []*events.Event) error {
+	return saveEventsByAggregate(ctx, u, eventList)
+}
+
+// AppendToStream appends events to an aggregate stream, stamping events
+// without a schema version with the latest one of the registry
+func (u *UpcastingEventStore) AppendToStream(ctx context.Context, aggregateID string, expectedVersion int, eventList []*events.Event) error {
+	for _, event := range eventList {
+		if event.SchemaVersion == 0 {
+			event.SchemaVersion = u.upcasters.LatestVersion(event.Type)
+		}
+	}
+
+	return u.eventStore.AppendToStream(ctx, aggregateID, expectedVersion, eventList)
+}
+
+// upcast brings an event to the latest schema version
+func (u *UpcastingEventStore) upcast(ctx context.Context, event *events.Event) (*events.Event, error) {
+	upcasted, err := u.upcasters.Upcast(event)
+	if err != nil {
+		return nil, NewEventStoreError(ErrCodeSerialization, "failed to upcast event", err)
+	}
+	return upcasted, nil
+}
@@ -0,0 +1,42 @@
+package store
+
+import (
+	"context"
+
+	"github.com/kegazani/metachat-event-sourcing/events"
+)
+
+// ValidatingEventStore is an EventStore decorator rejecting events whose
+// payloads violate their validation rules, catching events built without
+// events.NewEvent. Events of types without a registered payload are accepted.
+type ValidatingEventStore struct {
+	eventMapper
+}
+
+// NewValidatingEventStore creates a validating decorator
+func NewValidatingEventStore(eventStore EventStore) *ValidatingEventStore {
+	return &ValidatingEventStore{
+		eventMapper: eventMapper{eventStore: eventStore, mapEvent: passEvent},
+	}
+}
+
+// SaveEvents saves a batch of events to the store
+func (v *ValidatingEventStore) SaveEvents(ctx context.Context, eventList []*events.Event) error {
+	return saveEventsByAggregate(ctx, v, eventList)
+}
+
+// AppendToStream validates every event before appending any of them
+func (v *ValidatingEventStore) AppendToStream(ctx context.Context, aggregateID string, expectedVersion int, eventList []*events.Event) error {
+	for _, event := range eventList {
+		if err := events.Validate(event); err != nil {
+			return NewEventStoreError(ErrCodeInvalidEvent, "invalid event "+event.ID, err)
+		}
+	}
+
+	return v.eventStore.AppendToStream(ctx, aggregateID, expectedVersion, eventList)
+}
+
+// passEvent returns an event unchanged
+func passEvent(ctx context.Context, event *events.Event) (*events.Event, error) {
+	return event, nil
+}